@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"azure-openai-proxy/internal/config"
 	"azure-openai-proxy/internal/handlers"
@@ -36,7 +42,7 @@ func main() {
 	}
 
 	// Setup logging
-	setupLogging(cfg.Logging)
+	logFile := setupLogging(cfg.Logging)
 
 	// Initialize storage
 	stateStore, err := storage.NewRedisStore("redis://localhost:6379", "")
@@ -54,6 +60,11 @@ func main() {
 	if err != nil {
 		logrus.Fatalf("Failed to initialize instance manager: %v", err)
 	}
+	instanceManager.SetRoutingConfig(cfg.Routing)
+	instanceManager.SetModelRegistry(cfg.Models)
+	if cfg.Admission.Enabled {
+		instanceManager.SetAdmissionConfig(cfg.Admission)
+	}
 
 	// Start health monitoring
 	go instanceManager.StartHealthMonitoring()
@@ -74,23 +85,119 @@ func main() {
 	router.Use(gin.Recovery())
 
 	// Initialize handlers
+	healthHandler := handlers.NewHealthHandler()
 	proxyHandler := handlers.NewProxyHandler(instanceManager)
 	adminHandler := handlers.NewAdminHandler(instanceManager)
 	statsHandler := handlers.NewStatsHandler(instanceManager)
 
+	// Optional exact-match response cache
+	var responseCache *storage.RedisCache
+	if cfg.Cache.Enabled {
+		responseCache, err = storage.NewRedisCache("redis://localhost:6379", "", cfg.Cache.GetMaxEntries())
+		if err != nil {
+			logrus.Fatalf("Failed to initialize response cache: %v", err)
+		}
+		proxyHandler.SetResponseCache(responseCache, cfg.Cache)
+	}
+	if cfg.Coalescing.Enabled {
+		proxyHandler.SetCoalescing(cfg.Coalescing)
+	}
+	if cfg.EmbeddingBatching.Enabled {
+		proxyHandler.SetEmbeddingBatching(cfg.EmbeddingBatching)
+	}
+	if cfg.Cache.Semantic.Enabled {
+		vectorIndex, err := newVectorIndex(cfg.Cache.Semantic, configStore)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize semantic cache: %v", err)
+		}
+		proxyHandler.SetSemanticCache(vectorIndex, cfg.Cache)
+		adminHandler.SetSemanticCache(vectorIndex)
+	}
+
 	// Setup routes
-	setupRoutes(router, proxyHandler, adminHandler, statsHandler)
+	setupRoutes(router, cfg, healthHandler, proxyHandler, adminHandler, statsHandler)
 
 	// Start server
 	address := fmt.Sprintf(":%d", cfg.Port)
-	logrus.Infof("Starting Azure OpenAI Proxy server on %s", address)
-	
-	if err := router.Run(address); err != nil {
-		logrus.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{
+		Addr:    address,
+		Handler: router,
+	}
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		logrus.Infof("Starting Azure OpenAI Proxy server on %s", address)
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	// Wait for a shutdown signal or a listener failure
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErrors:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatalf("Failed to start server: %v", err)
+		}
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Info("Shutdown signal received, draining")
+		shutdownServer(server, healthHandler, cfg.Server)
+	}
+
+	// Close realtime sessions (hijacked connections are not drained by
+	// Shutdown), then release upstream transports, rate limiters and stores
+	if err := proxyHandler.Close(); err != nil {
+		logrus.WithError(err).Warn("Failed to close Azure services")
+	}
+	if err := instanceManager.Close(); err != nil {
+		logrus.WithError(err).Warn("Failed to close instance manager")
 	}
+	if responseCache != nil {
+		responseCache.Close()
+	}
+
+	logrus.Info("Azure OpenAI Proxy stopped")
+
+	// Flush logs
+	if logFile != nil {
+		logFile.Sync()
+		logFile.Close()
+	}
+}
+
+// shutdownServer advertises draining, then stops accepting connections and
+// waits for in-flight requests and streams until the shutdown deadline
+func shutdownServer(server *http.Server, health *handlers.HealthHandler, cfg config.ServerConfig) {
+	// Let load balancers observe the draining status before the listener closes
+	health.SetDraining()
+	server.SetKeepAlivesEnabled(false)
+	if delay := cfg.GetDrainDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GetShutdownTimeout())
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("Shutdown deadline exceeded, closing remaining connections")
+		server.Close()
+	}
+}
+
+// newVectorIndex creates the semantic cache index in memory or in the SQLite database
+func newVectorIndex(cfg config.SemanticCacheConfig, configStore *storage.SQLiteStore) (storage.VectorIndex, error) {
+	if cfg.EmbeddingModel == "" {
+		return nil, fmt.Errorf("cache.semantic.embedding_model is required")
+	}
+	if cfg.Store == "sqlite" {
+		return configStore.VectorIndex(cfg.GetMaxEntries())
+	}
+	return storage.NewMemoryVectorIndex(cfg.GetMaxEntries()), nil
 }
 
-func setupLogging(cfg config.LoggingConfig) {
+// setupLogging configures logrus and returns the log file, if any, so it can
+// be flushed on shutdown
+func setupLogging(cfg config.LoggingConfig) *os.File {
 	// Set log level
 	level, err := logrus.ParseLevel(cfg.Level)
 	if err != nil {
@@ -114,23 +221,46 @@ func setupLogging(cfg config.LoggingConfig) {
 				logrus.Warnf("Failed to open log file: %v", err)
 			} else {
 				logrus.SetOutput(file)
+				return file
 			}
 		}
 	}
+
+	return nil
 }
 
-func setupRoutes(router *gin.Engine, proxy *handlers.ProxyHandler, admin *handlers.AdminHandler, stats *handlers.StatsHandler) {
+func setupRoutes(router *gin.Engine, cfg *config.AppConfig, health *handlers.HealthHandler, proxy *handlers.ProxyHandler, admin *handlers.AdminHandler, stats *handlers.StatsHandler) {
 	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy"})
-	})
+	router.GET("/health", health.Health)
 
 	// OpenAI API proxy routes
 	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(cfg.Clients))
 	{
 		v1.POST("/chat/completions", proxy.ChatCompletions)
 		v1.POST("/completions", proxy.Completions)
 		v1.POST("/embeddings", proxy.Embeddings)
+		v1.POST("/images/generations", proxy.ImageGenerations)
+		v1.POST("/audio/transcriptions", proxy.AudioTranscriptions)
+		v1.POST("/audio/translations", proxy.AudioTranslations)
+		v1.POST("/audio/speech", proxy.AudioSpeech)
+		v1.GET("/models", proxy.ListModels)
+		v1.GET("/models/:id", proxy.RetrieveModel)
+		v1.GET("/realtime", proxy.Realtime)
+		v1.POST("/responses", proxy.Responses)
+		v1.GET("/responses/:id", proxy.GetResponse)
+		v1.DELETE("/responses/:id", proxy.DeleteResponse)
+		v1.POST("/responses/:id/cancel", proxy.CancelResponse)
+		v1.GET("/responses/:id/input_items", proxy.ListResponseInputItems)
+		v1.POST("/files", proxy.UploadFile)
+		v1.GET("/files", proxy.ListFiles)
+		v1.GET("/files/:id", proxy.GetFile)
+		v1.DELETE("/files/:id", proxy.DeleteFile)
+		v1.GET("/files/:id/content", proxy.GetFileContent)
+		v1.POST("/batches", proxy.CreateBatch)
+		v1.GET("/batches", proxy.ListBatches)
+		v1.GET("/batches/:id", proxy.GetBatch)
+		v1.POST("/batches/:id/cancel", proxy.CancelBatch)
 	}
 
 	// Admin routes (with optional authentication)
@@ -143,8 +273,16 @@ func setupRoutes(router *gin.Engine, proxy *handlers.ProxyHandler, admin *handle
 		adminGroup.GET("/instances", admin.GetInstances)
 		adminGroup.GET("/instances/:name", admin.GetInstance)
 		adminGroup.POST("/instances/:name/reset", admin.ResetInstance)
+		adminGroup.POST("/instances/:name/drain", admin.DrainInstance)
+		adminGroup.GET("/instances/:name/drain", admin.GetDrainStatus)
+		adminGroup.DELETE("/instances/:name/drain", admin.ResumeInstance)
 		adminGroup.PUT("/instances/:name/config", admin.UpdateInstanceConfig)
 		adminGroup.GET("/config", admin.GetConfig)
+		adminGroup.GET("/batches", admin.GetBatches)
+		adminGroup.GET("/costs", admin.GetCosts)
+		adminGroup.GET("/cache/semantic", admin.GetSemanticCache)
+		adminGroup.DELETE("/cache/semantic", admin.InvalidateSemanticCache)
+		adminGroup.DELETE("/cache/semantic/:id", admin.DeleteSemanticCacheEntry)
 	}
 
 	// Stats routes
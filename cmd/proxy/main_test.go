@@ -1,19 +1,34 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 	
 	"azure-openai-proxy/internal/config"
 	"azure-openai-proxy/internal/handlers"
 	"azure-openai-proxy/internal/instance"
 	"azure-openai-proxy/internal/middleware"
+	"azure-openai-proxy/internal/storage"
 	
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pkoukk/tiktoken-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,6 +62,33 @@ func TestHealthEndpoint(t *testing.T) {
 	assert.Equal(t, "healthy", response["status"])
 }
 
+func TestHealthEndpointDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	
+	healthHandler := handlers.NewHealthHandler()
+	router.GET("/health", healthHandler.Health)
+	
+	// Healthy before shutdown starts
+	req, _ := http.NewRequest("GET", "/health", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	
+	// Draining once shutdown begins so load balancers stop routing here
+	healthHandler.SetDraining()
+	
+	req, _ = http.NewRequest("GET", "/health", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 503, resp.Code)
+	
+	var response map[string]interface{}
+	err := json.Unmarshal(resp.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "draining", response["status"])
+}
+
 func TestProxyHandlerValidation(t *testing.T) {
 	// Setup test configuration
 	testConfigs := []config.InstanceConfig{
@@ -64,42 +106,2296 @@ func TestProxyHandlerValidation(t *testing.T) {
 			ModelDeployments: map[string]string{
 				"gpt-4": "gpt-4-deployment",
 			},
-			Enabled:          true,
-			TimeoutSeconds:   30.0,
-			RetryCount:       3,
-			RateLimitEnabled: false, // Disabled for testing
+			Enabled:          true,
+			TimeoutSeconds:   30.0,
+			RetryCount:       3,
+			RateLimitEnabled: false, // Disabled for testing
+		},
+	}
+	
+	// Create mock stores
+	stateStore := &MockStateStore{}
+	configStore := &MockConfigStore{}
+	
+	// Create instance manager
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, configStore)
+	assert.NoError(t, err)
+	
+	// Create proxy handler
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	// Setup test router
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	// Test invalid JSON request
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString("invalid json"))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	
+	router.ServeHTTP(resp, req)
+	
+	assert.Equal(t, 400, resp.Code)
+	
+	var errorResponse map[string]interface{}
+	err = json.Unmarshal(resp.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+	assert.Contains(t, errorResponse, "error")
+}
+
+func TestModelsEndpointRespectsClientAllowlist(t *testing.T) {
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         "https://test.openai.azure.com",
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4", "gpt-35-turbo"},
+			ModelDeployments: map[string]string{
+				"gpt-4": "gpt-4-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	clients := []config.ClientConfig{
+		{Name: "limited", APIKey: "limited-key", AllowedModels: []string{"gpt-4"}},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(clients))
+	v1.GET("/models", proxyHandler.ListModels)
+	v1.GET("/models/:id", proxyHandler.RetrieveModel)
+	
+	// Requests with an unknown key are served anonymously, without an allowlist
+	req, _ := http.NewRequest("GET", "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer unknown-key")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"gpt-35-turbo"`)
+	
+	// Listing only includes allowed models
+	req, _ = http.NewRequest("GET", "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer limited-key")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	
+	var list struct {
+		Object string                   `json:"object"`
+		Data   []map[string]interface{} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	assert.Equal(t, "list", list.Object)
+	if assert.Len(t, list.Data, 1) {
+		model := list.Data[0]
+		assert.Equal(t, "gpt-4", model["id"])
+		assert.Equal(t, "azure-openai", model["owned_by"])
+		assert.Equal(t, float64(8192), model["context_length"])
+		assert.Equal(t, true, model["available"])
+	}
+	
+	// Retrieving a disallowed model looks like it does not exist
+	req, _ = http.NewRequest("GET", "/v1/models/gpt-35-turbo", nil)
+	req.Header.Set("Authorization", "Bearer limited-key")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestAudioTranscriptionMultipartForwarding(t *testing.T) {
+	// Fake Azure upstream that checks the re-encoded form
+	var upstreamPath, upstreamFile, upstreamModel, upstreamFormat string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			upstreamModel = r.FormValue("model")
+			upstreamFormat = r.FormValue("response_format")
+			if file, _, err := r.FormFile("file"); err == nil {
+				data, _ := io.ReadAll(file)
+				upstreamFile = string(data)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text": "hello", "duration": 3.2}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"whisper"},
+			ModelDeployments: map[string]string{
+				"whisper": "whisper-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/audio/transcriptions", proxyHandler.AudioTranscriptions)
+	
+	// The file precedes the model field, as some SDKs send it
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fileWriter, _ := form.CreateFormFile("file", "speech.mp3")
+	fileWriter.Write([]byte("fake-audio-bytes"))
+	form.WriteField("model", "whisper")
+	form.WriteField("response_format", "verbose_json")
+	form.Close()
+	
+	req, _ := http.NewRequest("POST", "/v1/audio/transcriptions", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "hello")
+	assert.Equal(t, "/openai/deployments/whisper-deployment/audio/transcriptions", upstreamPath)
+	assert.Equal(t, "fake-audio-bytes", upstreamFile)
+	assert.Equal(t, "verbose_json", upstreamFormat)
+	assert.Empty(t, upstreamModel)
+}
+
+func TestCreateBatchRoutesToFileInstance(t *testing.T) {
+	// Fake Azure upstream that accepts the batch
+	var upstreamPath, upstreamInputFile string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		upstreamInputFile, _ = payload["input_file_id"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "batch_123", "object": "batch", "status": "validating", "input_file_id": "file-abc"}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:           "test-instance",
+			ProviderType:   "azure",
+			APIKey:         "test-key",
+			APIBase:        upstream.URL,
+			Weight:         10,
+			Enabled:        true,
+			BatchEnabled:   true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	// The mock config store maps every file to test-instance
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/batches", proxyHandler.CreateBatch)
+	
+	// Missing input file is rejected before reaching the upstream
+	req, _ := http.NewRequest("POST", "/v1/batches", bytes.NewBufferString(`{"endpoint": "/v1/chat/completions"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+	assert.Empty(t, upstreamPath)
+	
+	req, _ = http.NewRequest("POST", "/v1/batches", bytes.NewBufferString(`{"input_file_id": "file-abc", "endpoint": "/v1/chat/completions", "completion_window": "24h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "batch_123")
+	assert.Equal(t, "/openai/batches", upstreamPath)
+	assert.Equal(t, "file-abc", upstreamInputFile)
+}
+
+func TestFilesAndBatchesOwnedByClient(t *testing.T) {
+	// Fake Azure upstream holding the uploaded file and one created elsewhere
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/openai/files":
+			w.Write([]byte(`{"id": "file-1", "object": "file", "purpose": "batch"}`))
+		case r.Method == "GET" && r.URL.Path == "/openai/files":
+			w.Write([]byte(`{"object": "list", "data": [{"id": "file-1", "object": "file"}, {"id": "file-elsewhere", "object": "file"}]}`))
+		default:
+			w.Write([]byte(`{"id": "` + strings.TrimPrefix(r.URL.Path, "/openai/files/") + `", "object": "file"}`))
+		}
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:           "test-instance",
+			ProviderType:   "azure",
+			APIKey:         "test-key",
+			APIBase:        upstream.URL,
+			Weight:         10,
+			Enabled:        true,
+			BatchEnabled:   true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	configStore, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "proxy.db"))
+	assert.NoError(t, err)
+	defer configStore.Close()
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, configStore)
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if name := c.GetHeader("x-test-client"); name != "" {
+			c.Set("client", &config.ClientConfig{Name: name})
+		}
+	})
+	router.POST("/v1/files", proxyHandler.UploadFile)
+	router.GET("/v1/files", proxyHandler.ListFiles)
+	router.GET("/v1/files/:id", proxyHandler.GetFile)
+	
+	send := func(method, path, client string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(`{}`))
+		req.Header.Set("x-test-client", client)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	resp := send("POST", "/v1/files", "team-a")
+	assert.Equal(t, 200, resp.Code)
+	
+	// Only the client that uploaded the file can reach it
+	resp = send("GET", "/v1/files/file-1", "team-a")
+	assert.Equal(t, 200, resp.Code)
+	resp = send("GET", "/v1/files/file-1", "team-b")
+	assert.Equal(t, 404, resp.Code)
+	
+	// Lists only show the client's own files, and listing grants no access
+	resp = send("GET", "/v1/files", "team-a")
+	assert.Contains(t, resp.Body.String(), "file-1")
+	assert.NotContains(t, resp.Body.String(), "file-elsewhere")
+	resp = send("GET", "/v1/files", "team-b")
+	assert.NotContains(t, resp.Body.String(), "file-1")
+	resp = send("GET", "/v1/files/file-elsewhere", "team-a")
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestResponsesRoutedByAffinity(t *testing.T) {
+	// Fake Azure upstream for the Responses API
+	var upstreamPath, upstreamVersion string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		upstreamVersion = r.URL.Query().Get("api-version")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "resp_1", "object": "response", "status": "completed"}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:           "test-instance",
+			ProviderType:   "azure",
+			APIKey:         "test-key",
+			APIBase:        upstream.URL,
+			Weight:         10,
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	// The mock config store resolves every stored response to test-instance
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/responses", proxyHandler.Responses)
+	router.GET("/v1/responses/:id", proxyHandler.GetResponse)
+	
+	req, _ := http.NewRequest("GET", "/v1/responses/resp_1", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "resp_1")
+	assert.Equal(t, "/openai/responses/resp_1", upstreamPath)
+	assert.Equal(t, "2025-03-01-preview", upstreamVersion)
+	
+	// Missing input is rejected before reaching the upstream
+	upstreamPath = ""
+	req, _ = http.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{"model": "gpt-4o", "previous_response_id": "resp_1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+	assert.Empty(t, upstreamPath)
+}
+
+func TestRealtimeWebSocketRelay(t *testing.T) {
+	// Fake Azure realtime deployment that echoes client events
+	var upstreamPath, upstreamDeployment, upstreamKey string
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		upstreamDeployment = r.URL.Query().Get("deployment")
+		upstreamKey = r.Header.Get("api-key")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "response.done", "response": {"usage": {"total_tokens": 30, "input_token_details": {"text_tokens": 10}, "output_token_details": {"audio_tokens": 20}}}}`))
+		}
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:                "test-instance",
+			ProviderType:        "azure",
+			APIKey:              "test-key",
+			APIBase:             upstream.URL,
+			Weight:              10,
+			MaxTPM:              60000,
+			MaxRealtimeSessions: 1,
+			SupportedModels:     []string{"gpt-4o-realtime"},
+			ModelDeployments: map[string]string{
+				"gpt-4o-realtime": "realtime-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.GET("/v1/realtime", proxyHandler.Realtime)
+	proxyServer := httptest.NewServer(router)
+	defer proxyServer.Close()
+	
+	proxyURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/v1/realtime?model=gpt-4o-realtime"
+	conn, _, err := websocket.DefaultDialer.Dial(proxyURL, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	
+	assert.Equal(t, "/openai/realtime", upstreamPath)
+	assert.Equal(t, "realtime-deployment", upstreamDeployment)
+	assert.Equal(t, "test-key", upstreamKey)
+	
+	// Frames are relayed in both directions
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "response.create"}`)))
+	_, echoed, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Contains(t, string(echoed), "response.create")
+	_, done, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Contains(t, string(done), "response.done")
+	assert.Equal(t, 1, instanceManager.ActiveRealtimeSessions("test-instance"))
+	
+	// A second session exceeds the instance limit
+	_, resp, err := websocket.DefaultDialer.Dial(proxyURL, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, 503, resp.StatusCode)
+	}
+	
+	// Shutdown closes open sessions with a going-away frame
+	assert.NoError(t, proxyHandler.Close())
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
+
+func TestRealtimeHandshakeTimeout(t *testing.T) {
+	// Fake Azure realtime deployment that never answers the upgrade
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	
+	testConfig := testInstanceConfig("test-instance", upstream.URL)
+	testConfig.SupportedModels = []string{"gpt-4o-realtime"}
+	testConfig.ModelDeployments = map[string]string{"gpt-4o-realtime": "realtime-deployment"}
+	testConfig.ConnectTimeoutSeconds = 0.2
+	testConfig.TimeoutSeconds = 0.5
+	
+	instanceManager, err := instance.NewManager([]config.InstanceConfig{testConfig}, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.GET("/v1/realtime", proxyHandler.Realtime)
+	proxyServer := httptest.NewServer(router)
+	defer proxyServer.Close()
+	
+	// Fractional timeouts bound the handshake rather than disabling it
+	start := time.Now()
+	proxyURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/v1/realtime?model=gpt-4o-realtime"
+	_, resp, err := websocket.DefaultDialer.Dial(proxyURL, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// byteLevelBpeLoader serves a single-byte vocabulary so token estimation works
+// without downloading encodings
+type byteLevelBpeLoader struct{}
+
+func (byteLevelBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+func TestStreamingRelayLargeLinesAndIdleTimeout(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream sending an oversized chunk, then stalling
+	largeArguments := strings.Repeat("x", 100*1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: 1\n"))
+		w.Write([]byte(`data: {"id": "chatcmpl-1", "model": "gpt-4-deployment", "choices": [{"delta": {"tool_calls": [{"function": {"arguments": "` + largeArguments + `"}}]}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4"},
+			ModelDeployments: map[string]string{
+				"gpt-4": "gpt-4-deployment",
+			},
+			Enabled:                  true,
+			TimeoutSeconds:           30.0,
+			StreamIdleTimeoutSeconds: 0.2,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	payload := `{"model": "gpt-4", "stream": true, "messages": [{"role": "user", "content": "Hello"}]}`
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	body := resp.Body.String()
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, body, "id: 1\n")
+	assert.Contains(t, body, largeArguments)
+	assert.Contains(t, body, `"model":"gpt-4"`)
+	
+	// The stalled stream ends with a well-formed error event
+	assert.True(t, strings.HasSuffix(body, "\n\n"))
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	lastEvent := strings.TrimPrefix(lines[len(lines)-1], "data: ")
+	var errorEvent map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lastEvent), &errorEvent))
+	assert.Equal(t, "upstream stream idle timeout", errorEvent["error"].(map[string]interface{})["message"])
+}
+
+func TestCompletionsStreamEndingWithoutDone(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream whose completions stream ends without [DONE]
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id": "cmpl-1", "object": "text_completion", "choices": [{"index": 0, "text": "Once upon"}]}` + "\n\n"))
+	}))
+	defer upstream.Close()
+	
+	instanceManager, err := instance.NewManager([]config.InstanceConfig{testInstanceConfig("test-instance", upstream.URL)}, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/completions", proxyHandler.Completions)
+	
+	req, _ := http.NewRequest("POST", "/v1/completions", bytes.NewBufferString(`{"model": "gpt-4o", "stream": true, "prompt": "Tell a story"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	// The dropped connection is reported instead of silently truncating
+	body := resp.Body.String()
+	assert.Contains(t, body, "Once upon")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	var errorEvent map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[len(lines)-1], "data: ")), &errorEvent))
+	assert.Equal(t, "upstream stream failed", errorEvent["error"].(map[string]interface{})["message"])
+}
+
+func TestStreamingFailoverContinuesPartialResponse(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Primary sends part of the answer, then the stream ends without [DONE]
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id": "chatcmpl-primary", "model": "gpt-4-deployment", "choices": [{"index": 0, "delta": {"role": "assistant", "content": ""}}]}` + "\n\n"))
+		w.Write([]byte(`data: {"id": "chatcmpl-primary", "model": "gpt-4-deployment", "choices": [{"index": 0, "delta": {"content": "Hel"}}]}` + "\n\n"))
+	}))
+	defer primary.Close()
+	
+	// Secondary continues from the partial assistant message
+	var continuedFrom string
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages := payload["messages"].([]interface{})
+		last := messages[len(messages)-1].(map[string]interface{})
+		if last["role"] == "assistant" {
+			continuedFrom, _ = last["content"].(string)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id": "chatcmpl-secondary", "model": "gpt-4-deployment", "choices": [{"index": 0, "delta": {"role": "assistant", "content": ""}}]}` + "\n\n"))
+		w.Write([]byte(`data: {"id": "chatcmpl-secondary", "model": "gpt-4-deployment", "choices": [{"index": 0, "delta": {"content": "lo"}}]}` + "\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer secondary.Close()
+	
+	newInstance := func(name, apiBase string, priority int) config.InstanceConfig {
+		return config.InstanceConfig{
+			Name:            name,
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         apiBase,
+			Priority:        priority,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4"},
+			ModelDeployments: map[string]string{
+				"gpt-4": "gpt-4-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		}
+	}
+	testConfigs := []config.InstanceConfig{
+		newInstance("primary", primary.URL, 1),
+		newInstance("secondary", secondary.URL, 2),
+	}
+	
+	configStore, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "proxy.db"))
+	assert.NoError(t, err)
+	defer configStore.Close()
+	instanceManager, err := instance.NewManager(testConfigs, "failover", &MockStateStore{}, configStore)
+	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{
+		Strategy: "failover",
+		StreamFailover: config.StreamFailoverConfig{
+			Enabled:      true,
+			Continuation: true,
+		},
+	})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	payload := `{"model": "gpt-4", "stream": true, "messages": [{"role": "user", "content": "Say hello"}]}`
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	body := resp.Body.String()
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "Hel", continuedFrom)
+	
+	// The client sees one stream: a single role chunk, both parts of the text,
+	// the original id throughout and a final [DONE]
+	assert.Equal(t, 1, strings.Count(body, `"role":"assistant"`))
+	assert.Contains(t, body, `"content":"Hel"`)
+	assert.Contains(t, body, `"content":"lo"`)
+	assert.NotContains(t, body, "chatcmpl-secondary")
+	assert.NotContains(t, body, `"error"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+	
+	// Each attempt is costed from its estimated prompt and the text it relayed
+	costs, err := instanceManager.CostStats(context.Background(), "")
+	assert.NoError(t, err)
+	byInstance := costs["by_instance"].(map[string]interface{})
+	primaryCosts := byInstance["primary"].(map[string]interface{})
+	secondaryCosts := byInstance["secondary"].(map[string]interface{})
+	assert.Equal(t, int64(1), primaryCosts["requests"])
+	assert.Equal(t, int64(1), secondaryCosts["requests"])
+	assert.Greater(t, primaryCosts["output_tokens"], int64(0))
+	assert.Greater(t, secondaryCosts["output_tokens"], int64(0))
+	assert.Greater(t, secondaryCosts["input_tokens"], primaryCosts["input_tokens"])
+	
+	// Without failover the truncated stream ends with an error event
+	instanceManager.SetRoutingConfig(config.RoutingConfig{Strategy: "failover"})
+	req, _ = http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	body = resp.Body.String()
+	assert.Contains(t, body, `"content":"Hel"`)
+	assert.NotContains(t, body, "[DONE]")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	var errorEvent map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[len(lines)-1], "data: ")), &errorEvent))
+	assert.Equal(t, "upstream stream failed", errorEvent["error"].(map[string]interface{})["message"])
+}
+
+func TestUpstreamFirstByteTimeoutAndClientCancellation(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream that never answers, reporting when its request is cancelled
+	upstreamCancelled := make(chan struct{}, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body is consumed
+		io.ReadAll(r.Body)
+		<-r.Context().Done()
+		upstreamCancelled <- struct{}{}
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4"},
+			ModelDeployments: map[string]string{
+				"gpt-4": "gpt-4-deployment",
+			},
+			Enabled:                 true,
+			TimeoutSeconds:          30.0,
+			FirstByteTimeoutSeconds: 0.2,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	payload := `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}]}`
+	
+	// Waiting for response headers is bounded by the first-byte timeout
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	
+	assert.Equal(t, 504, resp.Code)
+	assert.Contains(t, resp.Body.String(), "upstream first_byte timeout")
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("timed out upstream request was not cancelled")
+	}
+	
+	// A client disconnect cancels the upstream request and sends nothing back
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequestWithContext(ctx, "POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	router.ServeHTTP(resp, req)
+	
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+	assert.Empty(t, resp.Body.String())
+}
+
+func TestResponseCacheServesDeterministicRequests(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	upstreamCalls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"id\": \"chatcmpl-2\", \"object\": \"chat.completion.chunk\", \"created\": 1700000000, \"choices\": [{\"index\": 0, \"delta\": {\"role\": \"assistant\", \"content\": \"Streamed answer\"}, \"finish_reason\": \"stop\"}]}\n\n"))
+			w.Write([]byte("data: {\"id\": \"chatcmpl-2\", \"object\": \"chat.completion.chunk\", \"created\": 1700000000, \"choices\": [], \"usage\": {\"prompt_tokens\": 6, \"completion_tokens\": 3, \"total_tokens\": 9}}\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "created": 1700000000, "model": "gpt-4-deployment", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Cached answer"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4"},
+			ModelDeployments: map[string]string{
+				"gpt-4": "gpt-4-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	proxyHandler.SetResponseCache(&MockResponseCache{entries: make(map[string][]byte)}, config.CacheConfig{Enabled: true})
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	send := func(payload string, optIn bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if optIn {
+			req.Header.Set("X-Proxy-Cache", "true")
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	payload := `{"model": "gpt-4", "temperature": 0, "messages": [{"role": "user", "content": "Hello"}]}`
+	
+	// The first request goes upstream and fills the cache
+	resp := send(payload, true)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Equal(t, 1, upstreamCalls)
+	
+	// The same payload, with keys in another order, is served from the cache
+	resp = send(`{"messages": [{"content": "Hello", "role": "user"}], "temperature": 0.0, "model": "gpt-4"}`, true)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.Contains(t, resp.Body.String(), "Cached answer")
+	assert.Contains(t, resp.Body.String(), `"model":"gpt-4"`)
+	assert.Equal(t, 1, upstreamCalls)
+	
+	// Streaming requests are replayed as SSE
+	resp = send(`{"model": "gpt-4", "temperature": 0, "stream": true, "messages": [{"role": "user", "content": "Hello"}]}`, true)
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Body.String(), `"object":"chat.completion.chunk"`)
+	assert.Contains(t, resp.Body.String(), `"content":"Cached answer"`)
+	assert.Contains(t, resp.Body.String(), `"finish_reason":"stop"`)
+	assert.True(t, strings.HasSuffix(resp.Body.String(), "data: [DONE]\n\n"))
+	assert.Equal(t, 1, upstreamCalls)
+	
+	// Requests that did not opt in, or are not deterministic, bypass the cache
+	resp = send(payload, false)
+	assert.Empty(t, resp.Header().Get("X-Cache"))
+	resp = send(`{"model": "gpt-4", "temperature": 0.7, "messages": [{"role": "user", "content": "Hello"}]}`, true)
+	assert.Empty(t, resp.Header().Get("X-Cache"))
+	resp = send(`{"model": "gpt-4", "temperature": 0.7, "seed": 42, "messages": [{"role": "user", "content": "Hello"}]}`, true)
+	assert.Empty(t, resp.Header().Get("X-Cache"))
+	assert.Equal(t, 4, upstreamCalls)
+	
+	cacheStats := instanceManager.CacheStats()
+	assert.Equal(t, int64(2), cacheStats["hits"])
+	assert.Equal(t, int64(1), cacheStats["misses"])
+	
+	// A completion cached from a stream keeps the stream's usage
+	resp = send(`{"model": "gpt-4", "temperature": 0, "stream": true, "messages": [{"role": "user", "content": "Stream it"}]}`, true)
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Contains(t, resp.Body.String(), "Streamed answer")
+	resp = send(`{"model": "gpt-4", "temperature": 0, "messages": [{"role": "user", "content": "Stream it"}]}`, true)
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.Contains(t, resp.Body.String(), "Streamed answer")
+	assert.Contains(t, resp.Body.String(), `"usage":{"completion_tokens":3,"prompt_tokens":6,"total_tokens":9}`)
+	assert.Equal(t, 5, upstreamCalls)
+}
+
+func TestSemanticCacheReusesSimilarAnswers(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream serving embeddings and chat; questions about the
+	// capital of France embed close to each other
+	embeddings := map[string]string{
+		"What is the capital of France?": "[1, 0, 0]",
+		"Tell me the capital of France":  "[0.98, 0.1, 0]",
+		"How tall is Mount Everest?":     "[0, 1, 0]",
+	}
+	chatCalls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Header().Set("Content-Type", "application/json")
+		
+		if strings.Contains(r.URL.Path, "/embeddings") {
+			w.Write([]byte(`{"object": "list", "data": [{"index": 0, "embedding": ` + embeddings[payload["input"].(string)] + `}], "usage": {"prompt_tokens": 8, "total_tokens": 8}}`))
+			return
+		}
+		chatCalls++
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "created": 1700000000, "model": "gpt-4-deployment", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Answer ` + strconv.Itoa(chatCalls) + `"}, "finish_reason": "stop"}]}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"gpt-4", "text-embedding-3-small"},
+			ModelDeployments: map[string]string{
+				"gpt-4":                  "gpt-4-deployment",
+				"text-embedding-3-small": "embedding-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	configStore, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "proxy.db"))
+	assert.NoError(t, err)
+	defer configStore.Close()
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, configStore)
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	adminHandler := handlers.NewAdminHandler(instanceManager)
+	
+	vectorIndex := storage.NewMemoryVectorIndex(100)
+	cacheConfig := config.CacheConfig{
+		Semantic: config.SemanticCacheConfig{
+			Enabled:        true,
+			EmbeddingModel: "text-embedding-3-small",
+			Threshold:      0.95,
+		},
+	}
+	proxyHandler.SetSemanticCache(vectorIndex, cacheConfig)
+	adminHandler.SetSemanticCache(vectorIndex)
+	
+	clients := []config.ClientConfig{
+		{Name: "support-bot", APIKey: "support-key", SemanticCache: true, CacheNamespace: "support"},
+		{Name: "other", APIKey: "other-key"},
+	}
+	
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(clients))
+	v1.POST("/chat/completions", proxyHandler.ChatCompletions)
+	router.GET("/admin/cache/semantic", adminHandler.GetSemanticCache)
+	router.DELETE("/admin/cache/semantic", adminHandler.InvalidateSemanticCache)
+	
+	askWithSystem := func(apiKey, system, question string) *httptest.ResponseRecorder {
+		payload := `{"model": "gpt-4", "messages": [{"role": "system", "content": "` + system + `"}, {"role": "user", "content": "` + question + `"}]}`
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	ask := func(apiKey, question string) *httptest.ResponseRecorder {
+		return askWithSystem(apiKey, "Be brief", question)
+	}
+	
+	// The first question goes upstream and is indexed
+	resp := ask("support-key", "What is the capital of France?")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Contains(t, resp.Body.String(), "Answer 1")
+	
+	// A similar question reuses the answer
+	resp = ask("support-key", "Tell me the capital of France")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.NotEmpty(t, resp.Header().Get("X-Cache-Similarity"))
+	assert.Contains(t, resp.Body.String(), "Answer 1")
+	assert.Equal(t, 1, chatCalls)
+	
+	// A different question, or a client without the semantic cache, goes upstream
+	resp = ask("support-key", "How tall is Mount Everest?")
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	resp = ask("other-key", "Tell me the capital of France")
+	assert.Empty(t, resp.Header().Get("X-Cache"))
+	assert.Equal(t, 3, chatCalls)
+	
+	// The same question under another system prompt is a different conversation
+	resp = askWithSystem("support-key", "Answer in French", "Tell me the capital of France")
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Equal(t, 4, chatCalls)
+	
+	// Entries are listed per namespace, model and conversation context, and can
+	// be invalidated
+	req, _ := http.NewRequest("GET", "/admin/cache/semantic", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	var listing struct {
+		Namespaces map[string]int `json:"namespaces"`
+		Total      int            `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &listing))
+	assert.Len(t, listing.Namespaces, 2)
+	for namespace := range listing.Namespaces {
+		assert.True(t, strings.HasPrefix(namespace, "support/gpt-4/"))
+	}
+	assert.Equal(t, 3, listing.Total)
+	
+	req, _ = http.NewRequest("DELETE", "/admin/cache/semantic?namespace=support", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"removed":3`)
+	
+	resp = ask("support-key", "Tell me the capital of France")
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Equal(t, 5, chatCalls)
+	
+	// Every lookup's embedding is costed to the client that asked
+	costs, err := instanceManager.CostStats(context.Background(), "")
+	assert.NoError(t, err)
+	embeddingCosts := costs["by_model"].(map[string]interface{})["text-embedding-3-small"].(map[string]interface{})
+	assert.Equal(t, int64(5), embeddingCosts["requests"])
+	assert.Equal(t, int64(40), embeddingCosts["input_tokens"])
+	clientCosts := costs["by_client"].(map[string]interface{})["support-bot"].(map[string]interface{})
+	assert.Equal(t, int64(5), clientCosts["requests"])
+}
+
+func TestCoalescingSharesInFlightRequests(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream holding responses until released
+	var upstreamCalls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object": "list", "model": "embedding-deployment", "data": [{"index": 0, "embedding": [0.1, 0.2]}], "usage": {"prompt_tokens": 2, "total_tokens": 2}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"text-embedding-3-small"},
+			ModelDeployments: map[string]string{
+				"text-embedding-3-small": "embedding-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	proxyHandler.SetCoalescing(config.CoalescingConfig{Enabled: true})
+	
+	clients := []config.ClientConfig{
+		{Name: "dashboard", APIKey: "dashboard-key"},
+		{Name: "reports", APIKey: "reports-key"},
+	}
+	
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(clients))
+	v1.POST("/embeddings", proxyHandler.Embeddings)
+	
+	// Four identical dashboard requests and one from another client key
+	apiKeys := []string{"dashboard-key", "dashboard-key", "dashboard-key", "dashboard-key", "reports-key"}
+	responses := make([]*httptest.ResponseRecorder, len(apiKeys))
+	var wg sync.WaitGroup
+	for i, apiKey := range apiKeys {
+		wg.Add(1)
+		go func(i int, apiKey string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "/v1/embeddings", bytes.NewBufferString(`{"model": "text-embedding-3-small", "input": "refresh"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			responses[i] = httptest.NewRecorder()
+			router.ServeHTTP(responses[i], req)
+		}(i, apiKey)
+	}
+	
+	// Let every request reach the upstream or join an in-flight one
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	
+	coalesced := 0
+	for _, resp := range responses {
+		assert.Equal(t, 200, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"embedding":[0.1,0.2]`)
+		assert.Contains(t, resp.Body.String(), `"model":"text-embedding-3-small"`)
+		if resp.Header().Get("X-Coalesced") == "true" {
+			coalesced++
+		}
+	}
+	assert.Equal(t, int32(2), upstreamCalls.Load())
+	assert.Equal(t, 3, coalesced)
+}
+
+func TestEmbeddingBatchingMergesConcurrentRequests(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream embedding each input as its first byte, returned in
+	// reverse order
+	var upstreamCalls atomic.Int32
+	var batchedInputs []interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		batchedInputs, _ = payload["input"].([]interface{})
+		
+		var data []map[string]interface{}
+		for i := len(batchedInputs) - 1; i >= 0; i-- {
+			text, _ := batchedInputs[i].(string)
+			data = append(data, map[string]interface{}{
+				"object":    "embedding",
+				"index":     i,
+				"embedding": []float64{float64(text[0])},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"model":  "embedding-deployment",
+			"data":   data,
+			"usage":  map[string]interface{}{"prompt_tokens": 10, "total_tokens": 10},
+		})
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			MaxInputTokens:  8000,
+			SupportedModels: []string{"text-embedding-3-small"},
+			ModelDeployments: map[string]string{
+				"text-embedding-3-small": "embedding-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	proxyHandler.SetEmbeddingBatching(config.EmbeddingBatchingConfig{Enabled: true, MaxWaitMs: 200})
+	
+	router := gin.New()
+	router.POST("/v1/embeddings", proxyHandler.Embeddings)
+	
+	// Three single-string requests and one with two inputs
+	inputs := []string{`"apple"`, `"banana"`, `"cherry"`, `["date", "elderberry"]`}
+	responses := make([]*httptest.ResponseRecorder, len(inputs))
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "/v1/embeddings", bytes.NewBufferString(`{"model": "text-embedding-3-small", "input": `+input+`}`))
+			req.Header.Set("Content-Type", "application/json")
+			responses[i] = httptest.NewRecorder()
+			router.ServeHTTP(responses[i], req)
+		}(i, input)
+	}
+	wg.Wait()
+	
+	assert.Equal(t, int32(1), upstreamCalls.Load())
+	assert.Len(t, batchedInputs, 5)
+	
+	totalTokens := 0
+	for i, resp := range responses {
+		assert.Equal(t, 200, resp.Code)
+		
+		var result struct {
+			Model string `json:"model"`
+			Data  []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+			Usage struct {
+				PromptTokens int `json:"prompt_tokens"`
+			} `json:"usage"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+		assert.Equal(t, "text-embedding-3-small", result.Model)
+		
+		// Each caller gets the vectors of its own inputs, indexed from 0
+		var expected []string
+		json.Unmarshal([]byte(inputs[i]), &expected)
+		if len(expected) == 0 {
+			var single string
+			json.Unmarshal([]byte(inputs[i]), &single)
+			expected = []string{single}
+		}
+		assert.Len(t, result.Data, len(expected))
+		for j, item := range result.Data {
+			assert.Equal(t, j, item.Index)
+			assert.Equal(t, []float64{float64(expected[j][0])}, item.Embedding)
+		}
+		totalTokens += result.Usage.PromptTokens
+	}
+	assert.Equal(t, 10, totalTokens)
+	
+	stats := instanceManager.EmbeddingBatchStats()
+	assert.Equal(t, int64(1), stats["batches"])
+	assert.Equal(t, int64(4), stats["requests"])
+}
+
+func TestAdmissionQueueAdmitsByPriority(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream recording which client each request came from
+	var servedMutex sync.Mutex
+	var served []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		servedMutex.Lock()
+		served = append(served, fmt.Sprint(payload["input"]))
+		servedMutex.Unlock()
+		data := `{"index": 0, "embedding": [0.1]}`
+		if inputs, ok := payload["input"].([]interface{}); ok {
+			data = ""
+			for i := range inputs {
+				if i > 0 {
+					data += ", "
+				}
+				data += fmt.Sprintf(`{"index": %d, "embedding": [0.1]}`, i)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object": "list", "data": [` + data + `], "usage": {"prompt_tokens": 1, "total_tokens": 1}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:            "test-instance",
+			ProviderType:    "azure",
+			APIKey:          "test-key",
+			APIBase:         upstream.URL,
+			Weight:          10,
+			MaxTPM:          60000,
+			SupportedModels: []string{"text-embedding-3-small"},
+			ModelDeployments: map[string]string{
+				"text-embedding-3-small": "embedding-deployment",
+			},
+			Enabled:        true,
+			TimeoutSeconds: 30.0,
+		},
+	}
+	
+	stateStore := &GatedStateStore{}
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetAdmissionConfig(config.AdmissionConfig{Enabled: true, MaxQueueSize: 2, MaxWaitSeconds: 1})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	clients := []config.ClientConfig{
+		{Name: "interactive", APIKey: "interactive-key", Priority: "high"},
+		{Name: "ingestion", APIKey: "ingestion-key", Priority: "low"},
+	}
+	
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(clients))
+	v1.POST("/embeddings", proxyHandler.Embeddings)
+	
+	embed := func(apiKey, input string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/embeddings", bytes.NewBufferString(`{"model": "text-embedding-3-small", "input": "`+input+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	// With every instance at its limit, a batch request queues first and an
+	// interactive request after it
+	lowDone := make(chan *httptest.ResponseRecorder, 1)
+	highDone := make(chan *httptest.ResponseRecorder, 1)
+	go func() { lowDone <- embed("ingestion-key", "batch") }()
+	time.Sleep(50 * time.Millisecond)
+	go func() { highDone <- embed("interactive-key", "interactive") }()
+	time.Sleep(50 * time.Millisecond)
+	
+	// The queue is full, so further requests are shed
+	resp := embed("ingestion-key", "shed")
+	assert.Equal(t, 429, resp.Code)
+	assert.Contains(t, resp.Body.String(), "admission queue is full")
+	
+	stats := instanceManager.AdmissionStats()
+	assert.Equal(t, 2, stats["depth"])
+	assert.Equal(t, map[string]int{"high": 1, "low": 1}, stats["depth_by_priority"])
+	
+	// Capacity for one request admits the interactive request first
+	stateStore.AllowHealthyReads(1)
+	assert.Equal(t, 200, (<-highDone).Code)
+	servedMutex.Lock()
+	assert.Equal(t, []string{"interactive"}, served)
+	servedMutex.Unlock()
+	
+	stateStore.AllowHealthyReads(1)
+	assert.Equal(t, 200, (<-lowDone).Code)
+	
+	// Requests give up once the maximum wait expires
+	resp = embed("interactive-key", "late")
+	assert.Equal(t, 429, resp.Code)
+	assert.Contains(t, resp.Body.String(), "timed out waiting for capacity")
+	
+	stats = instanceManager.AdmissionStats()
+	assert.Equal(t, 0, stats["depth"])
+	assert.Equal(t, int64(2), stats["admitted"])
+	assert.Equal(t, int64(1), stats["shed"])
+	assert.Equal(t, int64(1), stats["timed_out"])
+	
+	// A batch of embeddings queues as one request at its callers' highest priority
+	proxyHandler.SetEmbeddingBatching(config.EmbeddingBatchingConfig{Enabled: true, MaxWaitMs: 50})
+	go func() { lowDone <- embed("ingestion-key", "first") }()
+	time.Sleep(10 * time.Millisecond)
+	go func() { highDone <- embed("interactive-key", "second") }()
+	time.Sleep(150 * time.Millisecond)
+	
+	stats = instanceManager.AdmissionStats()
+	assert.Equal(t, 1, stats["depth"])
+	assert.Equal(t, map[string]int{"high": 1}, stats["depth_by_priority"])
+	
+	stateStore.AllowHealthyReads(1)
+	assert.Equal(t, 200, (<-lowDone).Code)
+	assert.Equal(t, 200, (<-highDone).Code)
+	servedMutex.Lock()
+	assert.Equal(t, "[first second]", served[len(served)-1])
+	servedMutex.Unlock()
+}
+
+func TestHedgingOvertakesSlowInstance(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Primary instance that turns slow on demand and notices cancellation
+	var slow atomic.Bool
+	primaryCancelled := make(chan struct{}, 1)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+				primaryCancelled <- struct{}{}
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "from primary"}, "finish_reason": "stop"}]}`))
+	}))
+	defer primary.Close()
+	
+	var secondaryCalls atomic.Int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-2", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "from secondary"}, "finish_reason": "stop"}]}`))
+	}))
+	defer secondary.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:             "primary-instance",
+			ProviderType:     "azure",
+			APIKey:           "test-key",
+			APIBase:          primary.URL,
+			Priority:         1,
+			Weight:           10,
+			MaxTPM:           60000,
+			SupportedModels:  []string{"gpt-4o"},
+			ModelDeployments: map[string]string{"gpt-4o": "gpt-4o-deployment"},
+			Enabled:          true,
+			TimeoutSeconds:   30.0,
+		},
+		{
+			Name:             "secondary-instance",
+			ProviderType:     "azure",
+			APIKey:           "test-key",
+			APIBase:          secondary.URL,
+			Priority:         2,
+			Weight:           10,
+			MaxTPM:           60000,
+			SupportedModels:  []string{"gpt-4o"},
+			ModelDeployments: map[string]string{"gpt-4o": "gpt-4o-deployment"},
+			Enabled:          true,
+			TimeoutSeconds:   30.0,
+		},
+	}
+	
+	configStore, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "proxy.db"))
+	assert.NoError(t, err)
+	defer configStore.Close()
+	instanceManager, err := instance.NewManager(testConfigs, "failover", &MockStateStore{}, configStore)
+	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{
+		Strategy: "failover",
+		Hedging:  config.HedgingConfig{Enabled: true, Percentile: 90},
+	})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	chat := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	// Record the primary's normal latency; nothing is hedged yet
+	for i := 0; i < 10; i++ {
+		resp := chat()
+		assert.Equal(t, 200, resp.Code)
+		assert.Contains(t, resp.Body.String(), "from primary")
+	}
+	assert.Equal(t, int32(0), secondaryCalls.Load())
+	
+	// A slow primary is overtaken by the hedge and cancelled
+	slow.Store(true)
+	start := time.Now()
+	resp := chat()
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "from secondary")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	select {
+	case <-primaryCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("losing request was not cancelled")
+	}
+	
+	// The cancelled request's prompt is costed from its estimate
+	costs, err := instanceManager.CostStats(context.Background(), "")
+	assert.NoError(t, err)
+	primaryCosts := costs["by_instance"].(map[string]interface{})["primary-instance"].(map[string]interface{})
+	assert.Equal(t, int64(1), primaryCosts["requests"])
+	assert.Greater(t, primaryCosts["input_tokens"], int64(0))
+	assert.Equal(t, int64(0), primaryCosts["output_tokens"])
+	
+	// The default budget allows hedging 10% of requests, so the next slow
+	// request waits for the primary
+	resp = chat()
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "from primary")
+	
+	stats := instanceManager.HedgingStats()
+	assert.Equal(t, int64(1), stats["hedges_sent"])
+	assert.Equal(t, int64(1), stats["wins"])
+	assert.Equal(t, int64(0), stats["losses"])
+	assert.Equal(t, int64(1), stats["budget_skipped"])
+}
+
+func TestModelAliasesAndFallbacks(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream naming the deployment that answered
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "model": "` + deployment + `", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}]}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:             "gpt4o-instance",
+			ProviderType:     "azure",
+			APIKey:           "test-key",
+			APIBase:          upstream.URL,
+			Weight:           10,
+			MaxTPM:           60000,
+			SupportedModels:  []string{"gpt-4o"},
+			ModelDeployments: map[string]string{"gpt-4o": "gpt-4o-deployment"},
+			Enabled:          true,
+			TimeoutSeconds:   30.0,
+		},
+		{
+			Name:             "gpt4-instance",
+			ProviderType:     "azure",
+			APIKey:           "test-key",
+			APIBase:          upstream.URL,
+			Weight:           10,
+			MaxTPM:           60000,
+			SupportedModels:  []string{"gpt-4"},
+			ModelDeployments: map[string]string{"gpt-4": "gpt-4-deployment"},
+			Enabled:          true,
+			TimeoutSeconds:   30.0,
+		},
+	}
+	
+	stateStore := &LimitedStateStore{limited: map[string]bool{}}
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetModelRegistry([]config.ModelConfig{
+		{Name: "gpt-4o", Aliases: []string{"gpt-4-latest"}, Fallbacks: []string{"gpt-4"}},
+	})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	router.GET("/v1/models/:id", proxyHandler.RetrieveModel)
+	
+	chat := func(model string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "`+model+`", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	// The alias is served by the model it refers to
+	resp := chat("gpt-4-latest")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by gpt-4o-deployment")
+	assert.Contains(t, resp.Body.String(), `"model":"gpt-4o"`)
+	assert.Equal(t, "gpt-4o", resp.Header().Get("X-Served-Model"))
+	
+	// Without capacity for gpt-4o the fallback serves the request and says so
+	stateStore.limited["gpt4o-instance"] = true
+	resp = chat("gpt-4-latest")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by gpt-4-deployment")
+	assert.Contains(t, resp.Body.String(), `"model":"gpt-4"`)
+	assert.Equal(t, "gpt-4", resp.Header().Get("X-Served-Model"))
+	
+	// With the fallback exhausted too, the request fails
+	stateStore.limited["gpt4-instance"] = true
+	resp = chat("gpt-4o")
+	assert.Equal(t, 503, resp.Code)
+	
+	// Aliases are listed with the model they refer to
+	req, _ := http.NewRequest("GET", "/v1/models/gpt-4-latest", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"alias_for":"gpt-4o"`)
+	assert.Contains(t, resp.Body.String(), `"fallbacks":["gpt-4"]`)
+	
+	// A request too large for the gpt-4o instance's token limit falls back too
+	stateStore.limited = map[string]bool{}
+	testConfigs[0].MaxTPM = 50
+	instanceManager, err = instance.NewManager(testConfigs, "weighted", stateStore, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetModelRegistry([]config.ModelConfig{
+		{Name: "gpt-4o", Fallbacks: []string{"gpt-4"}},
+	})
+	router = gin.New()
+	router.POST("/v1/chat/completions", handlers.NewProxyHandler(instanceManager).ChatCompletions)
+	
+	resp = chat("gpt-4o")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by gpt-4o-deployment")
+	
+	req, _ = http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "`+strings.Repeat("a long question ", 20)+`"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by gpt-4-deployment")
+	assert.Equal(t, "gpt-4", resp.Header().Get("X-Served-Model"))
+}
+
+func TestLatencyAwareStrategies(t *testing.T) {
+	testConfigs := []config.InstanceConfig{
+		testInstanceConfig("slow-instance", "https://slow-instance.openai.azure.com"),
+		testInstanceConfig("medium-instance", "https://medium-instance.openai.azure.com"),
+		testInstanceConfig("fast-instance", "https://fast-instance.openai.azure.com"),
+	}
+	latencies := map[string]time.Duration{
+		"slow-instance":   900 * time.Millisecond,
+		"medium-instance": 300 * time.Millisecond,
+		"fast-instance":   20 * time.Millisecond,
+	}
+	
+	for _, strategy := range []string{"lowest_latency", "composite", "power_of_two"} {
+		instanceManager, err := instance.NewManager(testConfigs, strategy, &MockStateStore{}, &MockConfigStore{})
+		assert.NoError(t, err)
+		for name, latency := range latencies {
+			for i := 0; i < 20; i++ {
+				instanceManager.RecordLatency(name, latency)
+			}
+		}
+		
+		selected := make(map[string]int)
+		for i := 0; i < 50; i++ {
+			instanceName, err := instanceManager.SelectInstance(context.Background(), "gpt-4o", 100, "azure")
+			assert.NoError(t, err)
+			selected[instanceName]++
+		}
+		
+		if strategy == "power_of_two" {
+			// The slowest instance loses every comparison it is part of
+			assert.Zero(t, selected["slow-instance"], strategy)
+			assert.Positive(t, selected["fast-instance"], strategy)
+		} else {
+			assert.Equal(t, 50, selected["fast-instance"], strategy)
+		}
+	}
+	
+	// Weights can make latency irrelevant
+	testConfigs[0].Weight = 20
+	instanceManager, err := instance.NewManager(testConfigs, "composite", &MockStateStore{}, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{
+		Strategy:         "composite",
+		CompositeWeights: config.CompositeWeights{Weight: 1},
+	})
+	for name, latency := range latencies {
+		for i := 0; i < 20; i++ {
+			instanceManager.RecordLatency(name, latency)
+		}
+	}
+	instanceName, err := instanceManager.SelectInstance(context.Background(), "gpt-4o", 100, "azure")
+	assert.NoError(t, err)
+	assert.Equal(t, "slow-instance", instanceName)
+}
+
+func TestRoutingRulesOverrideStrategyAndCandidates(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream naming the deployment that answered
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}]}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		testInstanceConfig("us-instance", upstream.URL),
+		testInstanceConfig("eu-instance", upstream.URL),
+		testInstanceConfig("ptu-instance", upstream.URL),
+	}
+	clients := []config.ClientConfig{
+		{Name: "eu-tenant", APIKey: "eu-key"},
+		{Name: "other-tenant", APIKey: "other-key"},
+	}
+	
+	stateStore := &LimitedStateStore{limited: map[string]bool{}}
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{
+		Strategy: "weighted",
+		Rules: []config.RoutingRule{
+			{
+				Name:      "eu-tenant",
+				Match:     config.RoutingRuleMatch{Clients: []string{"eu-tenant"}},
+				Instances: []string{"eu-instance"},
+			},
+			{
+				Name:      "eu-header",
+				Match:     config.RoutingRuleMatch{Headers: map[string]string{"X-Region": "eu"}},
+				Instances: []string{"eu-instance"},
+			},
+			{
+				Name:          "gpt-4o-ptu",
+				Match:         config.RoutingRuleMatch{Models: []string{"gpt-4o"}, Endpoints: []string{"/v1/chat/completions"}},
+				Strategy:      "failover",
+				PriorityOrder: []string{"ptu-instance", "us-instance"},
+			},
 		},
+	})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.Use(middleware.ClientIdentity(clients))
+	v1.POST("/chat/completions", proxyHandler.ChatCompletions)
+	
+	chat := func(key string, headers map[string]string) string {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		return resp.Body.String()
 	}
 	
-	// Create mock stores
-	stateStore := &MockStateStore{}
-	configStore := &MockConfigStore{}
+	// gpt-4o chat fails over from the PTU deployment in the rule's order
+	for i := 0; i < 10; i++ {
+		assert.Contains(t, chat("other-key", nil), "answered by ptu-instance")
+	}
+	stateStore.limited["ptu-instance"] = true
+	for i := 0; i < 10; i++ {
+		assert.Contains(t, chat("other-key", nil), "answered by us-instance")
+	}
 	
-	// Create instance manager
-	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, configStore)
+	// Rules matching the client key or a header pin requests to their candidates
+	for i := 0; i < 10; i++ {
+		assert.Contains(t, chat("eu-key", nil), "answered by eu-instance")
+		assert.Contains(t, chat("other-key", map[string]string{"X-Region": "eu"}), "answered by eu-instance")
+	}
+	
+	// Without capacity among its candidates a pinned request is not sent elsewhere
+	stateStore.limited["eu-instance"] = true
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer eu-key")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 503, resp.Code)
+}
+
+func TestSpilloverFromProvisionedInstance(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// The PTU deployment reports its utilization, or throttles when it is full
+	var ptuUtilization atomic.Value
+	var ptuThrottled atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		w.Header().Set("Content-Type", "application/json")
+		if deployment == "ptu" {
+			if ptuThrottled.Load() {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error": {"code": "429", "message": "Requests to the deployment have exceeded the provisioned throughput"}}`))
+				return
+			}
+			w.Header().Set("azure-openai-deployment-utilization", ptuUtilization.Load().(string))
+		}
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}]}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{testInstanceConfig("standard", upstream.URL), testInstanceConfig("ptu", upstream.URL)}
+	testConfigs[0].Tier = config.TierStandard
+	testConfigs[1].Tier = config.TierPTU
+	
+	newRouter := func() (*gin.Engine, *instance.Manager) {
+		instanceManager, err := instance.NewManager(testConfigs, "spillover", &MockStateStore{}, &MockConfigStore{})
+		assert.NoError(t, err)
+		instanceManager.SetRoutingConfig(config.RoutingConfig{
+			Strategy:  "spillover",
+			Spillover: config.SpilloverConfig{UtilizationThreshold: 90},
+		})
+		router := gin.New()
+		router.POST("/v1/chat/completions", handlers.NewProxyHandler(instanceManager).ChatCompletions)
+		return router, instanceManager
+	}
+	chat := func(router *gin.Engine) string {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		return resp.Body.String()
+	}
+	
+	// The PTU takes all traffic while below the threshold
+	router, instanceManager := newRouter()
+	ptuUtilization.Store("40.5%")
+	for i := 0; i < 5; i++ {
+		assert.Contains(t, chat(router), "answered by ptu")
+	}
+	
+	// Once it reports high utilization, new requests spill over
+	ptuUtilization.Store("95%")
+	assert.Contains(t, chat(router), "answered by ptu")
+	for i := 0; i < 3; i++ {
+		assert.Contains(t, chat(router), "answered by standard")
+	}
+	stats := instanceManager.SpilloverStats()
+	assert.Equal(t, int64(3), stats["saturated"])
+	assert.Equal(t, int64(0), stats["after_429"])
+	
+	// A 429 from the PTU is resent to the standard instance instead of failing
+	router, instanceManager = newRouter()
+	ptuUtilization.Store("40%")
+	ptuThrottled.Store(true)
+	assert.Contains(t, chat(router), "answered by standard")
+	assert.Contains(t, chat(router), "answered by standard")
+	stats = instanceManager.SpilloverStats()
+	assert.Equal(t, int64(1), stats["after_429"])
+	assert.Equal(t, int64(1), stats["saturated"])
+	assert.Equal(t, int64(2), stats["spillovers"])
+}
+
+func TestConsistentHashRoutingKeepsSessionsTogether(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream with a prompt cache per deployment
+	var mu sync.Mutex
+	cachedPrompts := make(map[string]bool)
+	var misses int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages, _ := json.Marshal(payload["messages"].([]interface{})[0])
+		
+		mu.Lock()
+		cachedTokens := 80
+		if !cachedPrompts[deployment+string(messages)] {
+			cachedPrompts[deployment+string(messages)] = true
+			cachedTokens = 0
+			misses++
+		}
+		mu.Unlock()
+		
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 100, "completion_tokens": 5, "total_tokens": 105, "prompt_tokens_details": {"cached_tokens": ` + strconv.Itoa(cachedTokens) + `}}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		testInstanceConfig("instance-a", upstream.URL),
+		testInstanceConfig("instance-b", upstream.URL),
+		testInstanceConfig("instance-c", upstream.URL),
+	}
+	
+	stateStore := &LimitedStateStore{limited: map[string]bool{}}
+	instanceManager, err := instance.NewManager(testConfigs, "consistent_hash", stateStore, &MockConfigStore{})
 	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{Strategy: "consistent_hash"})
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
 	
-	// Create proxy handler
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	
+	requests := 0
+	chat := func(body string, session string) string {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.Header.Set("x-session-id", session)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		requests++
+		
+		var response map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		choice := response["choices"].([]interface{})[0].(map[string]interface{})
+		return strings.TrimPrefix(choice["message"].(map[string]interface{})["content"].(string), "answered by ")
+	}
+	
+	// A session header, the user field, or a shared prompt prefix each keep
+	// requests on one instance
+	sessionInstance := chat(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`, "session-1")
+	userInstance := chat(`{"model": "gpt-4o", "user": "alice", "messages": [{"role": "user", "content": "hello"}]}`, "")
+	prefixInstance := chat(`{"model": "gpt-4o", "messages": [{"role": "system", "content": "You are a long system prompt."}, {"role": "user", "content": "question"}]}`, "")
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, sessionInstance, chat(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`, "session-1"))
+		assert.Equal(t, userInstance, chat(`{"model": "gpt-4o", "user": "alice", "messages": [{"role": "user", "content": "hello"}]}`, ""))
+		assert.Equal(t, prefixInstance, chat(`{"model": "gpt-4o", "messages": [{"role": "system", "content": "You are a long system prompt."}, {"role": "user", "content": "question"}, {"role": "assistant", "content": "answer"}, {"role": "user", "content": "follow-up `+strconv.Itoa(i)+`"}]}`, ""))
+	}
+	
+	// When its instance is unavailable the session moves, and returns once it recovers
+	stateStore.limited[sessionInstance] = true
+	assert.NotEqual(t, sessionInstance, chat(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`, "session-1"))
+	stateStore.limited[sessionInstance] = false
+	assert.Equal(t, sessionInstance, chat(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`, "session-1"))
+	
+	// The prompt cache hit rate is measured from the reported cached tokens
+	stats := instanceManager.PromptCacheStats()
+	hits := int64(requests) - misses
+	assert.Equal(t, int64(requests), stats["requests"])
+	assert.Equal(t, hits, stats["hits"])
+	assert.Equal(t, int64(100*requests), stats["prompt_tokens"])
+	assert.Equal(t, 80*hits, stats["cached_tokens"])
+	assert.Greater(t, stats["hit_rate"].(float64), 0.5)
+	
+	// Different sessions spread across all instances
+	spread := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		ctx := instance.WithRequestRoute(context.Background(), instance.RequestRoute{SessionKey: "session:" + strconv.Itoa(i)})
+		instanceName, err := instanceManager.SelectInstance(ctx, "gpt-4o", 0, "azure")
+		assert.NoError(t, err)
+		spread[instanceName]++
+	}
+	for _, cfg := range testConfigs {
+		assert.Greater(t, spread[cfg.Name], 50, cfg.Name)
+	}
+}
+
+func TestOutlierEjectionAndSlowStart(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream where instance-b fails until it is fixed
+	var failing atomic.Bool
+	failing.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		w.Header().Set("Content-Type", "application/json")
+		if deployment == "instance-b" && failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": {"message": "internal error"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 10, "completion_tokens": 1, "total_tokens": 11}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{testInstanceConfig("instance-a", upstream.URL), testInstanceConfig("instance-b", upstream.URL)}
+	
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", &LimitedStateStore{limited: map[string]bool{}}, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceManager.SetRoutingConfig(config.RoutingConfig{
+		Strategy:  "weighted",
+		SlowStart: config.SlowStartConfig{Enabled: true, WindowSeconds: 60, MinWeightPercent: 10},
+		OutlierDetection: config.OutlierDetectionConfig{
+			Enabled:             true,
+			IntervalSeconds:     0.2,
+			MinRequests:         5,
+			BaseEjectionSeconds: 0.5,
+		},
+	})
 	proxyHandler := handlers.NewProxyHandler(instanceManager)
 	
-	// Setup test router
 	router := gin.New()
-	router.Use(middleware.RequestID())
 	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
 	
-	// Test invalid JSON request
-	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString("invalid json"))
+	selectCounts := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			instanceName, err := instanceManager.SelectInstance(context.Background(), "gpt-4o", 0, "azure")
+			assert.NoError(t, err)
+			counts[instanceName]++
+		}
+		return counts
+	}
+	
+	// instance-b fails its share of the traffic during the first interval
+	instanceManager.SelectInstance(context.Background(), "gpt-4o", 0, "azure")
+	for i := 0; i < 40; i++ {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	
+	// The next interval ejects it, and the healthy instance takes all traffic
+	time.Sleep(250 * time.Millisecond)
+	counts := selectCounts(50)
+	assert.Equal(t, 50, counts["instance-a"])
+	stats := instanceManager.OutlierStats()
+	assert.Equal(t, int64(1), stats["ejections"])
+	assert.Equal(t, true, stats["instances"].(map[string]interface{})["instance-b"].(map[string]interface{})["ejected"])
+	
+	// Back from ejection it starts with a fraction of its weight
+	failing.Store(false)
+	time.Sleep(600 * time.Millisecond)
+	counts = selectCounts(400)
+	assert.Greater(t, counts["instance-b"], 0)
+	assert.Less(t, counts["instance-b"], 100)
+	stats = instanceManager.OutlierStats()
+	factor := stats["instances"].(map[string]interface{})["instance-b"].(map[string]interface{})["weight_factor"].(float64)
+	assert.Less(t, factor, 0.2)
+}
+
+func TestInstanceDrainAndMaintenanceWindows(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream that holds instance-a's responses until released
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		if deployment == "instance-a" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 10, "completion_tokens": 1, "total_tokens": 11}}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{testInstanceConfig("instance-a", upstream.URL), testInstanceConfig("instance-b", upstream.URL)}
+	testConfigs[1].Priority = 1
+	
+	instanceManager, err := instance.NewManager(testConfigs, "failover", &LimitedStateStore{limited: map[string]bool{}}, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	adminHandler := handlers.NewAdminHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	router.GET("/admin/instances/:name", adminHandler.GetInstance)
+	router.POST("/admin/instances/:name/drain", adminHandler.DrainInstance)
+	router.GET("/admin/instances/:name/drain", adminHandler.GetDrainStatus)
+	router.DELETE("/admin/instances/:name/drain", adminHandler.ResumeInstance)
+	
+	chat := func() string {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		
+		var response map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		choice := response["choices"].([]interface{})[0].(map[string]interface{})
+		return strings.TrimPrefix(choice["message"].(map[string]interface{})["content"].(string), "answered by ")
+	}
+	admin := func(method, path string) map[string]interface{} {
+		req, _ := http.NewRequest(method, path, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		
+		var response map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		return response["drain"].(map[string]interface{})
+	}
+	
+	// A request is in flight on instance-a when it is drained
+	inFlight := make(chan string)
+	go func() { inFlight <- chat() }()
+	assert.Eventually(t, func() bool { return instanceManager.InFlightRequests("instance-a") == 1 }, 2*time.Second, 5*time.Millisecond)
+	
+	drain := admin("POST", "/admin/instances/instance-a/drain")
+	assert.Equal(t, true, drain["draining"])
+	assert.Equal(t, "admin", drain["reason"])
+	assert.Equal(t, float64(1), drain["in_flight"])
+	assert.Equal(t, false, drain["complete"])
+	
+	// New requests go elsewhere while the one in flight finishes
+	assert.Equal(t, "instance-b", chat())
+	close(release)
+	assert.Equal(t, "instance-a", <-inFlight)
+	assert.Equal(t, true, admin("GET", "/admin/instances/instance-a/drain")["complete"])
+	assert.Equal(t, true, admin("GET", "/admin/instances/instance-a")["draining"])
+	
+	// Resuming puts it back into rotation
+	assert.Equal(t, false, admin("DELETE", "/admin/instances/instance-a/drain")["draining"])
+	assert.Equal(t, "instance-a", chat())
+	
+	// An instance inside a maintenance window is drained until it closes
+	schedule, err := config.ParseSchedule("0 2 * * 0")
+	assert.NoError(t, err)
+	assert.True(t, schedule.Matches(time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC)))  // Sunday
+	assert.False(t, schedule.Matches(time.Date(2024, 6, 3, 2, 0, 0, 0, time.UTC))) // Monday
+	_, err = config.ParseSchedule("61 * * * *")
+	assert.Error(t, err)
+	
+	window := config.MaintenanceWindow{Schedule: "0 2 * * 0", DurationMinutes: 90}
+	active, until := window.ActiveAt(schedule, time.Date(2024, 6, 2, 3, 15, 0, 0, time.UTC))
+	assert.True(t, active)
+	assert.Equal(t, time.Date(2024, 6, 2, 3, 30, 0, 0, time.UTC), until)
+	active, _ = window.ActiveAt(schedule, time.Date(2024, 6, 2, 3, 30, 0, 0, time.UTC))
+	assert.False(t, active)
+	
+	testConfigs[0].MaintenanceWindows = []config.MaintenanceWindow{{Schedule: "* * * * *", DurationMinutes: 1}}
+	maintenanceManager, err := instance.NewManager(testConfigs, "failover", &LimitedStateStore{limited: map[string]bool{}}, &MockConfigStore{})
+	assert.NoError(t, err)
+	instanceName, err := maintenanceManager.SelectInstance(context.Background(), "gpt-4o", 0, "azure")
+	assert.NoError(t, err)
+	assert.Equal(t, "instance-b", instanceName)
+	status := maintenanceManager.DrainStatus("instance-a")
+	assert.Equal(t, "maintenance", status["reason"])
+	assert.Equal(t, true, status["complete"])
+}
+
+func TestCostAccountingAndCheapestRouting(t *testing.T) {
+	tiktoken.SetBpeLoader(byteLevelBpeLoader{})
+	
+	// Fake Azure upstream reporting the same usage for every request, at the
+	// end of streams only if asked to. Like Azure, API versions before
+	// 2024-09-01-preview reject stream_options.
+	usage := `{"prompt_tokens": 1000, "completion_tokens": 500, "total_tokens": 1500, "prompt_tokens_details": {"cached_tokens": 400}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deployment := strings.Split(strings.TrimPrefix(r.URL.Path, "/openai/deployments/"), "/")[0]
+		var body struct {
+			Stream        bool `json:"stream"`
+			StreamOptions *struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.StreamOptions != nil && r.URL.Query().Get("api-version") < "2024-09-01-preview" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "BadRequest", "message": "Unrecognized request argument supplied: stream_options"}}`))
+			return
+		}
+		includeUsage := body.StreamOptions != nil && body.StreamOptions.IncludeUsage
+		if !body.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "answered by ` + deployment + `"}, "finish_reason": "stop"}], "usage": ` + usage + `}`))
+			return
+		}
+		
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunkUsage := ""
+		if includeUsage {
+			chunkUsage = `, "usage": null`
+		}
+		fmt.Fprintf(w, "data: {\"id\": \"chatcmpl-2\", \"object\": \"chat.completion.chunk\", \"choices\": [{\"index\": 0, \"delta\": {\"role\": \"assistant\", \"content\": \"answered by %s\"}, \"finish_reason\": \"stop\"}]%s}\n\n", deployment, chunkUsage)
+		if includeUsage {
+			fmt.Fprintf(w, "data: {\"id\": \"chatcmpl-2\", \"object\": \"chat.completion.chunk\", \"choices\": [], \"usage\": %s}\n\n", usage)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{testInstanceConfig("instance-dear", upstream.URL), testInstanceConfig("instance-cheap", upstream.URL)}
+	testConfigs[0].APIVersion = "2024-10-21"
+	testConfigs[0].Pricing = map[string]config.ModelPrice{"gpt-4o": {InputPer1K: 0.005, OutputPer1K: 0.015}}
+	testConfigs[1].Priority = 1
+	testConfigs[1].Pricing = map[string]config.ModelPrice{"gpt-4o": {InputPer1K: 0.0025, CachedInputPer1K: 0.00125, OutputPer1K: 0.01}}
+	
+	stateStore := &LimitedStateStore{limited: map[string]bool{}}
+	configStore, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "proxy.db"))
+	assert.NoError(t, err)
+	defer configStore.Close()
+	instanceManager, err := instance.NewManager(testConfigs, "cheapest", stateStore, configStore)
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	adminHandler := handlers.NewAdminHandler(instanceManager)
+	
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if name := c.GetHeader("x-test-client"); name != "" {
+			c.Set("client", &config.ClientConfig{Name: name})
+		}
+	})
+	router.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
+	router.GET("/admin/costs", adminHandler.GetCosts)
+	
+	chat := func(client string) string {
+		req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-test-client", client)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 200, resp.Code)
+		
+		var response map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		choice := response["choices"].([]interface{})[0].(map[string]interface{})
+		return strings.TrimPrefix(choice["message"].(map[string]interface{})["content"].(string), "answered by ")
+	}
+	
+	// The cheapest instance wins over the higher priority one while it has capacity
+	assert.Equal(t, "instance-cheap", chat("team-a"))
+	assert.Equal(t, "instance-cheap", chat("team-a"))
+	assert.Equal(t, "instance-cheap", chat(""))
+	stateStore.limited["instance-cheap"] = true
+	assert.Equal(t, "instance-dear", chat("team-b"))
+	
+	// A stream is costed from the usage chunk the proxy asks for, which the
+	// client did not ask for and does not get
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hello"}]}`))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-test-client", "team-b")
 	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by instance-dear")
+	assert.Contains(t, resp.Body.String(), "data: [DONE]")
+	assert.NotContains(t, resp.Body.String(), "usage")
 	
+	// Costs are priced from the reported usage, cached prompt tokens at their own price
+	cheapCost := (600*0.0025 + 400*0.00125 + 500*0.01) / 1000
+	dearCost := (1000*0.005 + 500*0.015) / 1000
+	stats, err := instanceManager.CostStats(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), stats["requests"])
+	assert.InDelta(t, 3*cheapCost+2*dearCost, stats["cost"].(float64), 1e-9)
+	assert.Equal(t, int64(2000), stats["cached_tokens"])
+	
+	byInstance := stats["by_instance"].(map[string]interface{})
+	assert.InDelta(t, 3*cheapCost, byInstance["instance-cheap"].(map[string]interface{})["cost"].(float64), 1e-9)
+	assert.InDelta(t, 2*dearCost, byInstance["instance-dear"].(map[string]interface{})["cost"].(float64), 1e-9)
+	byClient := stats["by_client"].(map[string]interface{})
+	assert.InDelta(t, 2*cheapCost, byClient["team-a"].(map[string]interface{})["cost"].(float64), 1e-9)
+	assert.InDelta(t, cheapCost, byClient["anonymous"].(map[string]interface{})["cost"].(float64), 1e-9)
+	assert.InDelta(t, 2*dearCost, byClient["team-b"].(map[string]interface{})["cost"].(float64), 1e-9)
+	assert.Len(t, stats["breakdown"], 3)
+	
+	// The admin endpoint reports a month's costs
+	month := time.Now().UTC().Format("2006-01")
+	req, _ = http.NewRequest("GET", "/admin/costs?month="+month, nil)
+	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var monthly map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &monthly)
+	assert.Equal(t, float64(5), monthly["requests"])
+	assert.Equal(t, []interface{}{month}, monthly["months"])
 	
+	req, _ = http.NewRequest("GET", "/admin/costs?month=october", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
 	assert.Equal(t, 400, resp.Code)
 	
-	var errorResponse map[string]interface{}
-	err = json.Unmarshal(resp.Body.Bytes(), &errorResponse)
+	// Totals are kept in the config store and survive a restart
+	restarted, err := instance.NewManager(testConfigs, "cheapest", stateStore, configStore)
 	assert.NoError(t, err)
-	assert.Contains(t, errorResponse, "error")
+	stats, err = restarted.CostStats(context.Background(), month)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), stats["requests"])
+	assert.InDelta(t, 3*cheapCost+2*dearCost, stats["cost"].(float64), 1e-9)
+	
+	// An instance on an API version without stream_options is not asked for
+	// usage; its streams are costed from estimates
+	stateStore.limited["instance-cheap"] = false
+	req, _ = http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hello"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "answered by instance-cheap")
+	
+	stats, err = instanceManager.CostStats(context.Background(), month)
+	assert.NoError(t, err)
+	cheapStats := stats["by_instance"].(map[string]interface{})["instance-cheap"].(map[string]interface{})
+	assert.Equal(t, int64(4), cheapStats["requests"])
+	assert.Greater(t, cheapStats["input_tokens"], int64(3000))
+	assert.Greater(t, cheapStats["output_tokens"], int64(1500))
+	
+	// Stats report the month's totals without the full breakdown, and still
+	// work when costs cannot be read
+	all, err := instanceManager.GetStats(context.Background())
+	assert.NoError(t, err)
+	costs := all["costs"].(map[string]interface{})
+	assert.Equal(t, month, costs["month"])
+	assert.Equal(t, int64(6), costs["requests"])
+	assert.NotContains(t, costs, "breakdown")
+	
+	configStore.Close()
+	all, err = instanceManager.GetStats(context.Background())
+	assert.NoError(t, err)
+	assert.NotContains(t, all, "costs")
+}
+
+func TestImageGenerationLimitsAndStats(t *testing.T) {
+	startFakeRedis(t)
+	
+	// Fake Azure upstream returning image URLs, without a model
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"created": 1700000000, "data": [{"url": "https://images.example.com/1.png"}]}`))
+	}))
+	defer upstream.Close()
+	
+	testConfigs := []config.InstanceConfig{
+		{
+			Name:               "test-instance",
+			ProviderType:       "azure",
+			APIKey:             "test-key",
+			APIBase:            upstream.URL,
+			Weight:             10,
+			MaxTPM:             60000,
+			MaxImagesPerMinute: 3,
+			SupportedModels:    []string{"dall-e-3"},
+			ModelDeployments:   map[string]string{"dall-e-3": "dalle-deployment"},
+			Enabled:            true,
+			RateLimitEnabled:   true,
+			TimeoutSeconds:     30.0,
+		},
+	}
+	
+	stateStore := &RecordingStateStore{states: make(map[string]*config.InstanceState)}
+	instanceManager, err := instance.NewManager(testConfigs, "weighted", stateStore, &MockConfigStore{})
+	assert.NoError(t, err)
+	proxyHandler := handlers.NewProxyHandler(instanceManager)
+	
+	router := gin.New()
+	router.POST("/v1/images/generations", proxyHandler.ImageGenerations)
+	
+	generate := func(payload string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/images/generations", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	
+	// Image responses are relayed without a model
+	resp := generate(`{"model": "dall-e-3", "prompt": "a lighthouse", "n": 2, "size": "1024x1792", "quality": "hd"}`)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "https://images.example.com/1.png")
+	assert.NotContains(t, resp.Body.String(), `"model"`)
+	
+	resp = generate(`{"model": "dall-e-3", "prompt": "a harbour"}`)
+	assert.Equal(t, 200, resp.Code)
+	
+	// The images per minute are used up
+	resp = generate(`{"model": "dall-e-3", "prompt": "a boat"}`)
+	assert.Equal(t, 429, resp.Code)
+	assert.Equal(t, int32(2), upstreamCalls.Load())
+	
+	// Generated images are counted per size and quality
+	state, err := instanceManager.GetInstanceState(context.Background(), "test-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, state.TotalImagesGenerated)
+	assert.Equal(t, map[string]int{"1024x1792/hd": 2, "1024x1024/standard": 1}, state.ImagesBySizeQuality)
 }
 
 func TestAdminEndpoints(t *testing.T) {
@@ -143,6 +2439,172 @@ func TestAdminEndpoints(t *testing.T) {
 	assert.Contains(t, response, "instances")
 }
 
+func TestConfigOverlayMergesInstancesByName(t *testing.T) {
+	configDir := t.TempDir()
+	
+	base := `port: 8080
+routing:
+  strategy: "weighted"
+instances:
+  - name: "azure-primary"
+    provider_type: "azure"
+    api_key: "primary-key"
+    api_base: "https://primary.openai.azure.com"
+    weight: 10
+    max_tpm: 60000
+    timeout_seconds: 30
+    model_deployments:
+      "gpt-4": "gpt-4-deployment"
+  - name: "azure-secondary"
+    provider_type: "azure"
+    api_key: "secondary-key"
+    api_base: "https://secondary.openai.azure.com"
+    weight: 5
+    max_tpm: 30000
+    timeout_seconds: 30
+`
+	overlay := `instances:
+  - name: "azure-tertiary"
+    provider_type: "azure"
+    api_key: "tertiary-key"
+    api_base: "https://tertiary.openai.azure.com"
+    weight: 1
+    max_tpm: 10000
+    timeout_seconds: 30
+  - name: "azure-primary"
+    max_tpm: 120000
+    weight: 15
+  - name: "azure-secondary"
+    _delete: true
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "base.yaml"), []byte(base), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "staging.yaml"), []byte(overlay), 0644))
+	t.Setenv("ENVIRONMENT", "staging")
+	
+	cfg, err := config.NewLoader().LoadConfig(configDir)
+	assert.NoError(t, err)
+	
+	if assert.Len(t, cfg.Instances, 2) {
+		primary := cfg.Instances[0]
+		assert.Equal(t, "azure-primary", primary.Name)
+		assert.Equal(t, 120000, primary.MaxTPM)
+		assert.Equal(t, 15, primary.Weight)
+		assert.Equal(t, "primary-key", primary.APIKey)
+		assert.Equal(t, "https://primary.openai.azure.com", primary.APIBase)
+		assert.Equal(t, "gpt-4-deployment", primary.ModelDeployments["gpt-4"])
+		
+		assert.Equal(t, "azure-tertiary", cfg.Instances[1].Name)
+	}
+}
+
+func TestConfigOverlayReplacesInstance(t *testing.T) {
+	configDir := t.TempDir()
+	
+	base := `port: 8080
+routing:
+  strategy: "weighted"
+instances:
+  - name: "azure-primary"
+    provider_type: "azure"
+    api_key: "primary-key"
+    api_base: "https://primary.openai.azure.com"
+    weight: 10
+    max_tpm: 60000
+    timeout_seconds: 30
+    model_deployments:
+      "gpt-4": "gpt-4-deployment"
+  - name: "azure-secondary"
+    provider_type: "azure"
+    api_key: "secondary-key"
+    api_base: "https://secondary.openai.azure.com"
+    weight: 5
+    max_tpm: 30000
+    timeout_seconds: 30
+`
+	overlay := `instances:
+  - name: "azure-primary"
+    _replace: true
+    provider_type: "azure"
+    api_key: "replacement-key"
+    api_base: "https://replacement.openai.azure.com"
+    weight: 3
+    max_tpm: 5000
+    timeout_seconds: 30
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "base.yaml"), []byte(base), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "staging.yaml"), []byte(overlay), 0644))
+	t.Setenv("ENVIRONMENT", "staging")
+	
+	cfg, err := config.NewLoader().LoadConfig(configDir)
+	assert.NoError(t, err)
+	
+	// The replaced entry keeps its position but none of the base fields
+	if assert.Len(t, cfg.Instances, 2) {
+		primary := cfg.Instances[0]
+		assert.Equal(t, "azure-primary", primary.Name)
+		assert.Equal(t, "replacement-key", primary.APIKey)
+		assert.Equal(t, "https://replacement.openai.azure.com", primary.APIBase)
+		assert.Equal(t, 3, primary.Weight)
+		assert.Equal(t, 5000, primary.MaxTPM)
+		assert.Empty(t, primary.ModelDeployments)
+		
+		secondary := cfg.Instances[1]
+		assert.Equal(t, "azure-secondary", secondary.Name)
+		assert.Equal(t, "secondary-key", secondary.APIKey)
+		assert.Equal(t, 30000, secondary.MaxTPM)
+	}
+}
+
+func TestConfigOverlayMarkersWithoutBaseInstances(t *testing.T) {
+	configDir := t.TempDir()
+	
+	base := `port: 8080
+routing:
+  strategy: "weighted"
+`
+	overlay := `instances:
+  - name: "azure-primary"
+    _replace: true
+    provider_type: "azure"
+    api_key: "primary-key"
+    api_base: "https://primary.openai.azure.com"
+    weight: 10
+    max_tpm: 60000
+    timeout_seconds: 30
+  - name: "azure-retired"
+    _delete: true
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "base.yaml"), []byte(base), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "staging.yaml"), []byte(overlay), 0644))
+	t.Setenv("ENVIRONMENT", "staging")
+	
+	cfg, err := config.NewLoader().LoadConfig(configDir)
+	assert.NoError(t, err)
+	
+	// Deleted entries are dropped even with nothing to delete in the base
+	if assert.Len(t, cfg.Instances, 1) {
+		assert.Equal(t, "azure-primary", cfg.Instances[0].Name)
+		assert.Equal(t, "primary-key", cfg.Instances[0].APIKey)
+	}
+}
+
+// testInstanceConfig returns an enabled Azure instance serving gpt-4o from a
+// deployment named after the instance
+func testInstanceConfig(name, apiBase string) config.InstanceConfig {
+	return config.InstanceConfig{
+		Name:             name,
+		ProviderType:     "azure",
+		APIKey:           "test-key",
+		APIBase:          apiBase,
+		Weight:           10,
+		MaxTPM:           60000,
+		SupportedModels:  []string{"gpt-4o"},
+		ModelDeployments: map[string]string{"gpt-4o": name},
+		Enabled:          true,
+		TimeoutSeconds:   30.0,
+	}
+}
+
 // Mock implementations for testing
 
 type MockStateStore struct{}
@@ -173,6 +2635,64 @@ func (m *MockStateStore) Close() error {
 	return nil
 }
 
+// GatedStateStore reports instances as rate limited except for a number of
+// allowed healthy reads
+type GatedStateStore struct {
+	MockStateStore
+	healthyReads int
+	mutex        sync.Mutex
+}
+
+func (g *GatedStateStore) AllowHealthyReads(reads int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.healthyReads += reads
+}
+
+func (g *GatedStateStore) Get(ctx context.Context, instanceName string) (*config.InstanceState, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	
+	state := config.NewInstanceState(instanceName)
+	if g.healthyReads > 0 {
+		g.healthyReads--
+	} else {
+		state.Status = config.StatusRateLimited
+	}
+	return state, nil
+}
+
+// LimitedStateStore reports the listed instances as rate limited
+type LimitedStateStore struct {
+	MockStateStore
+	limited map[string]bool
+}
+
+func (l *LimitedStateStore) Get(ctx context.Context, instanceName string) (*config.InstanceState, error) {
+	state := config.NewInstanceState(instanceName)
+	if l.limited[instanceName] {
+		state.Status = config.StatusRateLimited
+	}
+	return state, nil
+}
+
+type MockResponseCache struct {
+	entries map[string][]byte
+}
+
+func (m *MockResponseCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return m.entries[key], nil
+}
+
+func (m *MockResponseCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.entries[key] = value
+	return nil
+}
+
+func (m *MockResponseCache) Close() error {
+	return nil
+}
+
 type MockConfigStore struct{}
 
 func (m *MockConfigStore) SaveConfig(ctx context.Context, config *config.AppConfig) error {
@@ -199,6 +2719,160 @@ func (m *MockConfigStore) ListInstanceConfigs(ctx context.Context) ([]string, er
 	return []string{"test-instance"}, nil
 }
 
+func (m *MockConfigStore) SaveAffinity(ctx context.Context, kind, objectID, instanceName, owner string) error {
+	return nil
+}
+
+func (m *MockConfigStore) LoadAffinity(ctx context.Context, kind, objectID string) (string, string, error) {
+	return "test-instance", "", nil
+}
+
+func (m *MockConfigStore) DeleteAffinity(ctx context.Context, kind, objectID string) error {
+	return nil
+}
+
+func (m *MockConfigStore) SaveBatchJob(ctx context.Context, job *config.BatchJob) error {
+	return nil
+}
+
+func (m *MockConfigStore) ListBatchJobs(ctx context.Context) ([]config.BatchJob, error) {
+	return []config.BatchJob{}, nil
+}
+
+func (m *MockConfigStore) AddCostUsage(ctx context.Context, usage *config.CostUsage) error {
+	return nil
+}
+
+func (m *MockConfigStore) ListCostUsage(ctx context.Context) ([]config.CostUsage, error) {
+	return []config.CostUsage{}, nil
+}
+
 func (m *MockConfigStore) Close() error {
 	return nil
-}
\ No newline at end of file
+}
+// RecordingStateStore keeps the instance states written to it
+type RecordingStateStore struct {
+	MockStateStore
+	states map[string]*config.InstanceState
+	mutex  sync.Mutex
+}
+
+func (r *RecordingStateStore) Get(ctx context.Context, instanceName string) (*config.InstanceState, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	
+	if state, exists := r.states[instanceName]; exists {
+		copied := *state
+		return &copied, nil
+	}
+	return config.NewInstanceState(instanceName), nil
+}
+
+func (r *RecordingStateStore) Set(ctx context.Context, instanceName string, state *config.InstanceState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	
+	copied := *state
+	r.states[instanceName] = &copied
+	return nil
+}
+
+// startFakeRedis serves the sorted set commands used by the rate limiters on
+// the address the instance manager connects to, skipping the test if it is taken
+func startFakeRedis(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:6379")
+	if err != nil {
+		t.Skipf("cannot listen on the Redis port: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	
+	sets := make(map[string]map[string]float64)
+	var mutex sync.Mutex
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					args, err := readRedisCommand(reader)
+					if err != nil {
+						return
+					}
+					mutex.Lock()
+					reply := fakeRedisReply(sets, args)
+					mutex.Unlock()
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+// readRedisCommand reads a command sent as a RESP array of bulk strings
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	args := make([]string, count)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		value, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(value, "\r\n")
+	}
+	return args, nil
+}
+
+// fakeRedisReply executes a command against the sorted sets
+func fakeRedisReply(sets map[string]map[string]float64, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "ZADD":
+		if sets[args[1]] == nil {
+			sets[args[1]] = make(map[string]float64)
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			sets[args[1]][args[i+1]] = score
+		}
+		return ":1\r\n"
+	case "ZREMRANGEBYSCORE":
+		max, _ := strconv.ParseFloat(args[3], 64)
+		removed := 0
+		for member, score := range sets[args[1]] {
+			if score <= max {
+				delete(sets[args[1]], member)
+				removed++
+			}
+		}
+		return ":" + strconv.Itoa(removed) + "\r\n"
+	case "ZRANGE":
+		var reply strings.Builder
+		reply.WriteString("*" + strconv.Itoa(len(sets[args[1]])*2) + "\r\n")
+		for member, score := range sets[args[1]] {
+			scoreText := strconv.FormatFloat(score, 'f', -1, 64)
+			reply.WriteString("$" + strconv.Itoa(len(member)) + "\r\n" + member + "\r\n")
+			reply.WriteString("$" + strconv.Itoa(len(scoreText)) + "\r\n" + scoreText + "\r\n")
+		}
+		return reply.String()
+	case "DEL":
+		delete(sets, args[1])
+		return ":1\r\n"
+	case "EXPIRE":
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
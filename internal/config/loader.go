@@ -88,6 +88,15 @@ func (l *Loader) deepMerge(base, override map[string]interface{}) map[string]int
 	
 	// Apply overrides
 	for k, v := range override {
+		if keyField, keyed := keyedListFields[k]; keyed {
+			if overrideList, ok := v.([]interface{}); ok {
+				// Without a base list the overlay's merge markers are still
+				// applied and stripped
+				baseList, _ := result[k].([]interface{})
+				result[k] = l.mergeKeyedList(baseList, overrideList, keyField)
+				continue
+			}
+		}
 		if baseVal, exists := result[k]; exists {
 			if baseMap, ok := baseVal.(map[string]interface{}); ok {
 				if overrideMap, ok := v.(map[string]interface{}); ok {
@@ -102,6 +111,118 @@ func (l *Loader) deepMerge(base, override map[string]interface{}) map[string]int
 	return result
 }
 
+// keyedListFields lists the configuration arrays whose elements are objects
+// merged by a key field instead of being replaced wholesale by an overlay
+var keyedListFields = map[string]string{
+	"instances": "name",
+}
+
+// Merge markers recognised on entries of keyed lists in overlay files
+const (
+	// mergeDeleteMarker removes the base entry with the same key
+	mergeDeleteMarker = "_delete"
+	// mergeReplaceMarker replaces the base entry instead of merging its fields
+	mergeReplaceMarker = "_replace"
+)
+
+// mergeKeyedList merges two lists of objects by the given key field.
+//
+// Ordering rules: base entries keep their position and are merged field by
+// field with the overlay entry of the same key; overlay entries with new keys
+// are appended in overlay order. An overlay entry with "_delete: true" drops
+// the base entry, and "_replace: true" swaps it for the overlay entry as-is.
+// Entries without a key are appended unchanged.
+func (l *Loader) mergeKeyedList(base, override []interface{}, keyField string) []interface{} {
+	// Overlay entries in their original order; keyless entries have key ""
+	type overlayEntry struct {
+		key  string
+		item interface{}
+	}
+	
+	overrides := make(map[string]map[string]interface{})
+	var appended []overlayEntry
+	
+	for _, item := range override {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			appended = append(appended, overlayEntry{item: item})
+			continue
+		}
+		key, ok := itemMap[keyField].(string)
+		if !ok || key == "" {
+			appended = append(appended, overlayEntry{item: item})
+			continue
+		}
+		if _, seen := overrides[key]; !seen {
+			appended = append(appended, overlayEntry{key: key})
+		}
+		overrides[key] = itemMap
+	}
+	
+	result := make([]interface{}, 0, len(base)+len(appended))
+	merged := make(map[string]bool)
+	
+	for _, item := range base {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			result = append(result, item)
+			continue
+		}
+		key, _ := itemMap[keyField].(string)
+		overrideMap, exists := overrides[key]
+		if key == "" || !exists {
+			result = append(result, stripMergeMarkers(itemMap))
+			continue
+		}
+		merged[key] = true
+		
+		switch {
+		case isMarkerSet(overrideMap, mergeDeleteMarker):
+			// Dropped by the overlay
+		case isMarkerSet(overrideMap, mergeReplaceMarker):
+			result = append(result, stripMergeMarkers(overrideMap))
+		default:
+			result = append(result, stripMergeMarkers(l.deepMerge(itemMap, overrideMap)))
+		}
+	}
+	
+	// Append new entries in overlay order
+	for _, entry := range appended {
+		if entry.key == "" {
+			result = append(result, entry.item)
+			continue
+		}
+		if merged[entry.key] {
+			continue
+		}
+		overrideMap := overrides[entry.key]
+		if isMarkerSet(overrideMap, mergeDeleteMarker) {
+			continue
+		}
+		result = append(result, stripMergeMarkers(overrideMap))
+	}
+	
+	return result
+}
+
+// isMarkerSet reports whether a merge marker is set to true on an entry
+func isMarkerSet(entry map[string]interface{}, marker string) bool {
+	value, ok := entry[marker].(bool)
+	return ok && value
+}
+
+// stripMergeMarkers returns a copy of the entry without merge markers
+func stripMergeMarkers(entry map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(entry))
+	for k, v := range entry {
+		if k == mergeDeleteMarker || k == mergeReplaceMarker {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
 // resolveEnvVars recursively resolves environment variables in configuration
 func (l *Loader) resolveEnvVars(config interface{}) interface{} {
 	switch v := config.(type) {
@@ -168,16 +289,108 @@ func (l *Loader) validateConfig(config *AppConfig) error {
 		}
 	}
 	
+	// Validate clients
+	clientKeys := make(map[string]bool)
+	for i, client := range config.Clients {
+		if client.Name == "" {
+			return fmt.Errorf("client %d validation failed: client name is required", i)
+		}
+		if client.APIKey == "" {
+			return fmt.Errorf("client %d validation failed: API key is required for client %s", i, client.Name)
+		}
+		if clientKeys[client.APIKey] {
+			return fmt.Errorf("client %d validation failed: duplicate API key for client %s", i, client.Name)
+		}
+		clientKeys[client.APIKey] = true
+	}
+	
+	// Validate models: every name and alias must identify a single model
+	modelNames := make(map[string]string)
+	for i, model := range config.Models {
+		if model.Name == "" {
+			return fmt.Errorf("model %d validation failed: model name is required", i)
+		}
+		for _, name := range append([]string{model.Name}, model.Aliases...) {
+			nameLower := strings.ToLower(name)
+			if owner, exists := modelNames[nameLower]; exists && owner != model.Name {
+				return fmt.Errorf("model %d validation failed: %s is already used by model %s", i, name, owner)
+			}
+			modelNames[nameLower] = model.Name
+		}
+	}
+	for i, model := range config.Models {
+		for _, fallback := range model.Fallbacks {
+			if owner, exists := modelNames[strings.ToLower(fallback)]; exists && owner == model.Name {
+				return fmt.Errorf("model %d validation failed: model %s cannot fall back to itself", i, model.Name)
+			}
+		}
+	}
+	
 	// Validate routing strategy
 	validStrategies := map[string]bool{
-		"failover":    true,
-		"weighted":    true,
-		"round_robin": true,
+		"failover":           true,
+		"weighted":           true,
+		"round_robin":        true,
+		"lowest_latency":     true,
+		"lowest_utilization": true,
+		"composite":          true,
+		"power_of_two":       true,
+		"spillover":          true,
+		"consistent_hash":    true,
+		"cheapest":           true,
 	}
 	if !validStrategies[config.Routing.Strategy] {
 		return fmt.Errorf("invalid routing strategy: %s", config.Routing.Strategy)
 	}
 	
+	ruleNames := make(map[string]bool)
+	for i, rule := range config.Routing.Rules {
+		if err := l.validateRoutingRule(&rule, config.Instances, validStrategies); err != nil {
+			return fmt.Errorf("routing rule %d validation failed: %w", i, err)
+		}
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("routing rule %d validation failed: duplicate rule name: %s", i, rule.Name)
+		}
+		ruleNames[rule.Name] = true
+	}
+	
+	weights := config.Routing.CompositeWeights
+	if weights.Weight < 0 || weights.Utilization < 0 || weights.ErrorRate < 0 || weights.Latency < 0 {
+		return fmt.Errorf("composite weights cannot be negative")
+	}
+	
+	return nil
+}
+
+// validateRoutingRule validates a routing rule against the configured instances
+func (l *Loader) validateRoutingRule(rule *RoutingRule, instances []InstanceConfig, validStrategies map[string]bool) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if rule.Strategy != "" && !validStrategies[rule.Strategy] {
+		return fmt.Errorf("invalid routing strategy: %s", rule.Strategy)
+	}
+	
+	instanceNames := make(map[string]bool)
+	for _, instance := range instances {
+		instanceNames[instance.Name] = true
+	}
+	candidates := make(map[string]bool)
+	for _, name := range rule.Instances {
+		if !instanceNames[name] {
+			return fmt.Errorf("unknown instance: %s", name)
+		}
+		candidates[name] = true
+	}
+	for _, name := range rule.PriorityOrder {
+		if !instanceNames[name] {
+			return fmt.Errorf("unknown instance in priority order: %s", name)
+		}
+		if len(candidates) > 0 && !candidates[name] {
+			return fmt.Errorf("instance %s in priority order is not a candidate of the rule", name)
+		}
+	}
+	
 	return nil
 }
 
@@ -215,6 +428,28 @@ func (l *Loader) validateInstance(instance *InstanceConfig) error {
 		return fmt.Errorf("timeout must be positive for instance %s", instance.Name)
 	}
 	
+	if instance.Tier != "" && instance.Tier != TierPTU && instance.Tier != TierStandard {
+		return fmt.Errorf("invalid tier for instance %s: %s", instance.Name, instance.Tier)
+	}
+	
+	for model, price := range instance.Pricing {
+		if price.InputPer1K < 0 || price.CachedInputPer1K < 0 || price.OutputPer1K < 0 {
+			return fmt.Errorf("prices must not be negative for model %s on instance %s", model, instance.Name)
+		}
+	}
+	
+	for _, window := range instance.MaintenanceWindows {
+		if _, err := ParseSchedule(window.Schedule); err != nil {
+			return fmt.Errorf("invalid maintenance window for instance %s: %w", instance.Name, err)
+		}
+		if window.DurationMinutes <= 0 {
+			return fmt.Errorf("maintenance window duration must be positive for instance %s", instance.Name)
+		}
+		if _, err := window.GetLocation(); err != nil {
+			return fmt.Errorf("invalid maintenance window time zone for instance %s: %w", instance.Name, err)
+		}
+	}
+	
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,22 +17,125 @@ const (
 
 // InstanceConfig represents static configuration for an API instance
 type InstanceConfig struct {
-	Name             string            `json:"name" yaml:"name" validate:"required"`
-	ProviderType     string            `json:"provider_type" yaml:"provider_type" validate:"required,oneof=azure openai"`
-	APIKey           string            `json:"api_key" yaml:"api_key" validate:"required"`
-	APIBase          string            `json:"api_base" yaml:"api_base" validate:"required,url"`
-	APIVersion       string            `json:"api_version" yaml:"api_version"`
-	ProxyURL         *string           `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
-	Priority         int               `json:"priority" yaml:"priority" validate:"min=0"`
-	Weight           int               `json:"weight" yaml:"weight" validate:"min=1"`
-	MaxTPM           int               `json:"max_tpm" yaml:"max_tpm" validate:"min=1"`
-	MaxInputTokens   int               `json:"max_input_tokens" yaml:"max_input_tokens" validate:"min=0"`
-	SupportedModels  []string          `json:"supported_models" yaml:"supported_models"`
-	ModelDeployments map[string]string `json:"model_deployments" yaml:"model_deployments"`
-	Enabled          bool              `json:"enabled" yaml:"enabled"`
-	TimeoutSeconds   float64           `json:"timeout_seconds" yaml:"timeout_seconds" validate:"min=0"`
-	RetryCount       int               `json:"retry_count" yaml:"retry_count" validate:"min=0"`
-	RateLimitEnabled bool              `json:"rate_limit_enabled" yaml:"rate_limit_enabled"`
+	Name                     string                `json:"name" yaml:"name" validate:"required"`
+	ProviderType             string                `json:"provider_type" yaml:"provider_type" validate:"required,oneof=azure openai"`
+	APIKey                   string                `json:"api_key" yaml:"api_key" validate:"required"`
+	APIBase                  string                `json:"api_base" yaml:"api_base" validate:"required,url"`
+	APIVersion               string                `json:"api_version" yaml:"api_version"`
+	ProxyURL                 *string               `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
+	Priority                 int                   `json:"priority" yaml:"priority" validate:"min=0"`
+	Weight                   int                   `json:"weight" yaml:"weight" validate:"min=1"`
+	MaxTPM                   int                   `json:"max_tpm" yaml:"max_tpm" validate:"min=1"`
+	MaxInputTokens           int                   `json:"max_input_tokens" yaml:"max_input_tokens" validate:"min=0"`
+	MaxImagesPerMinute       int                   `json:"max_images_per_minute,omitempty" yaml:"max_images_per_minute,omitempty" validate:"min=0"`               // 0 disables the images limit
+	MaxAudioSecondsPerMinute int                   `json:"max_audio_seconds_per_minute,omitempty" yaml:"max_audio_seconds_per_minute,omitempty" validate:"min=0"` // transcription/translation
+	MaxCharactersPerMinute   int                   `json:"max_characters_per_minute,omitempty" yaml:"max_characters_per_minute,omitempty" validate:"min=0"`       // speech synthesis
+	MaxRealtimeSessions      int                   `json:"max_realtime_sessions,omitempty" yaml:"max_realtime_sessions,omitempty" validate:"min=0"`               // concurrent WebSocket sessions, 0 = unlimited
+	SupportedModels          []string              `json:"supported_models" yaml:"supported_models"`
+	ModelDeployments         map[string]string     `json:"model_deployments" yaml:"model_deployments"`
+	Enabled                  bool                  `json:"enabled" yaml:"enabled"`
+	TimeoutSeconds           float64               `json:"timeout_seconds" yaml:"timeout_seconds" validate:"min=0"`
+	ConnectTimeoutSeconds    float64               `json:"connect_timeout_seconds,omitempty" yaml:"connect_timeout_seconds,omitempty" validate:"min=0"`         // TCP connect and TLS handshake, defaults to 10 seconds
+	FirstByteTimeoutSeconds  float64               `json:"first_byte_timeout_seconds,omitempty" yaml:"first_byte_timeout_seconds,omitempty" validate:"min=0"`   // wait for response headers, 0 = bounded only by the total timeout
+	StreamIdleTimeoutSeconds float64               `json:"stream_idle_timeout_seconds,omitempty" yaml:"stream_idle_timeout_seconds,omitempty" validate:"min=0"` // max gap between stream chunks, defaults to timeout_seconds
+	StreamTimeoutSeconds     float64               `json:"stream_timeout_seconds,omitempty" yaml:"stream_timeout_seconds,omitempty" validate:"min=0"`           // total stream deadline, defaults to 10 minutes
+	RetryCount               int                   `json:"retry_count" yaml:"retry_count" validate:"min=0"`
+	RateLimitEnabled         bool                  `json:"rate_limit_enabled" yaml:"rate_limit_enabled"`
+	BatchEnabled             bool                  `json:"batch_enabled" yaml:"batch_enabled"`                                           // serves the Files and Batch APIs (global-batch deployments)
+	Tier                     string                `json:"tier,omitempty" yaml:"tier,omitempty" validate:"omitempty,oneof=ptu standard"` // ptu for provisioned throughput, defaults to standard
+	MaintenanceWindows       []MaintenanceWindow   `json:"maintenance_windows,omitempty" yaml:"maintenance_windows,omitempty"`           // drained while a window is open
+	Pricing                  map[string]ModelPrice `json:"pricing,omitempty" yaml:"pricing,omitempty"`                                   // prices per model served by this instance
+}
+
+// ModelPrice is the price of a model's tokens per 1K on an instance
+type ModelPrice struct {
+	InputPer1K       float64 `json:"input_per_1k" yaml:"input_per_1k" validate:"min=0"`
+	CachedInputPer1K float64 `json:"cached_input_per_1k,omitempty" yaml:"cached_input_per_1k,omitempty" validate:"min=0"` // prompt tokens served from the prompt cache, defaults to the input price
+	OutputPer1K      float64 `json:"output_per_1k" yaml:"output_per_1k" validate:"min=0"`
+}
+
+// GetCachedInputPer1K returns the price of cached prompt tokens, defaulting
+// to the input price
+func (p ModelPrice) GetCachedInputPer1K() float64 {
+	if p.CachedInputPer1K > 0 {
+		return p.CachedInputPer1K
+	}
+	return p.InputPer1K
+}
+
+// Cost returns the price of a request's tokens. Cached tokens are part of the
+// input tokens.
+func (p ModelPrice) Cost(inputTokens, cachedTokens, outputTokens int) float64 {
+	uncached := inputTokens - cachedTokens
+	if uncached < 0 {
+		uncached = 0
+	}
+	return (float64(uncached)*p.InputPer1K + float64(cachedTokens)*p.GetCachedInputPer1K() + float64(outputTokens)*p.OutputPer1K) / 1000
+}
+
+// GetPrice returns the instance's price for a model, if one is configured
+func (c InstanceConfig) GetPrice(model string) (ModelPrice, bool) {
+	for name, price := range c.Pricing {
+		if strings.EqualFold(name, model) {
+			return price, true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// MaintenanceWindow drains an instance for a duration starting at each time
+// its cron schedule fires
+type MaintenanceWindow struct {
+	Schedule        string `json:"schedule" yaml:"schedule" validate:"required"` // cron expression, e.g. "0 2 * * 0"
+	DurationMinutes int    `json:"duration_minutes" yaml:"duration_minutes" validate:"min=1"`
+	Timezone        string `json:"timezone,omitempty" yaml:"timezone,omitempty"` // IANA time zone of the schedule, defaults to UTC
+}
+
+// Instance tiers used by the spillover strategy
+const (
+	TierPTU      = "ptu"      // provisioned throughput, kept saturated first
+	TierStandard = "standard" // pay-as-you-go, takes the overflow
+)
+
+// IsProvisioned reports whether the instance is a provisioned-throughput deployment
+func (c InstanceConfig) IsProvisioned() bool {
+	return c.Tier == TierPTU
+}
+
+// GetConnectTimeout returns the limit for establishing the upstream
+// connection, defaulting to 10 seconds
+func (c InstanceConfig) GetConnectTimeout() time.Duration {
+	if c.ConnectTimeoutSeconds > 0 {
+		return time.Duration(c.ConnectTimeoutSeconds * float64(time.Second))
+	}
+	return 10 * time.Second
+}
+
+// GetFirstByteTimeout returns how long to wait for the upstream response
+// headers once the request was sent, or 0 for no separate limit
+func (c InstanceConfig) GetFirstByteTimeout() time.Duration {
+	return time.Duration(c.FirstByteTimeoutSeconds * float64(time.Second))
+}
+
+// GetStreamIdleTimeout returns the longest allowed gap between streamed
+// chunks, defaulting to the request timeout (or 60 seconds)
+func (c InstanceConfig) GetStreamIdleTimeout() time.Duration {
+	if c.StreamIdleTimeoutSeconds > 0 {
+		return time.Duration(c.StreamIdleTimeoutSeconds * float64(time.Second))
+	}
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds * float64(time.Second))
+	}
+	return 60 * time.Second
+}
+
+// GetStreamTimeout returns the total deadline for a streamed response,
+// defaulting to 10 minutes
+func (c InstanceConfig) GetStreamTimeout() time.Duration {
+	if c.StreamTimeoutSeconds > 0 {
+		return time.Duration(c.StreamTimeoutSeconds * float64(time.Second))
+	}
+	return 10 * time.Minute
 }
 
 // InstanceState represents dynamic runtime state for an API instance
@@ -88,8 +193,22 @@ type InstanceState struct {
 	CurrentRPM         int                  `json:"current_rpm"`
 	TotalRequests      int                  `json:"total_requests"`
 	SuccessfulRequests int                  `json:"successful_requests"`
+	CancelledRequests  int                  `json:"cancelled_requests"` // the client went away before the response completed
 	TotalTokensServed  int64                `json:"total_tokens_served"`
 	
+	// Image generation
+	TotalImagesGenerated int             `json:"total_images_generated"`
+	ImagesBySizeQuality  map[string]int  `json:"images_by_size_quality"` // "size/quality" -> count
+	
+	// Audio
+	TotalAudioSeconds     int            `json:"total_audio_seconds"`
+	TotalSpeechCharacters int            `json:"total_speech_characters"`
+	
+	// Realtime sessions
+	TotalRealtimeSessions int            `json:"total_realtime_sessions"`
+	RealtimeTextTokens    int64          `json:"realtime_text_tokens"`
+	RealtimeAudioTokens   int64          `json:"realtime_audio_tokens"`
+	
 	// Usage windows (timestamp -> count)
 	UsageWindow   map[int64]int            `json:"usage_window"`
 	RequestWindow map[int64]int            `json:"request_window"`
@@ -126,15 +245,583 @@ func NewInstanceState(name string) *InstanceState {
 		UpstreamOtherWindow:   make(map[int64]int),
 		UsageWindow:           make(map[int64]int),
 		RequestWindow:         make(map[int64]int),
+		ImagesBySizeQuality:   make(map[string]int),
 		LastUsed:              time.Now(),
 	}
 }
 
+// ClientConfig represents a client of the proxy identified by its API key
+type ClientConfig struct {
+	Name           string   `json:"name" yaml:"name" validate:"required"`
+	APIKey         string   `json:"api_key" yaml:"api_key" validate:"required"`
+	AllowedModels  []string `json:"allowed_models,omitempty" yaml:"allowed_models,omitempty"`   // models listed by /v1/models, empty lists all
+	Cache          bool     `json:"cache,omitempty" yaml:"cache,omitempty"`                     // use the response cache without the x-proxy-cache header
+	SemanticCache  bool     `json:"semantic_cache,omitempty" yaml:"semantic_cache,omitempty"`   // also reuse answers to similar chat requests
+	CacheNamespace string   `json:"cache_namespace,omitempty" yaml:"cache_namespace,omitempty"` // clients sharing a namespace share cached answers, defaults to the name
+	Priority       string   `json:"priority,omitempty" yaml:"priority,omitempty"`               // admission queue priority: high, normal (default) or low
+	HedgeBudget    float64  `json:"hedge_budget,omitempty" yaml:"hedge_budget,omitempty"`       // percent of requests that may be hedged, overrides routing.hedging.budget_percent
+}
+
+// GetPriority returns the client's admission queue priority level
+func (c *ClientConfig) GetPriority() int {
+	if priority, ok := ParsePriority(c.Priority); ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// GetCacheNamespace returns the namespace of the client's cached responses
+func (c *ClientConfig) GetCacheNamespace() string {
+	if c.CacheNamespace != "" {
+		return c.CacheNamespace
+	}
+	return c.Name
+}
+
+// AllowsModel checks if the model is listed to the client
+func (c *ClientConfig) AllowsModel(model string) bool {
+	if len(c.AllowedModels) == 0 {
+		return true
+	}
+	
+	modelLower := strings.ToLower(model)
+	for _, allowed := range c.AllowedModels {
+		if strings.ToLower(allowed) == modelLower {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchJob tracks an upstream batch job and the instance that owns it
+type BatchJob struct {
+	ID            string         `json:"id"`
+	Instance      string         `json:"instance"`
+	Client        string         `json:"client,omitempty"`
+	Status        string         `json:"status"`
+	Endpoint      string         `json:"endpoint"`
+	InputFileID   string         `json:"input_file_id"`
+	OutputFileID  string         `json:"output_file_id,omitempty"`
+	ErrorFileID   string         `json:"error_file_id,omitempty"`
+	RequestCounts map[string]int `json:"request_counts,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// CostUsage sums the tokens and cost of the requests a client made for a
+// model on an instance in a month ("2006-01")
+type CostUsage struct {
+	Month            string  `json:"month"`
+	Instance         string  `json:"instance"`
+	Model            string  `json:"model"`
+	Client           string  `json:"client"`
+	Requests         int64   `json:"requests"`
+	InputTokens      int64   `json:"input_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"` // part of InputTokens
+	OutputTokens     int64   `json:"output_tokens"`
+	Cost             float64 `json:"cost"`
+	UnpricedRequests int64   `json:"unpriced_requests"` // requests to instances without a price for the model
+}
+
 // RoutingConfig represents routing strategy configuration
 type RoutingConfig struct {
-	Strategy string `json:"strategy" yaml:"strategy" validate:"oneof=failover weighted round_robin"`
-	Retries  int    `json:"retries" yaml:"retries" validate:"min=0"`
-	Timeout  int    `json:"timeout" yaml:"timeout" validate:"min=1"`
+	Strategy          string                 `json:"strategy" yaml:"strategy" validate:"oneof=failover weighted round_robin lowest_latency lowest_utilization composite power_of_two spillover consistent_hash cheapest"`
+	Retries           int                    `json:"retries" yaml:"retries" validate:"min=0"`
+	Timeout           int                    `json:"timeout" yaml:"timeout" validate:"min=1"`
+	LatencyPercentile float64                `json:"latency_percentile" yaml:"latency_percentile" validate:"min=0,max=100"` // latency percentile compared by the latency-aware strategies
+	CompositeWeights  CompositeWeights       `json:"composite_weights" yaml:"composite_weights"`
+	StreamFailover    StreamFailoverConfig   `json:"stream_failover" yaml:"stream_failover"`
+	Hedging           HedgingConfig          `json:"hedging" yaml:"hedging"`
+	Spillover         SpilloverConfig        `json:"spillover" yaml:"spillover"`
+	Sticky            StickyConfig           `json:"sticky" yaml:"sticky"`
+	SlowStart         SlowStartConfig        `json:"slow_start" yaml:"slow_start"`
+	OutlierDetection  OutlierDetectionConfig `json:"outlier_detection" yaml:"outlier_detection"`
+	Rules             []RoutingRule          `json:"rules,omitempty" yaml:"rules,omitempty"` // evaluated in order, the first match applies
+}
+
+// UsesStrategy reports whether the routing strategy or any routing rule uses the strategy
+func (r RoutingConfig) UsesStrategy(strategy string) bool {
+	if r.Strategy == strategy {
+		return true
+	}
+	for _, rule := range r.Rules {
+		if rule.Strategy == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// SlowStartConfig ramps up the weight of an instance over a window after it
+// recovers from an error, rate limiting or ejection
+type SlowStartConfig struct {
+	Enabled          bool    `json:"enabled" yaml:"enabled"`
+	WindowSeconds    float64 `json:"window_seconds" yaml:"window_seconds" validate:"min=0"`                 // defaults to 60
+	MinWeightPercent float64 `json:"min_weight_percent" yaml:"min_weight_percent" validate:"min=0,max=100"` // weight right after recovery, defaults to 10
+}
+
+// GetWindow returns how long the weight ramps up, defaulting to 60 seconds
+func (s SlowStartConfig) GetWindow() time.Duration {
+	if s.WindowSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(s.WindowSeconds * float64(time.Second))
+}
+
+// GetMinWeightPercent returns the share of its weight an instance starts
+// with after recovery, defaulting to 10
+func (s SlowStartConfig) GetMinWeightPercent() float64 {
+	if s.MinWeightPercent <= 0 {
+		return 10
+	}
+	return s.MinWeightPercent
+}
+
+// OutlierDetectionConfig configures the temporary ejection of instances whose
+// recent error rate or p95 latency deviates from their peers serving the same
+// models
+type OutlierDetectionConfig struct {
+	Enabled             bool    `json:"enabled" yaml:"enabled"`
+	IntervalSeconds     float64 `json:"interval_seconds" yaml:"interval_seconds" validate:"min=0"`                 // how often instances are evaluated, defaults to 10
+	MinRequests         int     `json:"min_requests" yaml:"min_requests" validate:"min=0"`                         // requests in an interval before its error rate counts, defaults to 10
+	ErrorRateDeviation  float64 `json:"error_rate_deviation" yaml:"error_rate_deviation" validate:"min=0,max=100"` // percentage points above the peers' error rate, defaults to 20
+	LatencyFactor       float64 `json:"latency_factor" yaml:"latency_factor" validate:"min=0"`                     // multiple of the peers' median p95 latency, defaults to 3
+	BaseEjectionSeconds float64 `json:"base_ejection_seconds" yaml:"base_ejection_seconds" validate:"min=0"`       // doubled for each consecutive ejection, defaults to 30
+	MaxEjectionSeconds  float64 `json:"max_ejection_seconds" yaml:"max_ejection_seconds" validate:"min=0"`         // defaults to 300
+	MaxEjectionPercent  float64 `json:"max_ejection_percent" yaml:"max_ejection_percent" validate:"min=0,max=100"` // share of instances that may be ejected at once, defaults to 50
+}
+
+// GetInterval returns how often instances are evaluated, defaulting to 10 seconds
+func (o OutlierDetectionConfig) GetInterval() time.Duration {
+	if o.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(o.IntervalSeconds * float64(time.Second))
+}
+
+// GetMinRequests returns the requests an instance needs in an interval for
+// its error rate to be evaluated, defaulting to 10
+func (o OutlierDetectionConfig) GetMinRequests() int {
+	if o.MinRequests <= 0 {
+		return 10
+	}
+	return o.MinRequests
+}
+
+// GetErrorRateDeviation returns how many percentage points an instance's
+// error rate may exceed its peers' before ejection, defaulting to 20
+func (o OutlierDetectionConfig) GetErrorRateDeviation() float64 {
+	if o.ErrorRateDeviation <= 0 {
+		return 20
+	}
+	return o.ErrorRateDeviation
+}
+
+// GetLatencyFactor returns the multiple of the peers' median p95 latency at
+// which an instance is ejected, defaulting to 3
+func (o OutlierDetectionConfig) GetLatencyFactor() float64 {
+	if o.LatencyFactor <= 0 {
+		return 3
+	}
+	return o.LatencyFactor
+}
+
+// GetEjectionDuration returns how long an instance is ejected for its nth
+// consecutive ejection: the base duration doubled each time, up to the maximum
+func (o OutlierDetectionConfig) GetEjectionDuration(ejections int) time.Duration {
+	base, max := o.BaseEjectionSeconds, o.MaxEjectionSeconds
+	if base <= 0 {
+		base = 30
+	}
+	if max <= 0 {
+		max = 300
+	}
+	seconds := base
+	for i := 1; i < ejections && seconds < max; i++ {
+		seconds *= 2
+	}
+	if seconds > max {
+		seconds = max
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// GetMaxEjectionPercent returns the share of instances that may be ejected
+// at once, defaulting to 50
+func (o OutlierDetectionConfig) GetMaxEjectionPercent() float64 {
+	if o.MaxEjectionPercent <= 0 {
+		return 50
+	}
+	return o.MaxEjectionPercent
+}
+
+// StickyConfig controls how the consistent_hash strategy keys requests, so
+// that repeated prompt prefixes reach the same deployment's prompt cache
+type StickyConfig struct {
+	Header         string `json:"header" yaml:"header"`                                    // session header, defaults to x-session-id
+	PrefixMessages int    `json:"prefix_messages" yaml:"prefix_messages" validate:"min=0"` // messages after the system prompt hashed without a session or user, defaults to 1
+}
+
+// GetHeader returns the session header, defaulting to x-session-id
+func (s StickyConfig) GetHeader() string {
+	if s.Header == "" {
+		return "x-session-id"
+	}
+	return s.Header
+}
+
+// GetPrefixMessages returns how many messages after the system prompt are
+// hashed, defaulting to 1
+func (s StickyConfig) GetPrefixMessages() int {
+	if s.PrefixMessages <= 0 {
+		return 1
+	}
+	return s.PrefixMessages
+}
+
+// SpilloverConfig controls when the spillover strategy moves traffic from
+// provisioned-throughput instances to standard ones
+type SpilloverConfig struct {
+	UtilizationThreshold float64 `json:"utilization_threshold" yaml:"utilization_threshold" validate:"min=0,max=100"` // PTU utilization reported by Azure at which to spill over
+	CooldownSeconds      int     `json:"cooldown_seconds" yaml:"cooldown_seconds" validate:"min=0"`                   // how long a 429 without retry-after or a utilization reading holds
+}
+
+// GetUtilizationThreshold returns the PTU utilization percentage at which
+// requests spill over, defaulting to 90
+func (s SpilloverConfig) GetUtilizationThreshold() float64 {
+	if s.UtilizationThreshold <= 0 {
+		return 90
+	}
+	return s.UtilizationThreshold
+}
+
+// GetCooldown returns how long a throttled or busy PTU is passed over,
+// defaulting to 10 seconds
+func (s SpilloverConfig) GetCooldown() time.Duration {
+	if s.CooldownSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(s.CooldownSeconds) * time.Second
+}
+
+// RoutingRule overrides the strategy and candidate instances for matching requests
+type RoutingRule struct {
+	Name          string           `json:"name" yaml:"name"`
+	Match         RoutingRuleMatch `json:"match" yaml:"match"`
+	Strategy      string           `json:"strategy,omitempty" yaml:"strategy,omitempty"`             // defaults to the routing strategy
+	Instances     []string         `json:"instances,omitempty" yaml:"instances,omitempty"`           // candidate instances, defaults to all
+	PriorityOrder []string         `json:"priority_order,omitempty" yaml:"priority_order,omitempty"` // instances in failover order, ahead of unlisted ones
+}
+
+// RoutingRuleMatch selects the requests a routing rule applies to. Empty
+// fields match any request.
+type RoutingRuleMatch struct {
+	Models    []string          `json:"models,omitempty" yaml:"models,omitempty"`
+	Endpoints []string          `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	Clients   []string          `json:"clients,omitempty" yaml:"clients,omitempty"` // client key names
+	Headers   map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // header values that must all be present
+}
+
+// GetLatencyPercentile returns the latency percentile instances are compared
+// by, defaulting to the median
+func (r RoutingConfig) GetLatencyPercentile() float64 {
+	if r.LatencyPercentile <= 0 {
+		return 50
+	}
+	return r.LatencyPercentile
+}
+
+// CompositeWeights sets how much each factor counts towards an instance's
+// score in the composite and power_of_two strategies
+type CompositeWeights struct {
+	Weight      float64 `json:"weight" yaml:"weight" validate:"min=0"`           // configured instance weight
+	Utilization float64 `json:"utilization" yaml:"utilization" validate:"min=0"` // share of the TPM limit still free
+	ErrorRate   float64 `json:"error_rate" yaml:"error_rate" validate:"min=0"`   // share of requests that did not fail
+	Latency     float64 `json:"latency" yaml:"latency" validate:"min=0"`         // latency relative to the fastest candidate
+}
+
+// GetWeights returns the weights, defaulting to 0.3 weight, 0.4 utilization,
+// 0.2 error rate and 0.1 latency when none are set
+func (w CompositeWeights) GetWeights() CompositeWeights {
+	if w.Weight <= 0 && w.Utilization <= 0 && w.ErrorRate <= 0 && w.Latency <= 0 {
+		return CompositeWeights{Weight: 0.3, Utilization: 0.4, ErrorRate: 0.2, Latency: 0.1}
+	}
+	return w
+}
+
+// HedgingConfig controls re-sending slow non-streaming requests to a second
+// instance and using whichever response arrives first
+type HedgingConfig struct {
+	Enabled         bool     `json:"enabled" yaml:"enabled"`
+	Endpoints       []string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`                // defaults to /v1/chat/completions
+	Percentile      float64  `json:"percentile" yaml:"percentile" validate:"min=0,max=100"`         // latency percentile of the first instance to wait for
+	MinDelayMs      int      `json:"min_delay_ms" yaml:"min_delay_ms" validate:"min=0"`             // lower bound of the hedge delay
+	MaxPromptTokens int      `json:"max_prompt_tokens" yaml:"max_prompt_tokens" validate:"min=0"`   // only requests up to this size are hedged
+	BudgetPercent   float64  `json:"budget_percent" yaml:"budget_percent" validate:"min=0,max=100"` // share of a client's requests that may be hedged
+}
+
+// HedgesEndpoint reports whether requests to the endpoint are hedged
+func (h HedgingConfig) HedgesEndpoint(endpoint string) bool {
+	if !h.Enabled {
+		return false
+	}
+	if len(h.Endpoints) == 0 {
+		return endpoint == "/v1/chat/completions"
+	}
+	for _, configured := range h.Endpoints {
+		if configured == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPercentile returns the latency percentile to hedge at, defaulting to 95
+func (h HedgingConfig) GetPercentile() float64 {
+	if h.Percentile <= 0 {
+		return 95
+	}
+	return h.Percentile
+}
+
+// GetMinDelay returns the minimum hedge delay, defaulting to 50 milliseconds
+func (h HedgingConfig) GetMinDelay() time.Duration {
+	if h.MinDelayMs <= 0 {
+		return 50 * time.Millisecond
+	}
+	return time.Duration(h.MinDelayMs) * time.Millisecond
+}
+
+// GetMaxPromptTokens returns the largest hedged request, defaulting to 2000 tokens
+func (h HedgingConfig) GetMaxPromptTokens() int {
+	if h.MaxPromptTokens <= 0 {
+		return 2000
+	}
+	return h.MaxPromptTokens
+}
+
+// GetBudgetPercent returns the share of requests that may be hedged,
+// defaulting to 10 percent
+func (h HedgingConfig) GetBudgetPercent() float64 {
+	if h.BudgetPercent <= 0 {
+		return 10
+	}
+	return h.BudgetPercent
+}
+
+// StreamFailoverConfig controls retrying streamed chat completions whose
+// upstream stream dies mid-response
+type StreamFailoverConfig struct {
+	Enabled      bool `json:"enabled" yaml:"enabled"`
+	MaxAttempts  int  `json:"max_attempts" yaml:"max_attempts" validate:"min=0"` // further instances to try, defaults to 2
+	Continuation bool `json:"continuation" yaml:"continuation"`                  // after content was sent, re-issue with the partial text appended
+}
+
+// GetMaxAttempts returns how many further instances may be tried
+func (s StreamFailoverConfig) GetMaxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return 2
+	}
+	return s.MaxAttempts
+}
+
+// CacheConfig controls the exact-match response cache for deterministic requests
+type CacheConfig struct {
+	Enabled       bool `json:"enabled" yaml:"enabled"`
+	TTLSeconds    int  `json:"ttl_seconds" yaml:"ttl_seconds" validate:"min=0"`         // defaults to 1 hour
+	MaxEntryBytes int  `json:"max_entry_bytes" yaml:"max_entry_bytes" validate:"min=0"` // larger responses are not cached, defaults to 1 MiB
+	MaxEntries    int  `json:"max_entries" yaml:"max_entries" validate:"min=0"`         // oldest entries are evicted beyond this, defaults to 10000
+	
+	Semantic SemanticCacheConfig `json:"semantic" yaml:"semantic"`
+}
+
+// SemanticCacheConfig controls answering chat requests whose last user
+// message is similar to one answered before
+type SemanticCacheConfig struct {
+	Enabled        bool    `json:"enabled" yaml:"enabled"`
+	EmbeddingModel string  `json:"embedding_model" yaml:"embedding_model"`                 // served by the proxy's own instances
+	Threshold      float64 `json:"threshold" yaml:"threshold" validate:"min=0,max=1"`      // minimum cosine similarity, defaults to 0.95
+	TTLSeconds     int     `json:"ttl_seconds" yaml:"ttl_seconds" validate:"min=0"`        // defaults to 1 hour
+	MaxEntries     int     `json:"max_entries" yaml:"max_entries" validate:"min=0"`        // defaults to 10000
+	Store          string  `json:"store" yaml:"store" validate:"omitempty,oneof=memory sqlite"` // defaults to memory
+}
+
+// GetThreshold returns the minimum similarity for a hit, defaulting to 0.95
+func (c SemanticCacheConfig) GetThreshold() float64 {
+	if c.Threshold <= 0 {
+		return 0.95
+	}
+	return c.Threshold
+}
+
+// GetTTL returns how long answers are reused, defaulting to 1 hour
+func (c SemanticCacheConfig) GetTTL() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// GetMaxEntries returns the index size limit, defaulting to 10000
+func (c SemanticCacheConfig) GetMaxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 10000
+	}
+	return c.MaxEntries
+}
+
+// GetTTL returns how long cached responses are served, defaulting to 1 hour
+func (c CacheConfig) GetTTL() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// GetMaxEntryBytes returns the largest cacheable response, defaulting to 1 MiB
+func (c CacheConfig) GetMaxEntryBytes() int {
+	if c.MaxEntryBytes <= 0 {
+		return 1 << 20
+	}
+	return c.MaxEntryBytes
+}
+
+// GetMaxEntries returns how many responses may be cached, defaulting to 10000
+func (c CacheConfig) GetMaxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 10000
+	}
+	return c.MaxEntries
+}
+
+// CoalescingConfig controls merging identical in-flight non-streaming
+// requests into a single upstream call
+type CoalescingConfig struct {
+	Enabled   bool     `json:"enabled" yaml:"enabled"`
+	Endpoints []string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"` // defaults to /v1/embeddings
+}
+
+// CoalescesEndpoint reports whether requests to the endpoint are coalesced
+func (c CoalescingConfig) CoalescesEndpoint(endpoint string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Endpoints) == 0 {
+		return endpoint == "/v1/embeddings"
+	}
+	for _, configured := range c.Endpoints {
+		if configured == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelConfig registers a model with the names clients may use for it and
+// the models to fall back to when it has no capacity
+type ModelConfig struct {
+	Name      string   `json:"name" yaml:"name" validate:"required"`
+	Aliases   []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`     // e.g. gpt-4-latest for gpt-4o
+	Fallbacks []string `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"` // tried in order when no instance for the model has capacity
+}
+
+// Admission queue priority levels, served lowest first
+const (
+	PriorityHigh   = 0
+	PriorityNormal = 1
+	PriorityLow    = 2
+)
+
+// priorityNames are the names of the priority levels, indexed by level
+var priorityNames = []string{"high", "normal", "low"}
+
+// ParsePriority parses a priority name or level (0-2)
+func ParsePriority(value string) (int, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for priority, name := range priorityNames {
+		if value == name || value == strconv.Itoa(priority) {
+			return priority, true
+		}
+	}
+	return 0, false
+}
+
+// PriorityName returns the name of a priority level
+func PriorityName(priority int) string {
+	if priority >= 0 && priority < len(priorityNames) {
+		return priorityNames[priority]
+	}
+	return strconv.Itoa(priority)
+}
+
+// AdmissionConfig controls queueing requests for capacity instead of
+// rejecting them when every instance is at its rate limit
+type AdmissionConfig struct {
+	Enabled        bool `json:"enabled" yaml:"enabled"`
+	MaxQueueSize   int  `json:"max_queue_size" yaml:"max_queue_size" validate:"min=0"`     // queued requests beyond this are shed with 429
+	MaxWaitSeconds int  `json:"max_wait_seconds" yaml:"max_wait_seconds" validate:"min=0"` // how long a request waits for capacity
+}
+
+// GetMaxQueueSize returns the queue capacity, defaulting to 100
+func (a AdmissionConfig) GetMaxQueueSize() int {
+	if a.MaxQueueSize <= 0 {
+		return 100
+	}
+	return a.MaxQueueSize
+}
+
+// GetMaxWait returns how long a request may wait, defaulting to 10 seconds
+func (a AdmissionConfig) GetMaxWait() time.Duration {
+	if a.MaxWaitSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(a.MaxWaitSeconds) * time.Second
+}
+
+// EmbeddingBatchingConfig controls merging concurrent embeddings requests for
+// the same model into one upstream call with an array input
+type EmbeddingBatchingConfig struct {
+	Enabled      bool `json:"enabled" yaml:"enabled"`
+	MaxBatchSize int  `json:"max_batch_size" yaml:"max_batch_size" validate:"min=0"` // inputs per upstream call
+	MaxWaitMs    int  `json:"max_wait_ms" yaml:"max_wait_ms" validate:"min=0"`       // how long the first request waits for others
+}
+
+// GetMaxBatchSize returns the maximum inputs per upstream call, defaulting to 16
+func (e EmbeddingBatchingConfig) GetMaxBatchSize() int {
+	if e.MaxBatchSize <= 0 {
+		return 16
+	}
+	return e.MaxBatchSize
+}
+
+// GetMaxWait returns how long a batch stays open, defaulting to 10 milliseconds
+func (e EmbeddingBatchingConfig) GetMaxWait() time.Duration {
+	if e.MaxWaitMs <= 0 {
+		return 10 * time.Millisecond
+	}
+	return time.Duration(e.MaxWaitMs) * time.Millisecond
+}
+
+// ServerConfig represents HTTP server lifecycle configuration
+type ServerConfig struct {
+	ShutdownTimeout int `json:"shutdown_timeout" yaml:"shutdown_timeout" validate:"min=0"` // seconds to wait for in-flight requests
+	DrainDelay      int `json:"drain_delay" yaml:"drain_delay" validate:"min=0"`           // seconds to report draining before closing the listener
+}
+
+// GetShutdownTimeout returns the shutdown deadline, defaulting to 30 seconds
+func (s ServerConfig) GetShutdownTimeout() time.Duration {
+	if s.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(s.ShutdownTimeout) * time.Second
+}
+
+// GetDrainDelay returns how long to advertise draining before shutdown
+func (s ServerConfig) GetDrainDelay() time.Duration {
+	if s.DrainDelay < 0 {
+		return 0
+	}
+	return time.Duration(s.DrainDelay) * time.Second
 }
 
 // LoggingConfig represents logging configuration
@@ -154,11 +841,18 @@ type MonitoringConfig struct {
 
 // AppConfig represents the main application configuration
 type AppConfig struct {
-	Name       string             `json:"name" yaml:"name"`
-	Version    string             `json:"version" yaml:"version"`
-	Port       int                `json:"port" yaml:"port" validate:"min=1,max=65535"`
-	Instances  []InstanceConfig   `json:"instances" yaml:"instances"`
-	Routing    RoutingConfig      `json:"routing" yaml:"routing"`
-	Logging    LoggingConfig      `json:"logging" yaml:"logging"`
-	Monitoring MonitoringConfig   `json:"monitoring" yaml:"monitoring"`
+	Name              string                  `json:"name" yaml:"name"`
+	Version           string                  `json:"version" yaml:"version"`
+	Port              int                     `json:"port" yaml:"port" validate:"min=1,max=65535"`
+	Instances         []InstanceConfig        `json:"instances" yaml:"instances"`
+	Clients           []ClientConfig          `json:"clients,omitempty" yaml:"clients,omitempty"`
+	Models            []ModelConfig           `json:"models,omitempty" yaml:"models,omitempty"`
+	Server            ServerConfig            `json:"server" yaml:"server"`
+	Routing           RoutingConfig           `json:"routing" yaml:"routing"`
+	Cache             CacheConfig             `json:"cache" yaml:"cache"`
+	Coalescing        CoalescingConfig        `json:"coalescing" yaml:"coalescing"`
+	EmbeddingBatching EmbeddingBatchingConfig `json:"embedding_batching" yaml:"embedding_batching"`
+	Admission         AdmissionConfig         `json:"admission" yaml:"admission"`
+	Logging           LoggingConfig           `json:"logging" yaml:"logging"`
+	Monitoring        MonitoringConfig        `json:"monitoring" yaml:"monitoring"`
 }
\ No newline at end of file
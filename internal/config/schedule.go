@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week (0 = Sunday)
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	anyDay   bool // day of month is *
+	anyWeek  bool // day of week is *
+}
+
+// scheduleFields are the bounds of the cron fields in order
+var scheduleFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseSchedule parses a cron expression such as "0 2 * * 0" (Sundays at
+// 02:00). Fields accept *, numbers, ranges (1-5), lists (1,3) and steps (*/15).
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("schedule %q must have 5 fields", expr)
+	}
+	
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseScheduleField(field, scheduleFields[i].min, scheduleFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in schedule %q: %w", scheduleFields[i].name, expr, err)
+		}
+		sets[i] = set
+	}
+	
+	// Both 0 and 7 mean Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Schedule{
+		minutes:  sets[0],
+		hours:    sets[1],
+		days:     sets[2],
+		months:   sets[3],
+		weekdays: sets[4],
+		anyDay:   fields[2] == "*",
+		anyWeek:  fields[4] == "*",
+	}, nil
+}
+
+// parseScheduleField returns the values a cron field matches as a bit set
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			rangePart = part[:slash]
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+		
+		start, end := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		
+		for value := start; value <= end; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+// Matches reports whether the schedule fires in the minute of t. As in cron,
+// when both day of month and day of week are restricted either may match.
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minutes&(1<<uint(t.Minute())) == 0 || s.hours&(1<<uint(t.Hour())) == 0 || s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayMatches := s.days&(1<<uint(t.Day())) != 0
+	weekMatches := s.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeek:
+		return true
+	case s.anyDay:
+		return weekMatches
+	case s.anyWeek:
+		return dayMatches
+	default:
+		return dayMatches || weekMatches
+	}
+}
+
+// GetLocation returns the time zone the window's schedule is in, defaulting to UTC
+func (w MaintenanceWindow) GetLocation() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(w.Timezone)
+}
+
+// GetDuration returns how long the window lasts after each scheduled start
+func (w MaintenanceWindow) GetDuration() time.Duration {
+	return time.Duration(w.DurationMinutes) * time.Minute
+}
+
+// ActiveAt reports whether t falls within the window and, if so, when the
+// window ends
+func (w MaintenanceWindow) ActiveAt(schedule *Schedule, t time.Time) (bool, time.Time) {
+	location, err := w.GetLocation()
+	if err != nil {
+		location = time.UTC
+	}
+	
+	// Look for a scheduled start within the last duration minutes
+	minute := t.In(location).Truncate(time.Minute)
+	for i := 0; i < w.DurationMinutes; i++ {
+		start := minute.Add(-time.Duration(i) * time.Minute)
+		if schedule.Matches(start) {
+			return true, start.Add(w.GetDuration())
+		}
+	}
+	return false, time.Time{}
+}
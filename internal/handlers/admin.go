@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 	"azure-openai-proxy/internal/instance"
+	"azure-openai-proxy/internal/storage"
 	
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -11,6 +13,7 @@ import (
 // AdminHandler handles administrative requests
 type AdminHandler struct {
 	instanceManager *instance.Manager
+	vectorIndex     storage.VectorIndex // semantic cache index, nil when disabled
 }
 
 // NewAdminHandler creates a new admin handler
@@ -20,6 +23,11 @@ func NewAdminHandler(instanceManager *instance.Manager) *AdminHandler {
 	}
 }
 
+// SetSemanticCache enables the semantic cache administration endpoints
+func (h *AdminHandler) SetSemanticCache(index storage.VectorIndex) {
+	h.vectorIndex = index
+}
+
 // GetInstances returns all instances with their current states
 func (h *AdminHandler) GetInstances(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -74,6 +82,7 @@ func (h *AdminHandler) GetInstance(c *gin.Context) {
 		"name":   instanceName,
 		"config": config,
 		"state":  state,
+		"drain":  h.instanceManager.DrainStatus(instanceName),
 		"health": gin.H{
 			"status":             state.Status,
 			"health_status":      state.HealthStatus,
@@ -87,6 +96,7 @@ func (h *AdminHandler) GetInstance(c *gin.Context) {
 			"current_rpm":          state.CurrentRPM,
 			"total_requests":       state.TotalRequests,
 			"successful_requests":  state.SuccessfulRequests,
+			"cancelled_requests":   state.CancelledRequests,
 			"total_tokens_served":  state.TotalTokensServed,
 			"utilization_percent":  state.UtilizationPercentage,
 			"last_used":            state.LastUsed,
@@ -138,6 +148,89 @@ func (h *AdminHandler) ResetInstance(c *gin.Context) {
 	})
 }
 
+// DrainInstance stops routing new requests to an instance while those in
+// flight finish, and returns the drain status to poll for completion
+func (h *AdminHandler) DrainInstance(c *gin.Context) {
+	instanceName := c.Param("name")
+	
+	if err := h.instanceManager.Drain(instanceName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Instance not found",
+			"instance": instanceName,
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Instance draining",
+		"instance": instanceName,
+		"drain": h.instanceManager.DrainStatus(instanceName),
+	})
+}
+
+// GetDrainStatus reports whether an instance is draining and whether the
+// drain is complete
+func (h *AdminHandler) GetDrainStatus(c *gin.Context) {
+	instanceName := c.Param("name")
+	
+	if _, err := h.instanceManager.GetInstanceConfig(instanceName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Instance not found",
+			"instance": instanceName,
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"instance": instanceName,
+		"drain": h.instanceManager.DrainStatus(instanceName),
+	})
+}
+
+// ResumeInstance puts a drained instance back into rotation
+func (h *AdminHandler) ResumeInstance(c *gin.Context) {
+	instanceName := c.Param("name")
+	
+	if err := h.instanceManager.Resume(instanceName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Instance not found",
+			"instance": instanceName,
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Instance resumed",
+		"instance": instanceName,
+		"drain": h.instanceManager.DrainStatus(instanceName),
+	})
+}
+
+// GetCosts returns request costs by instance, model and client key, for the
+// month given as ?month=2006-01 or for all months
+func (h *AdminHandler) GetCosts(c *gin.Context) {
+	month := c.Query("month")
+	if month != "" {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "month must be formatted as YYYY-MM",
+				"month": month,
+			})
+			return
+		}
+	}
+	
+	stats, err := h.instanceManager.CostStats(c.Request.Context(), month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve costs",
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, stats)
+}
+
 // GetConfig returns the current proxy configuration
 func (h *AdminHandler) GetConfig(c *gin.Context) {
 	configs := h.instanceManager.GetAllConfigs()
@@ -158,10 +251,17 @@ func (h *AdminHandler) GetConfig(c *gin.Context) {
 			"model_deployments": cfg.ModelDeployments,
 			"enabled":           cfg.Enabled,
 			"timeout_seconds":   cfg.TimeoutSeconds,
+			"connect_timeout_seconds":    cfg.ConnectTimeoutSeconds,
+			"first_byte_timeout_seconds": cfg.FirstByteTimeoutSeconds,
 			"retry_count":       cfg.RetryCount,
 			"rate_limit_enabled": cfg.RateLimitEnabled,
 			"api_key_configured": cfg.APIKey != "",
 			"proxy_url":         cfg.ProxyURL,
+			"batch_enabled":     cfg.BatchEnabled,
+			"max_realtime_sessions": cfg.MaxRealtimeSessions,
+			"tier":              cfg.Tier,
+			"maintenance_windows": cfg.MaintenanceWindows,
+			"pricing":           cfg.Pricing,
 		}
 		sanitizedConfigs[i] = sanitized
 	}
@@ -324,4 +424,126 @@ func (h *AdminHandler) GetHealth(c *gin.Context) {
 	}
 	
 	c.JSON(statusCode, response)
-}
\ No newline at end of file
+}
+
+// GetBatches returns tracked batch jobs with counts by status and instance
+func (h *AdminHandler) GetBatches(c *gin.Context) {
+	jobs, err := h.instanceManager.ListBatchJobs(c.Request.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list batch jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve batch jobs",
+		})
+		return
+	}
+	
+	byStatus := make(map[string]int)
+	byInstance := make(map[string]int)
+	for _, job := range jobs {
+		byStatus[job.Status]++
+		byInstance[job.Instance]++
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"batches":     jobs,
+		"total":       len(jobs),
+		"by_status":   byStatus,
+		"by_instance": byInstance,
+	})
+}
+
+// GetSemanticCache returns the number of semantic cache entries per namespace
+func (h *AdminHandler) GetSemanticCache(c *gin.Context) {
+	if !h.requireSemanticCache(c) {
+		return
+	}
+	
+	counts, err := h.vectorIndex.CountVectors(c.Request.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to count semantic cache entries")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve semantic cache entries",
+		})
+		return
+	}
+	
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"namespaces": counts,
+		"total":      total,
+		"stats":      h.instanceManager.CacheStats()["semantic"],
+	})
+}
+
+// InvalidateSemanticCache removes the semantic cache entries of the namespace
+// given by the "namespace" query parameter, or all entries without it
+func (h *AdminHandler) InvalidateSemanticCache(c *gin.Context) {
+	if !h.requireSemanticCache(c) {
+		return
+	}
+	
+	namespace := c.Query("namespace")
+	removed, err := h.vectorIndex.DeleteVectors(c.Request.Context(), namespace)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to invalidate semantic cache")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to invalidate semantic cache",
+		})
+		return
+	}
+	
+	logrus.WithFields(logrus.Fields{
+		"namespace": namespace,
+		"removed":   removed,
+	}).Info("Semantic cache invalidated")
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Semantic cache invalidated",
+		"namespace": namespace,
+		"removed":   removed,
+	})
+}
+
+// DeleteSemanticCacheEntry removes a single semantic cache entry
+func (h *AdminHandler) DeleteSemanticCacheEntry(c *gin.Context) {
+	if !h.requireSemanticCache(c) {
+		return
+	}
+	
+	entryID := c.Param("id")
+	found, err := h.vectorIndex.DeleteVector(c.Request.Context(), entryID)
+	if err != nil {
+		logrus.WithError(err).WithField("entry", entryID).Error("Failed to delete semantic cache entry")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete semantic cache entry",
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Semantic cache entry not found",
+			"entry": entryID,
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Semantic cache entry deleted",
+		"entry":   entryID,
+	})
+}
+
+// requireSemanticCache responds with 404 if the semantic cache is disabled
+func (h *AdminHandler) requireSemanticCache(c *gin.Context) bool {
+	if h.vectorIndex == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Semantic cache is not enabled",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	
+	"azure-openai-proxy/internal/errors"
+	"azure-openai-proxy/internal/instance"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxFormFieldSize bounds non-file multipart fields read into memory
+const maxFormFieldSize = 64 * 1024
+
+// AudioTranscriptions handles /v1/audio/transcriptions requests
+func (h *ProxyHandler) AudioTranscriptions(c *gin.Context) {
+	h.handleMultipartRequest(c, "/v1/audio/transcriptions")
+}
+
+// AudioTranslations handles /v1/audio/translations requests
+func (h *ProxyHandler) AudioTranslations(c *gin.Context) {
+	h.handleMultipartRequest(c, "/v1/audio/translations")
+}
+
+// AudioSpeech handles /v1/audio/speech requests
+func (h *ProxyHandler) AudioSpeech(c *gin.Context) {
+	h.handleProxyRequest(c, "/v1/audio/speech")
+}
+
+// formField is a non-file multipart field kept in its original order
+type formField struct {
+	name  string
+	value string
+}
+
+// multipartUpload describes a parsed multipart request whose file part is
+// forwarded without being buffered in memory
+type multipartUpload struct {
+	fields    []formField
+	filePart  *multipart.Part // file part still being read from the client
+	spooled   *os.File        // file part spooled to disk when it preceded the model field
+	reader    *multipart.Reader
+	remaining bool // parts after filePart have not been read yet
+}
+
+// field returns the value of a form field
+func (u *multipartUpload) field(name string) string {
+	for _, f := range u.fields {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// close releases the spooled file, if any
+func (u *multipartUpload) close() {
+	if u.spooled != nil {
+		u.spooled.Close()
+		os.Remove(u.spooled.Name())
+	}
+}
+
+// handleMultipartRequest proxies multipart/form-data audio uploads
+func (h *ProxyHandler) handleMultipartRequest(c *gin.Context, endpoint string) {
+	startTime := time.Now()
+	withRequestRoute(c, endpoint)
+	
+	upload, proxyErr := h.readMultipartUpload(c)
+	if proxyErr != nil {
+		h.sendErrorResponse(c, proxyErr)
+		return
+	}
+	defer upload.close()
+	
+	modelName := h.instanceManager.ResolveModel(upload.field("model"))
+	
+	selectedInstance, err := h.instanceManager.SelectInstance(c.Request.Context(), modelName, 0, "azure")
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInstanceError("no suitable instance available", map[string]interface{}{
+			"model":    modelName,
+			"endpoint": endpoint,
+			"error":    err.Error(),
+		}))
+		return
+	}
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(selectedInstance)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInternalError("failed to get instance config", map[string]interface{}{
+			"instance": selectedInstance,
+			"error":    err.Error(),
+		}))
+		return
+	}
+	
+	azureService, exists := h.azureServices[selectedInstance]
+	if !exists {
+		h.sendErrorResponse(c, errors.NewInternalError("Azure service not found for instance", map[string]interface{}{
+			"instance": selectedInstance,
+		}))
+		return
+	}
+	
+	// Capacity is accounted in audio seconds, estimated from the upload size
+	// and reconciled with the duration reported by the upstream
+	estimatedSeconds := h.transformer.EstimateAudioSeconds(c.Request.ContentLength)
+	if proxyErr := h.checkUnitCapacity(c.Request.Context(), selectedInstance, map[string]int{
+		instance.UnitAudioSeconds: estimatedSeconds,
+	}); proxyErr != nil {
+		h.sendErrorResponse(c, proxyErr)
+		return
+	}
+	
+	// Stream the re-encoded form to Azure through a pipe
+	bodyReader, bodyWriter := io.Pipe()
+	formWriter := multipart.NewWriter(bodyWriter)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		bodyWriter.CloseWithError(h.writeMultipartBody(formWriter, upload))
+	}()
+	
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	resp, err := azureService.ProxyRawRequest(c.Request.Context(), endpoint, bodyReader, formWriter.FormDataContentType(), deploymentName)
+	// Closing the reader unblocks the writer; wait for it before the upload is released
+	bodyReader.Close()
+	<-written
+	if err != nil {
+		h.sendUpstreamFailure(c, selectedInstance, err)
+		return
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode >= 400 {
+		h.sendErrorResponse(c, azureService.ParseErrorResponse(resp))
+		h.recordError(selectedInstance, resp.StatusCode)
+		return
+	}
+	
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInternalError("failed to read response", map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return
+	}
+	
+	// verbose_json responses report the actual duration
+	audioSeconds := estimatedSeconds
+	var responseData map[string]interface{}
+	if json.Unmarshal(body, &responseData) == nil {
+		if duration, ok := responseData["duration"].(float64); ok && duration > 0 {
+			audioSeconds = int(duration + 0.5)
+		}
+	}
+	
+	h.recordUsage(selectedInstance, 0, startTime)
+	h.recordUnitUsage(selectedInstance, map[string]int{instance.UnitAudioSeconds: audioSeconds})
+	h.recordAudioUsage(selectedInstance, audioSeconds, 0)
+	
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+}
+
+// readMultipartUpload reads form fields up to the file part. If the file
+// arrives before the model field, it is spooled to a temporary file so the
+// remaining fields can be read before selecting an instance.
+func (h *ProxyHandler) readMultipartUpload(c *gin.Context) (*multipartUpload, *errors.ProxyError) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, errors.NewClientError("multipart/form-data body required", 400, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	
+	upload := &multipartUpload{reader: reader}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			upload.close()
+			return nil, errors.NewClientError("invalid multipart body", 400, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				upload.close()
+				return nil, errors.NewClientError("invalid multipart field", 400, map[string]interface{}{
+					"field": part.FormName(),
+					"error": err.Error(),
+				})
+			}
+			upload.fields = append(upload.fields, formField{name: part.FormName(), value: string(value)})
+			continue
+		}
+		
+		if upload.filePart != nil {
+			// Only a single file upload is supported
+			continue
+		}
+		upload.filePart = part
+		
+		if upload.field("model") != "" {
+			// Model known: stream the file and later parts straight through
+			upload.remaining = true
+			break
+		}
+		
+		spooled, err := os.CreateTemp("", "proxy-audio-*")
+		if err != nil {
+			return nil, errors.NewInternalError("failed to spool upload", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		upload.spooled = spooled
+		if _, err := io.Copy(spooled, part); err != nil {
+			upload.close()
+			return nil, errors.NewClientError("failed to read uploaded file", 400, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			upload.close()
+			return nil, errors.NewInternalError("failed to rewind spooled upload", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	
+	if upload.filePart == nil {
+		upload.close()
+		return nil, errors.NewClientError("file field is required", 400, nil)
+	}
+	if upload.field("model") == "" {
+		upload.close()
+		return nil, errors.NewClientError("model field is required", 400, nil)
+	}
+	
+	return upload, nil
+}
+
+// writeMultipartBody re-encodes the upload for Azure, dropping the model field
+// since Azure addresses the deployment in the URL
+func (h *ProxyHandler) writeMultipartBody(formWriter *multipart.Writer, upload *multipartUpload) error {
+	for _, f := range upload.fields {
+		if f.name == "model" {
+			continue
+		}
+		if err := formWriter.WriteField(f.name, f.value); err != nil {
+			return err
+		}
+	}
+	
+	fileWriter, err := formWriter.CreatePart(upload.filePart.Header)
+	if err != nil {
+		return err
+	}
+	var fileSource io.Reader = upload.filePart
+	if upload.spooled != nil {
+		fileSource = upload.spooled
+	}
+	if _, err := io.Copy(fileWriter, fileSource); err != nil {
+		return err
+	}
+	
+	// Forward any parts that followed the file in the client request
+	for upload.remaining {
+		part, err := upload.reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if part.FormName() == "model" {
+			continue
+		}
+		partWriter, err := formWriter.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(partWriter, part); err != nil {
+			return err
+		}
+	}
+	
+	return formWriter.Close()
+}
+
+// streamBinaryResponse streams a binary response (e.g. synthesized audio) to the client
+func (h *ProxyHandler) streamBinaryResponse(c *gin.Context, resp *http.Response) {
+	for key, values := range resp.Header {
+		if strings.EqualFold(key, "Content-Length") {
+			continue
+		}
+		for _, value := range values {
+			c.Header(key, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+	
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := c.Writer.Write(buffer[:n]); writeErr != nil {
+				logrus.WithError(writeErr).Warn("Client closed audio stream")
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Error reading audio stream")
+			return
+		}
+	}
+}
+
+// recordAudioUsage records transcribed audio seconds and synthesized characters
+func (h *ProxyHandler) recordAudioUsage(instanceName string, audioSeconds, characters int) {
+	ctx := context.Background()
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	state.TotalAudioSeconds += audioSeconds
+	state.TotalSpeechCharacters += characters
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
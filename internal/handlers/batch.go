@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/errors"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Affinity kinds for upstream objects that only exist on the instance that created them
+const (
+	affinityFile  = "file"
+	affinityBatch = "batch"
+)
+
+// UploadFile handles POST /v1/files
+func (h *ProxyHandler) UploadFile(c *gin.Context) {
+	instanceName, err := h.instanceManager.SelectBatchInstance(c.Request.Context())
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInstanceError("no batch-enabled instance available", map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return
+	}
+	
+	// The multipart body is forwarded unchanged, without buffering
+	data, ok := h.proxyResource(c, instanceName, "POST", "/v1/files", c.Request.Body, c.GetHeader("Content-Type"))
+	if !ok {
+		return
+	}
+	
+	if fileID, _ := data["id"].(string); fileID != "" {
+		h.saveAffinity(c, affinityFile, fileID, instanceName)
+	}
+}
+
+// ListFiles handles GET /v1/files across all batch-enabled instances
+func (h *ProxyHandler) ListFiles(c *gin.Context) {
+	h.listResources(c, "/v1/files", affinityFile)
+}
+
+// GetFile handles GET /v1/files/:id
+func (h *ProxyHandler) GetFile(c *gin.Context) {
+	fileID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityFile, fileID)
+	if !ok {
+		return
+	}
+	
+	h.proxyResource(c, instanceName, "GET", "/v1/files/"+fileID, nil, "")
+}
+
+// DeleteFile handles DELETE /v1/files/:id
+func (h *ProxyHandler) DeleteFile(c *gin.Context) {
+	fileID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityFile, fileID)
+	if !ok {
+		return
+	}
+	
+	if _, ok := h.proxyResource(c, instanceName, "DELETE", "/v1/files/"+fileID, nil, ""); ok {
+		if err := h.instanceManager.DeleteAffinity(context.Background(), affinityFile, fileID); err != nil {
+			logrus.WithError(err).WithField("file_id", fileID).Warn("Failed to delete file affinity")
+		}
+	}
+}
+
+// GetFileContent handles GET /v1/files/:id/content
+func (h *ProxyHandler) GetFileContent(c *gin.Context) {
+	fileID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityFile, fileID)
+	if !ok {
+		return
+	}
+	
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		h.sendErrorResponse(c, errors.NewInternalError("Azure service not found for instance", map[string]interface{}{
+			"instance": instanceName,
+		}))
+		return
+	}
+	
+	resp, err := azureService.ProxyResourceRequest(c.Request.Context(), "GET", "/v1/files/"+fileID+"/content", "", nil, "")
+	if err != nil {
+		h.sendUpstreamFailure(c, instanceName, err)
+		return
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode >= 400 {
+		h.sendErrorResponse(c, azureService.ParseErrorResponse(resp))
+		return
+	}
+	
+	// Batch output files can be large; stream them through
+	h.streamBinaryResponse(c, resp)
+}
+
+// CreateBatch handles POST /v1/batches on the instance that owns the input file
+func (h *ProxyHandler) CreateBatch(c *gin.Context) {
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.sendErrorResponse(c, errors.NewClientError("invalid JSON payload", 400, map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return
+	}
+	
+	inputFileID, _ := payload["input_file_id"].(string)
+	if inputFileID == "" {
+		h.sendErrorResponse(c, errors.NewClientError("input_file_id field is required for batches", 400, nil))
+		return
+	}
+	
+	instanceName, ok := h.resolveAffinity(c, affinityFile, inputFileID)
+	if !ok {
+		return
+	}
+	
+	body, err := json.Marshal(payload)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInternalError("failed to marshal request payload", map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return
+	}
+	
+	data, ok := h.proxyResource(c, instanceName, "POST", "/v1/batches", bytes.NewReader(body), "application/json")
+	if !ok {
+		return
+	}
+	
+	if batchID, _ := data["id"].(string); batchID != "" {
+		h.saveAffinity(c, affinityBatch, batchID, instanceName)
+		h.trackBatchJob(c, instanceName, data)
+	}
+}
+
+// ListBatches handles GET /v1/batches across all batch-enabled instances
+func (h *ProxyHandler) ListBatches(c *gin.Context) {
+	h.listResources(c, "/v1/batches", affinityBatch)
+}
+
+// GetBatch handles GET /v1/batches/:id
+func (h *ProxyHandler) GetBatch(c *gin.Context) {
+	batchID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityBatch, batchID)
+	if !ok {
+		return
+	}
+	
+	if data, ok := h.proxyResource(c, instanceName, "GET", "/v1/batches/"+batchID, nil, ""); ok {
+		h.trackBatchJob(c, instanceName, data)
+	}
+}
+
+// CancelBatch handles POST /v1/batches/:id/cancel
+func (h *ProxyHandler) CancelBatch(c *gin.Context) {
+	batchID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityBatch, batchID)
+	if !ok {
+		return
+	}
+	
+	if data, ok := h.proxyResource(c, instanceName, "POST", "/v1/batches/"+batchID+"/cancel", nil, ""); ok {
+		h.trackBatchJob(c, instanceName, data)
+	}
+}
+
+// resolveAffinity finds the instance holding an object, sending a 404 if it
+// is unknown or owned by another client
+func (h *ProxyHandler) resolveAffinity(c *gin.Context, kind, objectID string) (string, bool) {
+	instanceName, owner, err := h.instanceManager.LookupAffinity(c.Request.Context(), kind, objectID)
+	if err == nil && owner != affinityOwner(c) {
+		logrus.WithFields(logrus.Fields{
+			"kind":   kind,
+			"id":     objectID,
+			"client": affinityOwner(c),
+		}).Warn("Access to another client's object denied")
+		err = fmt.Errorf("%s not found: %s", kind, objectID)
+	}
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewClientError(kind+" not found", 404, map[string]interface{}{
+			"id":    objectID,
+			"error": err.Error(),
+		}))
+		return "", false
+	}
+	return instanceName, true
+}
+
+// saveAffinity records the instance holding an object created by the
+// request's client, logging failures
+func (h *ProxyHandler) saveAffinity(c *gin.Context, kind, objectID, instanceName string) {
+	owner := affinityOwner(c)
+	if err := h.instanceManager.SaveAffinity(context.Background(), kind, objectID, instanceName, owner); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"kind":     kind,
+			"id":       objectID,
+			"instance": instanceName,
+			"owner":    owner,
+		}).Warn("Failed to save affinity")
+	}
+}
+
+// affinityOwner returns the client owning the objects a request creates, or
+// "" if no client key identified it
+func affinityOwner(c *gin.Context) string {
+	if client := clientFromContext(c); client != nil {
+		return client.Name
+	}
+	return ""
+}
+
+// proxyResource forwards a Files/Batch API call to an instance, relays the
+// response and returns the parsed JSON body on success
+func (h *ProxyHandler) proxyResource(c *gin.Context, instanceName, method, endpoint string, body io.Reader, contentType string) (map[string]interface{}, bool) {
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		h.sendErrorResponse(c, errors.NewInternalError("Azure service not found for instance", map[string]interface{}{
+			"instance": instanceName,
+		}))
+		return nil, false
+	}
+	
+	resp, err := azureService.ProxyResourceRequest(c.Request.Context(), method, endpoint, c.Request.URL.RawQuery, body, contentType)
+	if err != nil {
+		h.sendUpstreamFailure(c, instanceName, err)
+		return nil, false
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode >= 400 {
+		h.sendErrorResponse(c, azureService.ParseErrorResponse(resp))
+		return nil, false
+	}
+	
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInternalError("failed to read response", map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return nil, false
+	}
+	
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), responseBody)
+	
+	var data map[string]interface{}
+	if err := json.Unmarshal(responseBody, &data); err != nil {
+		return map[string]interface{}{}, true
+	}
+	return data, true
+}
+
+// listResources merges a list endpoint across all batch-enabled instances,
+// keeping the objects the requesting client created through the proxy
+func (h *ProxyHandler) listResources(c *gin.Context, endpoint, kind string) {
+	ctx := c.Request.Context()
+	merged := make([]interface{}, 0)
+	hasMore := false
+	
+	for _, cfg := range h.instanceManager.GetAllConfigs() {
+		if !cfg.Enabled || !cfg.BatchEnabled {
+			continue
+		}
+		azureService, exists := h.azureServices[cfg.Name]
+		if !exists {
+			continue
+		}
+		
+		resp, err := azureService.ProxyResourceRequest(ctx, "GET", endpoint, c.Request.URL.RawQuery, nil, "")
+		if err != nil {
+			logrus.WithError(err).WithField("instance", cfg.Name).Warn("Failed to list resources")
+			continue
+		}
+		
+		var page map[string]interface{}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode >= 400 || decodeErr != nil {
+			logrus.WithField("instance", cfg.Name).WithField("status_code", resp.StatusCode).Warn("Failed to list resources")
+			continue
+		}
+		
+		if more, ok := page["has_more"].(bool); ok && more {
+			hasMore = true
+		}
+		items, _ := page["data"].([]interface{})
+		for _, item := range items {
+			itemMap, _ := item.(map[string]interface{})
+			objectID, _ := itemMap["id"].(string)
+			instanceName, owner, err := h.instanceManager.LookupAffinity(ctx, kind, objectID)
+			if err != nil || instanceName != cfg.Name || owner != affinityOwner(c) {
+				continue
+			}
+			merged = append(merged, item)
+		}
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     merged,
+		"has_more": hasMore,
+	})
+}
+
+// trackBatchJob stores the batch status reported by the upstream
+func (h *ProxyHandler) trackBatchJob(c *gin.Context, instanceName string, data map[string]interface{}) {
+	batchID, _ := data["id"].(string)
+	if batchID == "" {
+		return
+	}
+	
+	job := &config.BatchJob{
+		ID:        batchID,
+		Instance:  instanceName,
+		UpdatedAt: time.Now(),
+	}
+	if client := clientFromContext(c); client != nil {
+		job.Client = client.Name
+	}
+	job.Status, _ = data["status"].(string)
+	job.Endpoint, _ = data["endpoint"].(string)
+	job.InputFileID, _ = data["input_file_id"].(string)
+	job.OutputFileID, _ = data["output_file_id"].(string)
+	job.ErrorFileID, _ = data["error_file_id"].(string)
+	if createdAt, ok := data["created_at"].(float64); ok {
+		job.CreatedAt = time.Unix(int64(createdAt), 0)
+	} else {
+		job.CreatedAt = job.UpdatedAt
+	}
+	if counts, ok := data["request_counts"].(map[string]interface{}); ok {
+		job.RequestCounts = make(map[string]int)
+		for key, value := range counts {
+			if count, ok := value.(float64); ok {
+				job.RequestCounts[key] = int(count)
+			}
+		}
+	}
+	
+	if err := h.instanceManager.TrackBatchJob(context.Background(), job); err != nil {
+		logrus.WithError(err).WithField("batch_id", batchID).Warn("Failed to track batch job")
+	}
+}
+
+// sendUpstreamFailure reports a failed upstream call, or records a
+// cancellation if the client went away
+func (h *ProxyHandler) sendUpstreamFailure(c *gin.Context, instanceName string, err error) {
+	// Nobody is left to receive a response
+	if clientCancelled(c) {
+		logrus.WithField("instance", instanceName).Info("Client disconnected, upstream request cancelled")
+		h.recordCancelled(instanceName, false)
+		return
+	}
+	h.instanceManager.RecordRequestResult(instanceName, true)
+	
+	if proxyErr, ok := err.(*errors.ProxyError); ok {
+		h.sendErrorResponse(c, proxyErr)
+		return
+	}
+	h.sendErrorResponse(c, errors.NewUpstreamError("request failed", 500, map[string]interface{}{
+		"error":    err.Error(),
+		"instance": instanceName,
+	}))
+}
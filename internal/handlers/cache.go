@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/storage"
+	"azure-openai-proxy/internal/utils"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheRequestHeader opts a request in ("true", or "semantic" to also reuse
+// answers to similar chat requests) or out ("false") of the response cache
+const cacheRequestHeader = "X-Proxy-Cache"
+
+// cacheableEndpoints lists the endpoints whose responses may be cached
+var cacheableEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+// cacheIgnoredFields do not change the upstream output and are left out of cache keys
+var cacheIgnoredFields = []string{"stream", "stream_options", "user"}
+
+// SetResponseCache enables the exact-match response cache
+func (h *ProxyHandler) SetResponseCache(cache storage.ResponseCache, cfg config.CacheConfig) {
+	h.responseCache = cache
+	h.cacheConfig = cfg
+}
+
+// cacheOptIn reports whether a request uses the exact and the semantic cache,
+// from the request header or else the client key's settings
+func cacheOptIn(c *gin.Context) (exact, semantic bool) {
+	switch c.GetHeader(cacheRequestHeader) {
+	case "true":
+		return true, false
+	case "semantic":
+		return true, true
+	case "false":
+		return false, false
+	}
+	
+	if client := clientFromContext(c); client != nil {
+		return client.Cache || client.SemanticCache, client.SemanticCache
+	}
+	return false, false
+}
+
+// cacheNamespace returns the namespace cached responses of the client are
+// shared in, or "" for requests without a client key
+func cacheNamespace(c *gin.Context) string {
+	if client := clientFromContext(c); client != nil {
+		return client.GetCacheNamespace()
+	}
+	return ""
+}
+
+// responseCacheKey returns the cache key of a request, or "" if the request
+// did not opt in or is not deterministic. Keys are scoped to the client's
+// cache namespace.
+func (h *ProxyHandler) responseCacheKey(c *gin.Context, endpoint string, payload map[string]interface{}) string {
+	if h.responseCache == nil || !cacheableEndpoints[endpoint] {
+		return ""
+	}
+	if exact, _ := cacheOptIn(c); !exact || !isDeterministic(endpoint, payload) {
+		return ""
+	}
+	
+	return requestHash(endpoint, cacheNamespace(c), payload, cacheIgnoredFields...)
+}
+
+// requestHash returns a canonical hash of a request payload within a scope,
+// leaving out the ignored fields, or "" if the payload cannot be encoded
+func requestHash(endpoint, scope string, payload map[string]interface{}, ignored ...string) string {
+	normalized := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		normalized[k] = v
+	}
+	for _, field := range ignored {
+		delete(normalized, field)
+	}
+	
+	// Map keys are marshaled in sorted order, giving a canonical encoding
+	canonical, err := json.Marshal(normalized)
+	if err != nil {
+		return ""
+	}
+	
+	hash := sha256.New()
+	hash.Write([]byte(endpoint + "\n" + scope + "\n"))
+	hash.Write(canonical)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isDeterministic reports whether repeating a request should give the same
+// output: embeddings always, generations with temperature 0. A seed alone
+// does not make sampling repeatable; it is part of the cache key instead.
+func isDeterministic(endpoint string, payload map[string]interface{}) bool {
+	if endpoint == "/v1/embeddings" {
+		return true
+	}
+	temperature, ok := payload["temperature"].(float64)
+	return ok && temperature == 0
+}
+
+// serveCachedResponse answers a request from the cache, replaying streaming
+// requests as SSE. It returns false on a miss, marking the response as such.
+func (h *ProxyHandler) serveCachedResponse(c *gin.Context, key, endpoint string, payload map[string]interface{}) bool {
+	data, err := h.responseCache.Get(c.Request.Context(), key)
+	if err != nil {
+		logrus.WithError(err).Warn("Response cache lookup failed")
+	}
+	
+	hit := data != nil
+	h.instanceManager.RecordCacheLookup(hit)
+	if !hit {
+		c.Header("X-Cache", "MISS")
+		return false
+	}
+	
+	return writeCachedResponse(c, endpoint, payload, data)
+}
+
+// writeCachedResponse sends a cached response, replaying it as SSE for
+// streaming requests. It returns false if the entry is unreadable.
+func writeCachedResponse(c *gin.Context, endpoint string, payload map[string]interface{}, data []byte) bool {
+	c.Header("X-Cache", "HIT")
+	if stream, _ := payload["stream"].(bool); !stream {
+		c.Data(http.StatusOK, "application/json", data)
+		return true
+	}
+	
+	var response map[string]interface{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		logrus.WithError(err).Warn("Discarding unreadable cached response")
+		c.Header("X-Cache", "MISS")
+		return false
+	}
+	
+	replayCachedStream(c, endpoint, response, streamIncludesUsage(payload))
+	return true
+}
+
+// storeCachedResponse caches a successful response in OpenAI format under the
+// exact key and, for semantic lookups that missed, under its embedding
+func (h *ProxyHandler) storeCachedResponse(key string, query *semanticQuery, response map[string]interface{}) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	if len(data) > h.cacheConfig.GetMaxEntryBytes() {
+		logrus.WithField("bytes", len(data)).Debug("Response too large to cache")
+		return
+	}
+	
+	if key != "" {
+		if err := h.responseCache.Set(context.Background(), key, data, h.cacheConfig.GetTTL()); err != nil {
+			logrus.WithError(err).Warn("Failed to cache response")
+		}
+	}
+	if query != nil {
+		h.storeSemanticResponse(query, data)
+	}
+}
+
+// replayCachedStream sends a cached completion as a stream of chunks: the
+// full message of each choice, its finish reason, then [DONE]
+func replayCachedStream(c *gin.Context, endpoint string, response map[string]interface{}, includeUsage bool) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Status(http.StatusOK)
+	
+	object := "chat.completion.chunk"
+	if endpoint == "/v1/completions" {
+		object = "text_completion"
+	}
+	newChunk := func(choices []interface{}) map[string]interface{} {
+		chunk := map[string]interface{}{
+			"id":      response["id"],
+			"object":  object,
+			"created": response["created"],
+			"model":   response["model"],
+			"choices": choices,
+		}
+		if fingerprint, ok := response["system_fingerprint"]; ok {
+			chunk["system_fingerprint"] = fingerprint
+		}
+		return chunk
+	}
+	
+	var chunks []map[string]interface{}
+	choices, _ := response["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		
+		if object == "text_completion" {
+			chunks = append(chunks, newChunk([]interface{}{choiceMap}))
+			continue
+		}
+		
+		delta := map[string]interface{}{"role": "assistant"}
+		if message, ok := choiceMap["message"].(map[string]interface{}); ok {
+			delta["content"] = message["content"]
+			if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
+				indexed := make([]interface{}, len(toolCalls))
+				for i, toolCall := range toolCalls {
+					if toolCallMap, ok := toolCall.(map[string]interface{}); ok {
+						copied := map[string]interface{}{"index": i}
+						for k, v := range toolCallMap {
+							copied[k] = v
+						}
+						toolCall = copied
+					}
+					indexed[i] = toolCall
+				}
+				delta["tool_calls"] = indexed
+			}
+		}
+		chunks = append(chunks,
+			newChunk([]interface{}{map[string]interface{}{"index": choiceMap["index"], "delta": delta, "finish_reason": nil}}),
+			newChunk([]interface{}{map[string]interface{}{"index": choiceMap["index"], "delta": map[string]interface{}{}, "finish_reason": choiceMap["finish_reason"]}}),
+		)
+	}
+	if usage, ok := response["usage"]; ok && includeUsage {
+		chunk := newChunk([]interface{}{})
+		chunk["usage"] = usage
+		chunks = append(chunks, chunk)
+	}
+	
+	for _, chunk := range chunks {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			continue
+		}
+		event := &utils.SSEEvent{Data: string(data)}
+		c.Writer.Write(event.Bytes())
+	}
+	c.Writer.Write((&utils.SSEEvent{Data: "[DONE]"}).Bytes())
+	c.Writer.Flush()
+}
+
+// completion assembles the chat completion a finished stream delivered, or
+// returns nil if the stream cannot be cached (incomplete or with tool calls)
+func (r *streamRelay) completion() map[string]interface{} {
+	if !r.done || r.clientGone || r.toolCallsSent || r.streamID == "" {
+		return nil
+	}
+	
+	created := r.created
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+	completion := map[string]interface{}{
+		"id":      r.streamID,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   r.originalModel,
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index": 0,
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": r.content.String(),
+				},
+				"finish_reason": r.finishReason,
+			},
+		},
+	}
+	if r.usage != nil {
+		completion["usage"] = r.usage
+	}
+	return completion
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	
+	"azure-openai-proxy/internal/config"
+	
+	"github.com/gin-gonic/gin"
+)
+
+// coalescedResult is the response of a leader request, replayed to followers
+type coalescedResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// coalescedCall is an in-flight request that identical requests wait on
+type coalescedCall struct {
+	done   chan struct{}
+	result *coalescedResult // nil if the leader produced no complete response
+}
+
+// requestCoalescer tracks in-flight requests by key so identical requests
+// share one upstream call
+type requestCoalescer struct {
+	config config.CoalescingConfig
+	calls  map[string]*coalescedCall
+	mutex  sync.Mutex
+}
+
+// newRequestCoalescer creates a new request coalescer
+func newRequestCoalescer(cfg config.CoalescingConfig) *requestCoalescer {
+	return &requestCoalescer{
+		config: cfg,
+		calls:  make(map[string]*coalescedCall),
+	}
+}
+
+// join returns the in-flight call for a key, starting one if there is none.
+// The caller leads the call if leader is true.
+func (rc *requestCoalescer) join(key string) (call *coalescedCall, leader bool) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	
+	if call, exists := rc.calls[key]; exists {
+		return call, false
+	}
+	call = &coalescedCall{done: make(chan struct{})}
+	rc.calls[key] = call
+	return call, true
+}
+
+// finish publishes the leader's response and releases the waiting followers
+func (rc *requestCoalescer) finish(key string, call *coalescedCall, result *coalescedResult) {
+	rc.mutex.Lock()
+	delete(rc.calls, key)
+	rc.mutex.Unlock()
+	
+	call.result = result
+	close(call.done)
+}
+
+// SetCoalescing enables coalescing of identical in-flight requests
+func (h *ProxyHandler) SetCoalescing(cfg config.CoalescingConfig) {
+	h.coalescer = newRequestCoalescer(cfg)
+}
+
+// coalescingKey returns the key identical requests share, or "" if the
+// request is not coalesced. Streaming requests never are, and keys are scoped
+// to the client key.
+func (h *ProxyHandler) coalescingKey(c *gin.Context, endpoint string, payload map[string]interface{}) string {
+	if h.coalescer == nil || !h.coalescer.config.CoalescesEndpoint(endpoint) {
+		return ""
+	}
+	if stream, _ := payload["stream"].(bool); stream {
+		return ""
+	}
+	
+	scope := ""
+	if client := clientFromContext(c); client != nil {
+		scope = client.Name
+	}
+	return requestHash(endpoint, scope, payload)
+}
+
+// coalesceRequest joins an identical in-flight request. Followers wait for
+// the leader's response and replay it, returning true. The leader gets a
+// function to call once its response is written; it returns false, as does a
+// follower whose leader produced no usable response and must proceed itself.
+func (h *ProxyHandler) coalesceRequest(c *gin.Context, key string) (finish func(), served bool) {
+	call, leader := h.coalescer.join(key)
+	if leader {
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		return func() {
+			h.coalescer.finish(key, call, recorder.result(c))
+		}, false
+	}
+	
+	select {
+	case <-call.done:
+	case <-c.Request.Context().Done():
+		return nil, true // the client went away, nothing to answer
+	}
+	if call.result == nil {
+		return nil, false
+	}
+	
+	h.instanceManager.RecordCoalescedRequest()
+	for name, values := range call.result.header {
+		// The follower keeps its own request ID
+		if name == "X-Request-Id" {
+			continue
+		}
+		c.Writer.Header()[name] = values
+	}
+	c.Header("X-Coalesced", "true")
+	c.Data(call.result.status, call.result.header.Get("Content-Type"), call.result.body)
+	return nil, true
+}
+
+// responseRecorder copies a response as it is written so it can be replayed
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes to the client and records the data
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// WriteString writes to the client and records the data
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// result returns the recorded response, or nil if none was completed
+// (nothing written, or the client went away)
+func (r *responseRecorder) result(c *gin.Context) *coalescedResult {
+	if !r.Written() || clientCancelled(c) {
+		return nil
+	}
+	
+	return &coalescedResult{
+		status: r.Status(),
+		header: r.Header().Clone(),
+		body:   r.body.Bytes(),
+	}
+}
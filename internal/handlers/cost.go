@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	
+	"azure-openai-proxy/internal/services"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// anonymousClient is the client costs are recorded for when no client key identified the request
+const anonymousClient = "anonymous"
+
+// recordCost prices the usage reported for a request to an instance and adds
+// it to the costs of the instance, model and client. It reads chat completion
+// and embeddings usage (prompt/completion tokens) as well as Responses API
+// usage (input/output tokens).
+func (h *ProxyHandler) recordCost(c *gin.Context, instanceName, model string, usage map[string]interface{}) {
+	inputTokens, ok := usageCount(usage, "prompt_tokens")
+	outputTokens, _ := usageCount(usage, "completion_tokens")
+	details, _ := usage["prompt_tokens_details"].(map[string]interface{})
+	if !ok {
+		inputTokens, ok = usageCount(usage, "input_tokens")
+		outputTokens, _ = usageCount(usage, "output_tokens")
+		details, _ = usage["input_tokens_details"].(map[string]interface{})
+	}
+	if !ok {
+		return
+	}
+	cachedTokens, _ := usageCount(details, "cached_tokens")
+	
+	client := anonymousClient
+	if clientConfig := clientFromContext(c); clientConfig != nil {
+		client = clientConfig.Name
+	}
+	
+	// The tokens were used even if the client went away meanwhile
+	cost, err := h.instanceManager.RecordCost(context.Background(), instanceName, model, client, inputTokens, cachedTokens, outputTokens)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to record request cost")
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"instance":      instanceName,
+		"model":         model,
+		"client":        client,
+		"input_tokens":  inputTokens,
+		"cached_tokens": cachedTokens,
+		"output_tokens": outputTokens,
+		"cost":          cost,
+	}).Debug("Recorded request cost")
+}
+
+// recordEstimatedCost records the cost of a request the upstream reported no
+// usage for, such as the cancelled attempt of a hedged request or a stream
+// without a usage chunk. The prompt is estimated from the request and the
+// completion from the output relayed to the client.
+func (h *ProxyHandler) recordEstimatedCost(c *gin.Context, instanceName, endpoint string, payload map[string]interface{}, output string) {
+	promptTokens, err := h.transformer.EstimateRequestTokens(endpoint, payload)
+	if err != nil {
+		return
+	}
+	model, _ := payload["model"].(string)
+	h.recordCost(c, instanceName, model, map[string]interface{}{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": h.transformer.EstimateOutputTokens(output, model),
+	})
+}
+
+// streamIncludesUsage reports whether a streaming request asked for the usage
+// chunk with stream_options.include_usage
+func streamIncludesUsage(payload map[string]interface{}) bool {
+	options, _ := payload["stream_options"].(map[string]interface{})
+	includeUsage, _ := options["include_usage"].(bool)
+	return includeUsage
+}
+
+// requestStreamUsage asks the upstream for the usage chunk at the end of a
+// chat completion stream, so the request can be costed even if the client did
+// not ask for it. The relay then drops the chunk. Instances on API versions
+// rejecting stream_options are left alone and their streams costed from
+// estimates.
+func requestStreamUsage(azureService *services.AzureService, payload map[string]interface{}) {
+	if !azureService.SupportsStreamUsage() {
+		return
+	}
+	options, _ := payload["stream_options"].(map[string]interface{})
+	withUsage := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		withUsage[k] = v
+	}
+	withUsage["include_usage"] = true
+	payload["stream_options"] = withUsage
+}
+
+// usageCount reads a token count from a usage object decoded from JSON or
+// built by the proxy
+func usageCount(usage map[string]interface{}, key string) (int, bool) {
+	switch value := usage[key].(type) {
+	case float64:
+		return int(value), true
+	case int:
+		return value, true
+	}
+	return 0, false
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/errors"
+	"azure-openai-proxy/internal/instance"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// embeddingCaller is one embeddings request waiting in a batch
+type embeddingCaller struct {
+	inputs   []interface{}
+	tokens   int
+	priority int // admission queue priority
+	done     chan embeddingResult
+}
+
+// embeddingResult is a caller's share of a batch response. If unbatched is
+// set the caller sends its request upstream on its own instead.
+type embeddingResult struct {
+	response  map[string]interface{}
+	instance  string // instance that served the batch
+	err       *errors.ProxyError
+	unbatched bool
+}
+
+// embeddingBatch collects requests sharing a model, parameters and routing
+// rule until it is full or its wait expires
+type embeddingBatch struct {
+	key     string
+	params  map[string]interface{} // request fields other than input
+	route   instance.RequestRoute  // of the first caller, selects the instance for all
+	callers []*embeddingCaller
+	inputs  int
+	tokens  int
+	timer   *time.Timer
+	flushed bool
+}
+
+// embeddingBatcher holds the open batch for each model and parameter set
+type embeddingBatcher struct {
+	config  config.EmbeddingBatchingConfig
+	batches map[string]*embeddingBatch
+	mutex   sync.Mutex
+}
+
+// newEmbeddingBatcher creates a new embeddings batcher
+func newEmbeddingBatcher(cfg config.EmbeddingBatchingConfig) *embeddingBatcher {
+	return &embeddingBatcher{
+		config:  cfg,
+		batches: make(map[string]*embeddingBatch),
+	}
+}
+
+// detach closes a batch to new callers, returning false if it was already
+// flushed. The caller must hold the mutex.
+func (eb *embeddingBatcher) detach(batch *embeddingBatch) bool {
+	if batch.flushed {
+		return false
+	}
+	batch.flushed = true
+	batch.timer.Stop()
+	if eb.batches[batch.key] == batch {
+		delete(eb.batches, batch.key)
+	}
+	return true
+}
+
+// SetEmbeddingBatching enables merging concurrent embeddings requests into
+// batched upstream calls
+func (h *ProxyHandler) SetEmbeddingBatching(cfg config.EmbeddingBatchingConfig) {
+	h.embeddingBatcher = newEmbeddingBatcher(cfg)
+}
+
+// serveBatchedEmbedding answers an embeddings request as part of a batch. It
+// returns false, having written nothing, if the request is not batchable or
+// the batch asked its callers to proceed on their own. The response is
+// returned for caching when one was written.
+func (h *ProxyHandler) serveBatchedEmbedding(c *gin.Context, payload map[string]interface{}) (map[string]interface{}, bool) {
+	inputs, ok := embeddingInputs(payload["input"])
+	if !ok || len(inputs) > h.embeddingBatcher.config.GetMaxBatchSize() {
+		return nil, false
+	}
+	
+	tokens, err := h.transformer.EstimateRequestTokens("/v1/embeddings", payload)
+	if err != nil {
+		return nil, false
+	}
+	modelName, _ := payload["model"].(string)
+	if len(h.instanceManager.ModelFallbacks(modelName)) > 0 {
+		// The fallback chain is tried per request
+		return nil, false
+	}
+	tokenLimit := h.embeddingTokenLimit(modelName)
+	if tokenLimit > 0 && tokens > tokenLimit {
+		return nil, false
+	}
+	
+	params := make(map[string]interface{})
+	for key, value := range payload {
+		if key != "input" {
+			params[key] = value
+		}
+	}
+	caller := &embeddingCaller{
+		inputs:   inputs,
+		tokens:   tokens,
+		priority: requestPriority(c),
+		done:     make(chan embeddingResult, 1),
+	}
+	ctx := c.Request.Context()
+	route, _ := instance.RequestRouteFromContext(ctx)
+	key := requestHash("/v1/embeddings", h.instanceManager.RoutingRuleName(ctx, modelName), params)
+	h.enqueueEmbedding(key, params, route, caller, tokenLimit)
+	
+	var result embeddingResult
+	select {
+	case result = <-caller.done:
+	case <-c.Request.Context().Done():
+		return nil, true // the client went away, the batch goes on without it
+	}
+	
+	switch {
+	case result.unbatched:
+		return nil, false
+	case result.err != nil:
+		h.sendErrorResponse(c, result.err)
+		return nil, true
+	}
+	c.JSON(http.StatusOK, result.response)
+	if usage, ok := result.response["usage"].(map[string]interface{}); ok {
+		h.recordCost(c, result.instance, modelName, usage)
+	}
+	return result.response, true
+}
+
+// enqueueEmbedding adds a caller to the open batch for its key. A batch is
+// sent once it reaches the maximum size or the token limit of the smallest
+// instance serving the model, or when its wait expires.
+func (h *ProxyHandler) enqueueEmbedding(key string, params map[string]interface{}, route instance.RequestRoute, caller *embeddingCaller, tokenLimit int) {
+	eb := h.embeddingBatcher
+	maxBatchSize := eb.config.GetMaxBatchSize()
+	
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+	
+	// Send the open batch first if this caller would overflow it
+	batch := eb.batches[key]
+	if batch != nil && (batch.inputs+len(caller.inputs) > maxBatchSize ||
+		tokenLimit > 0 && batch.tokens+caller.tokens > tokenLimit) {
+		eb.detach(batch)
+		go h.sendEmbeddingBatch(batch)
+		batch = nil
+	}
+	
+	if batch == nil {
+		batch = &embeddingBatch{key: key, params: params, route: route}
+		eb.batches[key] = batch
+		opened := batch
+		batch.timer = time.AfterFunc(eb.config.GetMaxWait(), func() {
+			eb.mutex.Lock()
+			expired := eb.detach(opened)
+			eb.mutex.Unlock()
+			if expired {
+				h.sendEmbeddingBatch(opened)
+			}
+		})
+	}
+	
+	batch.callers = append(batch.callers, caller)
+	batch.inputs += len(caller.inputs)
+	batch.tokens += caller.tokens
+	if batch.inputs >= maxBatchSize || tokenLimit > 0 && batch.tokens >= tokenLimit {
+		eb.detach(batch)
+		go h.sendEmbeddingBatch(batch)
+	}
+}
+
+// sendEmbeddingBatch sends a batch upstream as one request and hands every
+// caller its share of the response
+func (h *ProxyHandler) sendEmbeddingBatch(batch *embeddingBatch) {
+	// A lone caller gains nothing from batching
+	if len(batch.callers) == 1 {
+		batch.callers[0].done <- embeddingResult{unbatched: true}
+		return
+	}
+	
+	// The batch waits for capacity at the highest priority of its callers
+	var inputs []interface{}
+	priority := config.PriorityLow
+	for _, caller := range batch.callers {
+		inputs = append(inputs, caller.inputs...)
+		if caller.priority < priority {
+			priority = caller.priority
+		}
+	}
+	payload := make(map[string]interface{}, len(batch.params)+1)
+	for key, value := range batch.params {
+		payload[key] = value
+	}
+	payload["input"] = inputs
+	
+	logrus.WithFields(logrus.Fields{
+		"requests": len(batch.callers),
+		"inputs":   len(inputs),
+		"tokens":   batch.tokens,
+	}).Debug("Sending embeddings batch")
+	
+	response, instanceName, proxyErr := h.proxyEmbeddingBatch(batch.route, payload, len(inputs), batch.tokens, priority)
+	if proxyErr != nil {
+		// A rejected input must not fail the other callers' requests
+		unbatched := proxyErr.StatusCode >= 400 && proxyErr.StatusCode < 500 && proxyErr.StatusCode != http.StatusTooManyRequests
+		for _, caller := range batch.callers {
+			caller.done <- embeddingResult{err: proxyErr, unbatched: unbatched}
+		}
+		return
+	}
+	h.instanceManager.RecordEmbeddingBatch(len(batch.callers))
+	
+	data, _ := response["data"].([]interface{})
+	usage, _ := response["usage"].(map[string]interface{})
+	promptTokens, _ := usage["prompt_tokens"].(float64)
+	
+	offset, apportioned := 0, 0
+	for i, caller := range batch.callers {
+		// Usage is split by each caller's share of the estimated tokens, the
+		// last caller taking the rounding remainder
+		callerTokens := int(promptTokens) * caller.tokens / batch.tokens
+		if i == len(batch.callers)-1 {
+			callerTokens = int(promptTokens) - apportioned
+		}
+		apportioned += callerTokens
+		
+		callerData := make([]interface{}, len(caller.inputs))
+		for j := range caller.inputs {
+			item := make(map[string]interface{})
+			for key, value := range data[offset+j].(map[string]interface{}) {
+				item[key] = value
+			}
+			item["index"] = j
+			callerData[j] = item
+		}
+		offset += len(caller.inputs)
+		
+		callerResponse := make(map[string]interface{}, len(response))
+		for key, value := range response {
+			callerResponse[key] = value
+		}
+		callerResponse["data"] = callerData
+		callerResponse["usage"] = map[string]interface{}{
+			"prompt_tokens": callerTokens,
+			"total_tokens":  callerTokens,
+		}
+		caller.done <- embeddingResult{response: callerResponse, instance: instanceName}
+	}
+}
+
+// proxyEmbeddingBatch sends a batched embeddings request upstream, returning
+// the OpenAI-format response with data ordered by index and the instance that
+// served it. Like a single request, it waits in the admission queue for an
+// instance with capacity for its estimated tokens.
+func (h *ProxyHandler) proxyEmbeddingBatch(route instance.RequestRoute, payload map[string]interface{}, inputCount, tokens, priority int) (map[string]interface{}, string, *errors.ProxyError) {
+	// The batch outlives any one caller, so it is not bound to their requests
+	ctx := instance.WithRequestRoute(context.Background(), route)
+	startTime := time.Now()
+	modelName, _ := payload["model"].(string)
+	
+	instanceName, err := h.instanceManager.SelectInstanceQueued(ctx, modelName, tokens, "azure", priority)
+	if stderrors.Is(err, instance.ErrQueueFull) || stderrors.Is(err, instance.ErrQueueTimeout) {
+		return nil, "", errors.NewUpstreamError("rate limit exceeded: "+err.Error(), 429, map[string]interface{}{
+			"model":       modelName,
+			"tokens":      tokens,
+			"retry_after": 1,
+		})
+	}
+	if err != nil {
+		return nil, "", errors.NewInstanceError("no suitable instance available", map[string]interface{}{
+			"model":    modelName,
+			"endpoint": "/v1/embeddings",
+			"error":    err.Error(),
+		})
+	}
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(instanceName)
+	if err != nil {
+		return nil, "", errors.NewInternalError("failed to get instance config", map[string]interface{}{
+			"instance": instanceName,
+			"error":    err.Error(),
+		})
+	}
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		return nil, "", errors.NewInternalError("Azure service not found for instance", map[string]interface{}{
+			"instance": instanceName,
+		})
+	}
+	
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	transformResult, err := h.transformer.TransformOpenAIToAzure(ctx, "/v1/embeddings", payload, deploymentName)
+	if err != nil {
+		return nil, "", errors.NewInternalError("request transformation failed", map[string]interface{}{
+			"error": err.Error(),
+			"model": modelName,
+		})
+	}
+	
+	hasCapacity, err := h.instanceManager.CheckRateLimit(ctx, instanceName, transformResult.RequiredTokens)
+	if err != nil {
+		logrus.WithError(err).Warn("Rate limit check failed")
+	}
+	if !hasCapacity {
+		return nil, "", errors.NewUpstreamError("rate limit exceeded", 429, map[string]interface{}{
+			"instance": instanceName,
+			"tokens":   transformResult.RequiredTokens,
+		})
+	}
+	
+	cleanPayload := h.transformer.CleanRequestMetadata(transformResult.Payload)
+	resp, err := azureService.ProxyRequest(ctx, "/v1/embeddings", cleanPayload, deploymentName)
+	if err != nil {
+		if proxyErr, ok := err.(*errors.ProxyError); ok {
+			return nil, "", proxyErr
+		}
+		return nil, "", errors.NewUpstreamError("request failed", 500, map[string]interface{}{
+			"error":    err.Error(),
+			"instance": instanceName,
+		})
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode >= 400 {
+		h.recordError(instanceName, resp.StatusCode)
+		return nil, "", azureService.ParseErrorResponse(resp)
+	}
+	
+	var responseData map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, "", errors.NewUpstreamError("failed to decode embeddings response", 502, map[string]interface{}{
+			"error":    err.Error(),
+			"instance": instanceName,
+		})
+	}
+	h.recordUsage(instanceName, transformResult.RequiredTokens, startTime)
+	
+	// Order the embeddings by input index so they can be split per caller
+	data, _ := responseData["data"].([]interface{})
+	ordered := make([]interface{}, inputCount)
+	for _, entry := range data {
+		item, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if index, ok := item["index"].(float64); ok && int(index) >= 0 && int(index) < inputCount {
+			ordered[int(index)] = item
+		}
+	}
+	for _, item := range ordered {
+		if item == nil {
+			return nil, "", errors.NewUpstreamError("embeddings response is missing inputs", 502, map[string]interface{}{
+				"instance": instanceName,
+				"inputs":   inputCount,
+				"returned": len(data),
+			})
+		}
+	}
+	responseData["data"] = ordered
+	
+	transformed, err := h.transformer.TransformAzureToOpenAI(ctx, responseData, transformResult.OriginalModel)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to transform response, returning as-is")
+		transformed = responseData
+	}
+	return transformed, instanceName, nil
+}
+
+// embeddingTokenLimit returns the smallest MaxInputTokens among the enabled
+// instances serving a model, or 0 if none is limited
+func (h *ProxyHandler) embeddingTokenLimit(modelName string) int {
+	modelLower := strings.ToLower(modelName)
+	limit := 0
+	for _, cfg := range h.instanceManager.GetAllConfigs() {
+		if !cfg.Enabled || cfg.MaxInputTokens <= 0 || !supportsModel(cfg, modelLower) {
+			continue
+		}
+		if limit == 0 || cfg.MaxInputTokens < limit {
+			limit = cfg.MaxInputTokens
+		}
+	}
+	return limit
+}
+
+// embeddingInputs returns the inputs of an embeddings request as a list. Only
+// text inputs are batched; token arrays are sent as they are.
+func embeddingInputs(input interface{}) ([]interface{}, bool) {
+	switch v := input.(type) {
+	case string:
+		return []interface{}{v}, true
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.(string); !ok {
+				return nil, false
+			}
+		}
+		return v, len(v) > 0
+	}
+	return nil, false
+}
+
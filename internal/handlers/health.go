@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+	
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler serves the load balancer health endpoint
+type HealthHandler struct {
+	draining atomic.Bool
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler() *HealthHandler {
+	return &HealthHandler{}
+}
+
+// SetDraining marks the server as draining so load balancers stop routing to it
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+// IsDraining reports whether the server is shutting down
+func (h *HealthHandler) IsDraining() bool {
+	return h.draining.Load()
+}
+
+// Health handles /health requests
+func (h *HealthHandler) Health(c *gin.Context) {
+	if h.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// hedgeBudgetWindow is the period over which a client's hedging budget applies
+const hedgeBudgetWindow = time.Minute
+
+// hedgeBudget counts a client's hedgeable requests and hedges in the current window
+type hedgeBudget struct {
+	windowStart time.Time
+	requests    int
+	hedges      int
+}
+
+// hedgeBudgets limits hedges to a share of each client's requests
+type hedgeBudgets struct {
+	budgets map[string]*hedgeBudget
+	mutex   sync.Mutex
+}
+
+// newHedgeBudgets creates a new set of per-client hedging budgets
+func newHedgeBudgets() *hedgeBudgets {
+	return &hedgeBudgets{
+		budgets: make(map[string]*hedgeBudget),
+	}
+}
+
+// current returns the client's budget, starting a new window when the last
+// one expired. The caller must hold the mutex.
+func (hb *hedgeBudgets) current(client string) *hedgeBudget {
+	budget, exists := hb.budgets[client]
+	if !exists || time.Since(budget.windowStart) > hedgeBudgetWindow {
+		budget = &hedgeBudget{windowStart: time.Now()}
+		hb.budgets[client] = budget
+	}
+	return budget
+}
+
+// countRequest counts a hedgeable request towards the client's budget
+func (hb *hedgeBudgets) countRequest(client string) {
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+	hb.current(client).requests++
+}
+
+// spend takes a hedge from the client's budget, returning false if hedges
+// would exceed the given percentage of its requests
+func (hb *hedgeBudgets) spend(client string, percent float64) bool {
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+	
+	budget := hb.current(client)
+	if float64(budget.hedges+1) > float64(budget.requests)*percent/100 {
+		return false
+	}
+	budget.hedges++
+	return true
+}
+
+// hedgeAttempt is the outcome of one of the upstream requests of a hedged request
+type hedgeAttempt struct {
+	instance string
+	resp     *http.Response
+	err      error
+	cancel   context.CancelFunc
+}
+
+// failed reports whether another attempt's response should be preferred
+func (a hedgeAttempt) failed() bool {
+	return a.err != nil || a.resp.StatusCode >= 500 || a.resp.StatusCode == http.StatusTooManyRequests
+}
+
+// discard releases an attempt that is not used
+func (a hedgeAttempt) discard() {
+	if a.resp != nil {
+		a.resp.Body.Close()
+	}
+	a.cancel()
+}
+
+// result returns the attempt's response, keeping its context alive until the
+// body is closed
+func (a hedgeAttempt) result() (*http.Response, string, error) {
+	if a.err != nil {
+		a.cancel()
+		return nil, a.instance, a.err
+	}
+	a.resp.Body = &cancelOnClose{ReadCloser: a.resp.Body, cancel: a.cancel}
+	return a.resp, a.instance, nil
+}
+
+// cancelOnClose releases an attempt's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the request context
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// hedgeable reports whether a non-streaming request may be hedged
+func (h *ProxyHandler) hedgeable(endpoint string, tokens int) bool {
+	hedging := h.instanceManager.GetRoutingConfig().Hedging
+	return hedging.HedgesEndpoint(endpoint) && tokens <= hedging.GetMaxPromptTokens()
+}
+
+// proxyWithHedge sends a request to the selected instance and, if it has not
+// answered within the configured percentile of its recent latency, sends the
+// same request to a second instance. The first usable response wins and the
+// other request is cancelled. It returns the response and the instance that
+// produced it.
+func (h *ProxyHandler) proxyWithHedge(c *gin.Context, endpoint string, payload map[string]interface{}, primary string, primaryPayload map[string]interface{}, deploymentName string, tokens int) (*http.Response, string, error) {
+	hedging := h.instanceManager.GetRoutingConfig().Hedging
+	clientName, budgetPercent := "", hedging.GetBudgetPercent()
+	if client := clientFromContext(c); client != nil {
+		clientName = client.Name
+		if client.HedgeBudget > 0 {
+			budgetPercent = client.HedgeBudget
+		}
+	}
+	h.hedgeBudgets.countRequest(clientName)
+	
+	attempts := make(chan hedgeAttempt, 2)
+	cancelPrimary := h.sendAttempt(c, attempts, primary, endpoint, primaryPayload, deploymentName)
+	
+	// Without enough latency samples there is no basis for a hedge delay
+	delay, ok := h.instanceManager.LatencyPercentile(primary, hedging.GetPercentile())
+	if !ok {
+		return (<-attempts).result()
+	}
+	if delay < hedging.GetMinDelay() {
+		delay = hedging.GetMinDelay()
+	}
+	
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case attempt := <-attempts:
+		return attempt.result()
+	case <-timer.C:
+	}
+	
+	hedgeInstance, cancelHedge, ok := h.sendHedge(c, attempts, endpoint, payload, primary, tokens, clientName, budgetPercent)
+	if !ok {
+		return (<-attempts).result()
+	}
+	
+	// Prefer the first usable response, falling back to the last failure
+	winner := <-attempts
+	if winner.failed() {
+		logrus.WithFields(logrus.Fields{
+			"instance": winner.instance,
+			"error":    winner.err,
+		}).Debug("Hedged attempt failed, waiting for the other")
+		if winner.resp != nil {
+			h.recordError(winner.instance, winner.resp.StatusCode)
+		} else if !clientCancelled(c) {
+			h.recordError(winner.instance, 0)
+		}
+		winner.discard()
+		winner = <-attempts
+	} else {
+		// Cancel the loser; its prompt still counts against its instance's
+		// limit and is billed
+		loser, cancelLoser := primary, cancelPrimary
+		if winner.instance == primary {
+			loser, cancelLoser = hedgeInstance, cancelHedge
+		}
+		cancelLoser()
+		if err := h.instanceManager.UpdateUsage(context.Background(), loser, tokens); err != nil {
+			logrus.WithError(err).WithField("instance", loser).Warn("Failed to update usage")
+		}
+		h.recordEstimatedCost(c, loser, endpoint, payload, "")
+		go func() {
+			(<-attempts).discard()
+		}()
+	}
+	
+	h.instanceManager.RecordHedge(winner.instance == hedgeInstance)
+	logrus.WithFields(logrus.Fields{
+		"primary": primary,
+		"hedge":   hedgeInstance,
+		"winner":  winner.instance,
+		"delay":   delay.String(),
+	}).Debug("Hedged request completed")
+	return winner.result()
+}
+
+// sendHedge sends the hedge request to another instance with capacity if the
+// client's budget allows it, returning the instance and its cancel function
+func (h *ProxyHandler) sendHedge(c *gin.Context, attempts chan hedgeAttempt, endpoint string, payload map[string]interface{}, primary string, tokens int, clientName string, budgetPercent float64) (string, context.CancelFunc, bool) {
+	ctx := c.Request.Context()
+	modelName, _ := payload["model"].(string)
+	
+	hedgeInstance, err := h.instanceManager.SelectInstanceExcluding(ctx, modelName, tokens, "azure", []string{primary})
+	if err != nil {
+		return "", nil, false
+	}
+	if !h.hedgeBudgets.spend(clientName, budgetPercent) {
+		h.instanceManager.RecordHedgeSkipped()
+		return "", nil, false
+	}
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(hedgeInstance)
+	if err != nil {
+		return "", nil, false
+	}
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	transformResult, err := h.transformer.TransformOpenAIToAzure(ctx, endpoint, payload, deploymentName)
+	if err != nil {
+		return "", nil, false
+	}
+	
+	cancel := h.sendAttempt(c, attempts, hedgeInstance, endpoint, h.transformer.CleanRequestMetadata(transformResult.Payload), deploymentName)
+	return hedgeInstance, cancel, true
+}
+
+// sendAttempt sends one upstream request in the background, delivering its
+// outcome to attempts. It returns a function cancelling the request.
+func (h *ProxyHandler) sendAttempt(c *gin.Context, attempts chan<- hedgeAttempt, instanceName, endpoint string, payload map[string]interface{}, deploymentName string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		attempts <- hedgeAttempt{instance: instanceName, err: fmt.Errorf("Azure service not found for instance %s", instanceName), cancel: cancel}
+		return cancel
+	}
+	
+	go func() {
+		resp, err := azureService.ProxyRequest(ctx, endpoint, payload, deploymentName)
+		attempts <- hedgeAttempt{instance: instanceName, resp: resp, err: err, cancel: cancel}
+	}()
+	return cancel
+}
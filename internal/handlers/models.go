@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/errors"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ownerForProvider maps a provider type to the OpenAI "owned_by" value
+func ownerForProvider(providerType string) string {
+	if providerType == "azure" {
+		return "azure-openai"
+	}
+	return providerType
+}
+
+// ListModels handles GET /v1/models
+func (h *ProxyHandler) ListModels(c *gin.Context) {
+	client := clientFromContext(c)
+	states := h.instanceStates(c)
+	
+	models := make([]gin.H, 0)
+	for _, modelID := range h.collectModelIDs() {
+		if client != nil && !clientAllowsModel(client, modelID, h.instanceManager.ResolveModel(modelID)) {
+			continue
+		}
+		models = append(models, h.buildModelObject(modelID, states))
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   models,
+	})
+}
+
+// RetrieveModel handles GET /v1/models/:id
+func (h *ProxyHandler) RetrieveModel(c *gin.Context) {
+	requested := strings.ToLower(c.Param("id"))
+	client := clientFromContext(c)
+	
+	for _, modelID := range h.collectModelIDs() {
+		if strings.ToLower(modelID) != requested {
+			continue
+		}
+		if client != nil && !clientAllowsModel(client, modelID, h.instanceManager.ResolveModel(modelID)) {
+			break
+		}
+		c.JSON(http.StatusOK, h.buildModelObject(modelID, h.instanceStates(c)))
+		return
+	}
+	
+	proxyErr := errors.NewClientError("model not found", 404, map[string]interface{}{
+		"model": c.Param("id"),
+	})
+	h.sendErrorResponse(c, proxyErr)
+}
+
+// collectModelIDs returns the sorted union of models supported by enabled
+// instances and the aliases of those models
+func (h *ProxyHandler) collectModelIDs() []string {
+	seen := make(map[string]bool)
+	modelIDs := make([]string, 0)
+	
+	for _, cfg := range h.instanceManager.GetAllConfigs() {
+		if !cfg.Enabled {
+			continue
+		}
+		for _, model := range cfg.SupportedModels {
+			modelLower := strings.ToLower(model)
+			if seen[modelLower] {
+				continue
+			}
+			seen[modelLower] = true
+			modelIDs = append(modelIDs, model)
+		}
+	}
+	for alias, model := range h.instanceManager.ModelAliases() {
+		if seen[strings.ToLower(model)] && !seen[alias] {
+			seen[alias] = true
+			modelIDs = append(modelIDs, alias)
+		}
+	}
+	
+	sort.Strings(modelIDs)
+	return modelIDs
+}
+
+// instanceStates returns the last known state of every instance, read once
+// per request rather than per model
+func (h *ProxyHandler) instanceStates(c *gin.Context) map[string]*config.InstanceState {
+	states, err := h.instanceManager.GetAllInstanceStates(c.Request.Context())
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get instance states")
+		return map[string]*config.InstanceState{}
+	}
+	return states
+}
+
+// buildModelObject builds an OpenAI model object with proxy extension fields.
+// Aliases describe the model they refer to. Availability comes from the
+// instance states kept by health monitoring and rate limit tracking.
+func (h *ProxyHandler) buildModelObject(modelID string, states map[string]*config.InstanceState) gin.H {
+	resolved := h.instanceManager.ResolveModel(modelID)
+	modelLower := strings.ToLower(resolved)
+	
+	ownedBy := ""
+	available := false
+	instances := make([]gin.H, 0)
+	
+	for _, cfg := range h.instanceManager.GetAllConfigs() {
+		if !cfg.Enabled || !supportsModel(cfg, modelLower) {
+			continue
+		}
+		if ownedBy == "" {
+			ownedBy = ownerForProvider(cfg.ProviderType)
+		}
+		
+		status := config.InstanceStatus("unknown")
+		instanceAvailable := false
+		if state, exists := states[cfg.Name]; exists {
+			status = state.Status
+			instanceAvailable = state.IsHealthy()
+		}
+		available = available || instanceAvailable
+		
+		instances = append(instances, gin.H{
+			"name":       cfg.Name,
+			"deployment": h.transformer.GetDeploymentName(resolved, cfg.ModelDeployments),
+			"status":     status,
+			"available":  instanceAvailable,
+		})
+	}
+	
+	modelInfo := h.transformer.GetModelInfo(resolved)
+	
+	model := gin.H{
+		"id":                 modelID,
+		"object":             "model",
+		"created":            h.createdAt,
+		"owned_by":           ownedBy,
+		"context_length":     modelInfo["max_tokens"],
+		"supports_vision":    modelInfo["supports_vision"],
+		"supports_functions": modelInfo["supports_functions"],
+		"available":          available,
+		"instances":          instances,
+	}
+	if !strings.EqualFold(resolved, modelID) {
+		model["alias_for"] = resolved
+	}
+	if fallbacks := h.instanceManager.ModelFallbacks(resolved); len(fallbacks) > 0 {
+		model["fallbacks"] = fallbacks
+	}
+	return model
+}
+
+// supportsModel checks if an instance lists the model (case-insensitive)
+func supportsModel(cfg config.InstanceConfig, modelLower string) bool {
+	for _, supportedModel := range cfg.SupportedModels {
+		if strings.ToLower(supportedModel) == modelLower {
+			return true
+		}
+	}
+	return false
+}
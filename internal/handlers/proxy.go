@@ -1,19 +1,22 @@
 package handlers
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 	
 	"azure-openai-proxy/internal/config"
 	"azure-openai-proxy/internal/errors"
 	"azure-openai-proxy/internal/instance"
 	"azure-openai-proxy/internal/services"
+	"azure-openai-proxy/internal/storage"
+	"azure-openai-proxy/internal/utils"
 	
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -24,27 +27,64 @@ type ProxyHandler struct {
 	instanceManager *instance.Manager
 	transformer     *services.RequestTransformer
 	azureServices   map[string]*services.AzureService
+	createdAt       int64
+	
+	// Exact-match and semantic response caches, nil when disabled
+	responseCache storage.ResponseCache
+	vectorIndex   storage.VectorIndex
+	cacheConfig   config.CacheConfig
+	
+	// Shares upstream calls among identical in-flight requests, nil when disabled
+	coalescer *requestCoalescer
+	
+	// Merges concurrent embeddings requests into batched calls, nil when disabled
+	embeddingBatcher *embeddingBatcher
+	
+	// Per-client limits on hedged requests
+	hedgeBudgets *hedgeBudgets
+	
+	// Open realtime WebSocket sessions, closed on shutdown
+	realtimeSessions map[*realtimeSession]struct{}
+	realtimeMutex    sync.Mutex
+	realtimeWG       sync.WaitGroup
+	realtimeClosing  bool
 }
 
 // NewProxyHandler creates a new proxy handler
 func NewProxyHandler(instanceManager *instance.Manager) *ProxyHandler {
 	handler := &ProxyHandler{
-		instanceManager: instanceManager,
-		transformer:     services.NewRequestTransformer(),
-		azureServices:   make(map[string]*services.AzureService),
+		instanceManager:  instanceManager,
+		transformer:      services.NewRequestTransformer(),
+		azureServices:    make(map[string]*services.AzureService),
+		createdAt:        time.Now().Unix(),
+		hedgeBudgets:     newHedgeBudgets(),
+		realtimeSessions: make(map[*realtimeSession]struct{}),
 	}
 	
 	// Initialize Azure services for each instance
 	configs := instanceManager.GetAllConfigs()
 	for _, cfg := range configs {
 		if cfg.ProviderType == "azure" {
-			handler.azureServices[cfg.Name] = services.NewAzureService(cfg)
+			azureService := services.NewAzureService(cfg)
+			azureService.SetRequestTracker(instanceManager)
+			handler.azureServices[cfg.Name] = azureService
 		}
 	}
 	
 	return handler
 }
 
+// Close closes open realtime sessions and releases the upstream HTTP
+// transports of all Azure services
+func (h *ProxyHandler) Close() error {
+	h.closeRealtimeSessions()
+	
+	for _, azureService := range h.azureServices {
+		azureService.Close()
+	}
+	return nil
+}
+
 // ChatCompletions handles /v1/chat/completions requests
 func (h *ProxyHandler) ChatCompletions(c *gin.Context) {
 	h.handleProxyRequest(c, "/v1/chat/completions")
@@ -60,9 +100,15 @@ func (h *ProxyHandler) Embeddings(c *gin.Context) {
 	h.handleProxyRequest(c, "/v1/embeddings")
 }
 
+// ImageGenerations handles /v1/images/generations requests
+func (h *ProxyHandler) ImageGenerations(c *gin.Context) {
+	h.handleProxyRequest(c, "/v1/images/generations")
+}
+
 // handleProxyRequest is the main proxy logic
 func (h *ProxyHandler) handleProxyRequest(c *gin.Context, endpoint string) {
 	startTime := time.Now()
+	withRequestRoute(c, endpoint)
 	
 	// Parse request payload
 	var payload map[string]interface{}
@@ -83,20 +129,60 @@ func (h *ProxyHandler) handleProxyRequest(c *gin.Context, endpoint string) {
 		return
 	}
 	
-	// Extract model and determine tokens needed
-	modelName, _ := payload["model"].(string)
+	// Extract model, resolving aliases to the registered model
+	requestedModel, _ := payload["model"].(string)
+	modelName := h.instanceManager.ResolveModel(requestedModel)
+	payload["model"] = modelName
+	
+	// Deterministic requests are answered from the cache without touching an
+	// instance, so hits neither wait for nor count against rate limits
+	cacheKey := h.responseCacheKey(c, endpoint, payload)
+	if cacheKey != "" && h.serveCachedResponse(c, cacheKey, endpoint, payload) {
+		return
+	}
+	semanticQuery, served := h.serveSemanticCache(c, endpoint, payload)
+	if served {
+		return
+	}
+	
+	// Identical in-flight requests wait for the first one's response
+	if coalesceKey := h.coalescingKey(c, endpoint, payload); coalesceKey != "" {
+		finish, served := h.coalesceRequest(c, coalesceKey)
+		if served {
+			return
+		}
+		if finish != nil {
+			defer finish()
+		}
+	}
+	
+	// Concurrent embeddings requests for the same model share one upstream call
+	if endpoint == "/v1/embeddings" && h.embeddingBatcher != nil {
+		if response, served := h.serveBatchedEmbedding(c, payload); served {
+			if cacheKey != "" && response != nil {
+				h.storeCachedResponse(cacheKey, nil, response)
+			}
+			return
+		}
+	}
+	
+	// Sessions and shared prompt prefixes stay on one instance under the
+	// consistent_hash strategy
+	h.withSessionKey(c, payload)
 	
 	// Get instance configuration to determine deployment mapping
-	selectedInstance, err := h.instanceManager.SelectInstance(c.Request.Context(), modelName, 0, "azure")
-	if err != nil {
-		proxyErr := errors.NewInstanceError("no suitable instance available", map[string]interface{}{
-			"model":    modelName,
-			"endpoint": endpoint,
-			"error":    err.Error(),
-		})
-		h.sendErrorResponse(c, proxyErr)
+	selectedInstance, servedModel, ok := h.selectInstanceForRequest(c, endpoint, payload, modelName)
+	if !ok {
 		return
 	}
+	if servedModel != modelName {
+		// A fallback model serves the request; its answer is not cached as
+		// the requested model's
+		modelName = servedModel
+		payload["model"] = servedModel
+		cacheKey, semanticQuery = "", nil
+	}
+	c.Header("X-Served-Model", modelName)
 	
 	instanceConfig, err := h.instanceManager.GetInstanceConfig(selectedInstance)
 	if err != nil {
@@ -136,6 +222,12 @@ func (h *ProxyHandler) handleProxyRequest(c *gin.Context, endpoint string) {
 		return
 	}
 	
+	// Images and speech are additionally limited per image and per character
+	if proxyErr := h.checkUnitCapacity(c.Request.Context(), selectedInstance, unitRequirements(transformResult)); proxyErr != nil {
+		h.sendErrorResponse(c, proxyErr)
+		return
+	}
+	
 	// Check if streaming is requested
 	isStreaming := false
 	if stream, ok := payload["stream"].(bool); ok && stream {
@@ -158,23 +250,32 @@ func (h *ProxyHandler) handleProxyRequest(c *gin.Context, endpoint string) {
 	// Send request to Azure
 	var resp *http.Response
 	if isStreaming {
+		if endpoint == "/v1/chat/completions" {
+			requestStreamUsage(azureService, cleanPayload)
+		}
 		resp, err = azureService.StreamRequest(c.Request.Context(), endpoint, cleanPayload, deploymentName)
+	} else if h.hedgeable(endpoint, transformResult.RequiredTokens) {
+		// A slow instance may be overtaken by a second one
+		resp, selectedInstance, err = h.proxyWithHedge(c, endpoint, payload, selectedInstance, cleanPayload, deploymentName, transformResult.RequiredTokens)
+		azureService = h.azureServices[selectedInstance]
 	} else {
 		resp, err = azureService.ProxyRequest(c.Request.Context(), endpoint, cleanPayload, deploymentName)
 	}
 	
 	if err != nil {
-		if proxyErr, ok := err.(*errors.ProxyError); ok {
-			h.sendErrorResponse(c, proxyErr)
-		} else {
-			proxyErr := errors.NewUpstreamError("request failed", 500, map[string]interface{}{
-				"error":    err.Error(),
-				"instance": selectedInstance,
-			})
-			h.sendErrorResponse(c, proxyErr)
-		}
+		h.sendUpstreamFailure(c, selectedInstance, err)
 		return
 	}
+	h.instanceManager.RecordDeploymentUtilization(selectedInstance, resp.Header.Get(deploymentUtilizationHeader))
+	
+	// A throttled provisioned instance spills the request over to another one
+	if resp.StatusCode == http.StatusTooManyRequests && h.instanceManager.IsProvisioned(selectedInstance) {
+		if spilled, spillInstance, ok := h.spillOver(c, endpoint, payload, selectedInstance, resp, isStreaming, transformResult.RequiredTokens); ok {
+			resp.Body.Close()
+			resp, selectedInstance = spilled, spillInstance
+			azureService = h.azureServices[selectedInstance]
+		}
+	}
 	defer resp.Body.Close()
 	
 	// Handle error responses
@@ -187,25 +288,154 @@ func (h *ProxyHandler) handleProxyRequest(c *gin.Context, endpoint string) {
 	
 	// Record successful usage
 	h.recordUsage(selectedInstance, transformResult.RequiredTokens, startTime)
+	h.recordUnitUsage(selectedInstance, unitRequirements(transformResult))
+	if transformResult.RequiredImages > 0 {
+		h.recordImageUsage(selectedInstance, transformResult)
+	}
+	if transformResult.RequiredCharacters > 0 {
+		h.recordAudioUsage(selectedInstance, 0, transformResult.RequiredCharacters)
+	}
 	
 	// Stream or return response
-	if isStreaming {
-		h.streamResponse(c, resp, transformResult.OriginalModel)
-	} else {
-		h.forwardResponse(c, resp, transformResult.OriginalModel)
+	switch {
+	case isStreaming && endpoint == "/v1/chat/completions":
+		relay := h.streamChatWithFailover(c, resp, selectedInstance, payload, transformResult.OriginalModel)
+		if relay.usage != nil {
+			h.recordPromptCache(relay.instance, relay.usage)
+			h.recordCost(c, relay.instance, modelName, relay.usage)
+		} else {
+			h.recordAttemptCost(c, relay, payload)
+		}
+		if completion := relay.completion(); (cacheKey != "" || semanticQuery != nil) && completion != nil {
+			h.storeCachedResponse(cacheKey, semanticQuery, completion)
+		}
+	case isStreaming:
+		h.streamResponse(c, resp, endpoint, selectedInstance, transformResult.OriginalModel, h.responseEventObserver(c, endpoint, selectedInstance, modelName, transformResult.RequiredTokens))
+	case endpoint == "/v1/audio/speech":
+		h.streamBinaryResponse(c, resp)
+	case endpoint == "/v1/images/generations":
+		// Image responses carry no model to restore
+		h.forwardResponse(c, resp, selectedInstance, "")
+	default:
+		responseData := h.forwardResponse(c, resp, selectedInstance, transformResult.OriginalModel)
+		if usage, ok := responseData["usage"].(map[string]interface{}); ok {
+			if endpoint == "/v1/chat/completions" {
+				h.recordPromptCache(selectedInstance, usage)
+			}
+			h.recordCost(c, selectedInstance, modelName, usage)
+		}
+		if endpoint == "/v1/responses" && responseData != nil {
+			h.recordResponseResult(c, selectedInstance, transformResult.RequiredTokens, responseData)
+		}
+		if (cacheKey != "" || semanticQuery != nil) && responseData != nil && resp.StatusCode == http.StatusOK {
+			if cached, err := h.transformer.TransformAzureToOpenAI(c.Request.Context(), responseData, transformResult.OriginalModel); err == nil {
+				h.storeCachedResponse(cacheKey, semanticQuery, cached)
+			}
+		}
+	}
+}
+
+// selectInstanceForRequest picks the instance for a request and the model it
+// serves, which is a fallback model if the requested one has no capacity. It
+// sends an error response on failure.
+func (h *ProxyHandler) selectInstanceForRequest(c *gin.Context, endpoint string, payload map[string]interface{}, modelName string) (string, string, bool) {
+	// Chained responses only resolve on the instance that stored the previous one
+	if previousID, _ := payload["previous_response_id"].(string); endpoint == "/v1/responses" && previousID != "" {
+		selectedInstance, ok := h.resolveAffinity(c, affinityResponse, previousID)
+		return selectedInstance, modelName, ok
+	}
+	
+	// With an admission queue the request waits for an instance with capacity
+	// for its estimated tokens instead of being rejected, and with a fallback
+	// chain it moves on to a model with capacity for them
+	tokens := 0
+	if h.instanceManager.QueuesRequests() || len(h.instanceManager.ModelFallbacks(modelName)) > 0 {
+		tokens, _ = h.transformer.EstimateRequestTokens(endpoint, payload)
+	}
+	selectedInstance, servedModel, err := h.selectModelInstance(c, modelName, tokens)
+	if stderrors.Is(err, instance.ErrQueueFull) || stderrors.Is(err, instance.ErrQueueTimeout) {
+		proxyErr := errors.NewUpstreamError("rate limit exceeded: "+err.Error(), 429, map[string]interface{}{
+			"model":       modelName,
+			"tokens":      tokens,
+			"retry_after": 1,
+		})
+		h.sendErrorResponse(c, proxyErr)
+		return "", "", false
+	}
+	if err != nil {
+		proxyErr := errors.NewInstanceError("no suitable instance available", map[string]interface{}{
+			"model":    modelName,
+			"endpoint": endpoint,
+			"error":    err.Error(),
+		})
+		h.sendErrorResponse(c, proxyErr)
+		return "", "", false
 	}
+	return selectedInstance, servedModel, true
 }
 
-// forwardResponse forwards a non-streaming response
-func (h *ProxyHandler) forwardResponse(c *gin.Context, resp *http.Response, originalModel string) {
+// selectModelInstance selects an instance for the model, trying the model's
+// fallback chain in order when no instance for it has capacity. Only if none
+// of them has capacity does the request queue for the model itself.
+func (h *ProxyHandler) selectModelInstance(c *gin.Context, modelName string, tokens int) (string, string, error) {
+	ctx := c.Request.Context()
+	
+	if fallbacks := h.instanceManager.ModelFallbacks(modelName); len(fallbacks) > 0 {
+		if selectedInstance, err := h.instanceManager.SelectInstance(ctx, modelName, tokens, "azure"); err == nil {
+			return selectedInstance, modelName, nil
+		}
+		
+		for _, fallback := range fallbacks {
+			if selectedInstance, err := h.instanceManager.SelectInstance(ctx, fallback, tokens, "azure"); err == nil {
+				logrus.WithFields(logrus.Fields{
+					"model":    modelName,
+					"fallback": fallback,
+					"instance": selectedInstance,
+				}).Info("No capacity for model, using fallback")
+				return selectedInstance, fallback, nil
+			}
+		}
+	}
+	
+	selectedInstance, err := h.instanceManager.SelectInstanceQueued(ctx, modelName, tokens, "azure", requestPriority(c))
+	return selectedInstance, modelName, err
+}
+
+// clientAllowsModel checks the client's allowlist against both the requested
+// name and the model it resolves to
+func clientAllowsModel(client *config.ClientConfig, requestedModel, modelName string) bool {
+	return client.AllowsModel(requestedModel) || client.AllowsModel(modelName)
+}
+
+// requestPriority returns the admission queue priority of a request. The
+// x-priority header may lower the client key's priority but not raise it.
+func requestPriority(c *gin.Context) int {
+	priority := config.PriorityNormal
+	client := clientFromContext(c)
+	if client != nil {
+		priority = client.GetPriority()
+	}
+	if requested, ok := config.ParsePriority(c.GetHeader("x-priority")); ok && (client == nil || requested > priority) {
+		priority = requested
+	}
+	return priority
+}
+
+// forwardResponse forwards a non-streaming response, returning the parsed body
+// if it was JSON. Without an original model the body is forwarded as is.
+func (h *ProxyHandler) forwardResponse(c *gin.Context, resp *http.Response, instanceName, originalModel string) map[string]interface{} {
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		if clientCancelled(c) {
+			h.recordCancelled(instanceName, true)
+			return nil
+		}
 		proxyErr := errors.NewInternalError("failed to read response", map[string]interface{}{
 			"error": err.Error(),
 		})
 		h.sendErrorResponse(c, proxyErr)
-		return
+		return nil
 	}
 	
 	// Parse and transform response
@@ -213,14 +443,18 @@ func (h *ProxyHandler) forwardResponse(c *gin.Context, resp *http.Response, orig
 	if err := json.Unmarshal(body, &responseData); err != nil {
 		// If can't parse as JSON, return as-is
 		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
-		return
+		return nil
 	}
 	
 	// Transform back to OpenAI format
-	transformedResponse, err := h.transformer.TransformAzureToOpenAI(c.Request.Context(), responseData, originalModel)
-	if err != nil {
-		logrus.WithError(err).Warn("Failed to transform response, returning as-is")
-		transformedResponse = responseData
+	transformedResponse := responseData
+	if originalModel != "" {
+		transformed, err := h.transformer.TransformAzureToOpenAI(c.Request.Context(), responseData, originalModel)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to transform response, returning as-is")
+		} else {
+			transformedResponse = transformed
+		}
 	}
 	
 	// Copy headers
@@ -231,10 +465,40 @@ func (h *ProxyHandler) forwardResponse(c *gin.Context, resp *http.Response, orig
 	}
 	
 	c.JSON(resp.StatusCode, transformedResponse)
+	return responseData
 }
 
-// streamResponse streams a response back to the client
-func (h *ProxyHandler) streamResponse(c *gin.Context, resp *http.Response, originalModel string) {
+// doneTerminatedEndpoints are the endpoints whose streams end with [DONE]
+var doneTerminatedEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+}
+
+// streamResponse streams a response back to the client. If observe is set it
+// is called with every parsed event.
+func (h *ProxyHandler) streamResponse(c *gin.Context, resp *http.Response, endpoint, instanceName, originalModel string, observe func(map[string]interface{})) {
+	h.writeStreamHeaders(c, resp)
+	
+	relay := &streamRelay{originalModel: originalModel, observe: observe}
+	err := h.relayStream(c, resp, relay)
+	if err == nil && !relay.clientGone && !relay.done && doneTerminatedEndpoints[endpoint] {
+		// A bare EOF before [DONE] is a dropped connection
+		err = io.ErrUnexpectedEOF
+	}
+	switch {
+	case relay.clientGone:
+		h.recordCancelled(instanceName, true)
+	case err != nil:
+		// End with an error event rather than silently truncating
+		logrus.WithError(err).Error("Error reading stream")
+		// The request was counted as successful when the upstream accepted it
+		h.recordErrorOutcome(instanceName, streamErrorStatus(err), true)
+		h.writeStreamError(c, err, relay.namedEvents)
+	}
+}
+
+// writeStreamHeaders sets the SSE headers and the upstream status
+func (h *ProxyHandler) writeStreamHeaders(c *gin.Context, resp *http.Response) {
 	// Set streaming headers
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -253,53 +517,208 @@ func (h *ProxyHandler) streamResponse(c *gin.Context, resp *http.Response, origi
 	}
 	
 	c.Status(resp.StatusCode)
-	
-	// Stream the response
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
+}
+
+// streamRelay tracks what has been relayed to the client across one or more
+// upstream streams
+type streamRelay struct {
+	originalModel string
+	observe       func(map[string]interface{})
+	
+	namedEvents bool // upstream uses "event:" names (Responses API)
+	done        bool // [DONE] was relayed
+	clientGone  bool // writing to the client failed
+	
+	// Chat completion progress, used for mid-stream failover
+	streamID      string          // id of the first stream, kept across failovers
+	created       int64           // creation time of the first stream
+	finishReason  string          // finish reason of choice 0
+	attempt       int             // number of upstream streams relayed before this one
+	attemptStart  int             // length of content when the current stream started
+	roleSent      bool            // the assistant role chunk was relayed
+	content       strings.Builder // assistant text relayed for choice 0
+	toolCallsSent bool            // tool call or multi-choice deltas were relayed
+	
+	instance  string                 // instance serving the current stream
+	usage     map[string]interface{} // usage chunk, requested from the upstream to cost the request
+	hideUsage bool                   // the client did not ask for the usage chunk
+}
+
+// contentSent reports whether any completion output reached the client
+func (r *streamRelay) contentSent() bool {
+	return r.content.Len() > 0 || r.toolCallsSent
+}
+
+// relayStream copies upstream events to the client until the stream ends,
+// returning the upstream read error if it fails
+func (h *ProxyHandler) relayStream(c *gin.Context, resp *http.Response, relay *streamRelay) error {
+	reader := utils.NewSSEReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// A client disconnect cancels the upstream request, failing the read
+			if clientCancelled(c) {
+				logrus.Info("Client disconnected, upstream stream cancelled")
+				relay.clientGone = true
+				return nil
+			}
+			return err
+		}
+		relay.namedEvents = relay.namedEvents || event.Event != ""
 		
-		// SSE format: "data: {json}\n\n"
-		if strings.HasPrefix(line, "data: ") {
-			dataStr := strings.TrimPrefix(line, "data: ")
-			
-			// Handle special cases
-			if dataStr == "[DONE]" {
-				c.Writer.WriteString("data: [DONE]\n\n")
-				c.Writer.Flush()
-				break
+		// Handle special cases
+		if event.Data == "[DONE]" {
+			c.Writer.Write(event.Bytes())
+			c.Writer.Flush()
+			relay.done = true
+			return nil
+		}
+		
+		// Parse and transform the JSON chunk; unparseable data passes through as-is
+		var chunkData map[string]interface{}
+		if err := json.Unmarshal([]byte(event.Data), &chunkData); err == nil {
+			if relay.observe != nil {
+				relay.observe(chunkData)
+			}
+			if !relay.trackChunk(chunkData) {
+				continue
 			}
 			
-			// Parse and transform the JSON chunk
-			var chunkData map[string]interface{}
-			if err := json.Unmarshal([]byte(dataStr), &chunkData); err == nil {
-				// Transform model name back to original
-				transformedChunk, err := h.transformer.TransformAzureToOpenAI(c.Request.Context(), chunkData, originalModel)
-				if err == nil {
-					chunkData = transformedChunk
+			// Transform model name back to original
+			if transformedChunk, err := h.transformer.TransformAzureToOpenAI(c.Request.Context(), chunkData, relay.originalModel); err == nil {
+				if jsonBytes, err := json.Marshal(transformedChunk); err == nil {
+					event.Data = string(jsonBytes)
 				}
-				
-				// Write transformed chunk
-				if jsonBytes, err := json.Marshal(chunkData); err == nil {
-					c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", string(jsonBytes)))
-				} else {
-					c.Writer.WriteString(line + "\n\n")
-				}
-			} else {
-				// If can't parse, pass through as-is
-				c.Writer.WriteString(line + "\n\n")
 			}
-		} else {
-			// Pass through non-data lines
-			c.Writer.WriteString(line + "\n")
 		}
 		
+		if _, err := c.Writer.Write(event.Bytes()); err != nil {
+			logrus.WithError(err).Warn("Client closed stream")
+			relay.clientGone = true
+			return nil
+		}
 		c.Writer.Flush()
 	}
+}
+
+// trackChunk records chat completion progress and makes chunks from a
+// failover stream look like part of the original one. It returns false for
+// chunks that must not be relayed.
+func (r *streamRelay) trackChunk(chunk map[string]interface{}) bool {
+	choices, _ := chunk["choices"].([]interface{})
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		r.usage = usage
+		if r.hideUsage && len(choices) == 0 {
+			return false
+		}
+	}
+	if r.hideUsage {
+		// Content chunks carry a null usage once it is requested
+		delete(chunk, "usage")
+	}
+	if id, ok := chunk["id"].(string); ok && id != "" {
+		if r.streamID == "" {
+			r.streamID = id
+			created, _ := chunk["created"].(float64)
+			r.created = int64(created)
+		} else if r.attempt > 0 {
+			chunk["id"] = r.streamID
+		}
+	}
 	
-	if err := scanner.Err(); err != nil {
-		logrus.WithError(err).Error("Error reading stream")
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delta, _ := choiceMap["delta"].(map[string]interface{})
+		if delta == nil {
+			continue
+		}
+		
+		if index, _ := choiceMap["index"].(float64); index != 0 {
+			r.toolCallsSent = true
+			continue
+		}
+		if finishReason, ok := choiceMap["finish_reason"].(string); ok {
+			r.finishReason = finishReason
+		}
+		if _, ok := delta["tool_calls"]; ok {
+			r.toolCallsSent = true
+		}
+		
+		content, _ := delta["content"].(string)
+		if _, hasRole := delta["role"]; hasRole {
+			// A failover stream repeats the opening role chunk
+			if r.roleSent && content == "" && r.attempt > 0 {
+				return false
+			}
+			r.roleSent = true
+		}
+		r.content.WriteString(content)
 	}
+	
+	return true
+}
+
+// writeStreamError sends a final SSE error event after an upstream failure
+// mid-stream. The event is named "error" when the upstream uses named events
+// (Responses API) and unnamed otherwise, matching what SDKs parse.
+func (h *ProxyHandler) writeStreamError(c *gin.Context, err error, named bool) {
+	message := "upstream stream failed"
+	code := http.StatusBadGateway
+	if err == services.ErrStreamIdleTimeout || err == services.ErrStreamDeadline {
+		message = err.Error()
+		code = http.StatusGatewayTimeout
+	}
+	
+	payload := map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    string(errors.ErrorTypeUpstream),
+			"code":    code,
+		},
+	}
+	if named {
+		payload["type"] = "error"
+	}
+	
+	data, _ := json.Marshal(payload)
+	event := &utils.SSEEvent{Data: string(data)}
+	if named {
+		event.Event = "error"
+	}
+	c.Writer.Write(event.Bytes())
+	c.Writer.Flush()
+}
+
+// clientCancelled reports whether the client went away, which cancels the
+// request context and with it any upstream call made with it
+func clientCancelled(c *gin.Context) bool {
+	return c.Request.Context().Err() == context.Canceled
+}
+
+// clientFromContext returns the client identified by the ClientIdentity middleware, if any
+func clientFromContext(c *gin.Context) *config.ClientConfig {
+	if value, exists := c.Get("client"); exists {
+		if client, ok := value.(*config.ClientConfig); ok {
+			return client
+		}
+	}
+	return nil
+}
+
+// withRequestRoute attaches the endpoint, client and headers routing rules
+// match on to the request's context
+func withRequestRoute(c *gin.Context, endpoint string) {
+	route := instance.RequestRoute{Endpoint: endpoint, Headers: c.Request.Header}
+	if client := clientFromContext(c); client != nil {
+		route.Client = client.Name
+	}
+	c.Request = c.Request.WithContext(instance.WithRequestRoute(c.Request.Context(), route))
 }
 
 // sendErrorResponse sends a standardized error response
@@ -351,6 +770,8 @@ func (h *ProxyHandler) recordUsage(instanceName string, tokens int, startTime ti
 	state.LastUsed = time.Now()
 	
 	// Calculate latency
+	h.instanceManager.RecordLatency(instanceName, time.Since(startTime))
+	h.instanceManager.RecordRequestResult(instanceName, false)
 	latency := float64(time.Since(startTime).Milliseconds())
 	if state.AvgLatencyMs == nil {
 		state.AvgLatencyMs = &latency
@@ -365,8 +786,83 @@ func (h *ProxyHandler) recordUsage(instanceName string, tokens int, startTime ti
 	}
 }
 
+// unitRequirements lists the non-token capacity units a request consumes
+func unitRequirements(result *services.TransformResult) map[string]int {
+	return map[string]int{
+		instance.UnitImages:     result.RequiredImages,
+		instance.UnitCharacters: result.RequiredCharacters,
+	}
+}
+
+// checkUnitCapacity checks per-unit rate limits, returning a 429 error if any is exhausted
+func (h *ProxyHandler) checkUnitCapacity(ctx context.Context, instanceName string, units map[string]int) *errors.ProxyError {
+	for unit, amount := range units {
+		if amount <= 0 {
+			continue
+		}
+		
+		hasCapacity, err := h.instanceManager.CheckUnitRateLimit(ctx, instanceName, unit, amount)
+		if err != nil {
+			logrus.WithError(err).WithField("unit", unit).Warn("Rate limit check failed")
+		}
+		if !hasCapacity {
+			return errors.NewUpstreamError(fmt.Sprintf("%s rate limit exceeded", unit), 429, map[string]interface{}{
+				"instance": instanceName,
+				unit:       amount,
+			})
+		}
+	}
+	
+	return nil
+}
+
+// recordUnitUsage records consumption of non-token capacity units
+func (h *ProxyHandler) recordUnitUsage(instanceName string, units map[string]int) {
+	ctx := context.Background()
+	
+	for unit, amount := range units {
+		if amount <= 0 {
+			continue
+		}
+		if err := h.instanceManager.UpdateUnitUsage(ctx, instanceName, unit, amount); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"instance": instanceName,
+				"unit":     unit,
+			}).Warn("Failed to update usage")
+		}
+	}
+}
+
+// recordImageUsage records generated images per size and quality
+func (h *ProxyHandler) recordImageUsage(instanceName string, result *services.TransformResult) {
+	ctx := context.Background()
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	if state.ImagesBySizeQuality == nil {
+		state.ImagesBySizeQuality = make(map[string]int)
+	}
+	state.TotalImagesGenerated += result.RequiredImages
+	state.ImagesBySizeQuality[result.ImageSize+"/"+result.ImageQuality] += result.RequiredImages
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
+
 // recordError records an error occurrence
 func (h *ProxyHandler) recordError(instanceName string, statusCode int) {
+	h.recordErrorOutcome(instanceName, statusCode, false)
+}
+
+// recordErrorOutcome records a failed request. If the request was already
+// counted as successful when the upstream accepted it, as streams are, it is
+// moved to the error outcome instead.
+func (h *ProxyHandler) recordErrorOutcome(instanceName string, statusCode int, countedSuccessful bool) {
 	ctx := context.Background()
 	
 	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
@@ -377,7 +873,13 @@ func (h *ProxyHandler) recordError(instanceName string, statusCode int) {
 	
 	// Update error counts
 	state.ErrorCount++
-	state.TotalRequests++
+	if countedSuccessful {
+		state.SuccessfulRequests--
+		h.instanceManager.RecordLateFailure(instanceName)
+	} else {
+		state.TotalRequests++
+		h.instanceManager.RecordRequestResult(instanceName, statusCode >= 500 || statusCode == 0)
+	}
 	
 	// Update specific error type counts
 	switch {
@@ -400,4 +902,28 @@ func (h *ProxyHandler) recordError(instanceName string, statusCode int) {
 	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
 		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state after error")
 	}
-}
\ No newline at end of file
+}
+
+// recordCancelled records a request abandoned by the client. If the request
+// was already counted as successful when the upstream accepted it, it is moved
+// to the cancelled outcome instead.
+func (h *ProxyHandler) recordCancelled(instanceName string, countedSuccessful bool) {
+	ctx := context.Background()
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	state.CancelledRequests++
+	if countedSuccessful {
+		state.SuccessfulRequests--
+	} else {
+		state.TotalRequests++
+	}
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
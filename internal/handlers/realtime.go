@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/errors"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// realtimeCloseTimeout bounds how long close frames may take to send
+const realtimeCloseTimeout = time.Second
+
+// realtimeUpgrader upgrades client connections; origins are handled by the CORS middleware
+var realtimeUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// realtimeSession is a client WebSocket relayed to an upstream realtime deployment
+type realtimeSession struct {
+	instance  string
+	client    *websocket.Conn
+	upstream  *websocket.Conn
+	closeOnce sync.Once
+}
+
+// close sends a close frame to both peers and closes the connections
+func (s *realtimeSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		deadline := time.Now().Add(realtimeCloseTimeout)
+		message := websocket.FormatCloseMessage(code, reason)
+		s.client.WriteControl(websocket.CloseMessage, message, deadline)
+		s.upstream.WriteControl(websocket.CloseMessage, message, deadline)
+		s.client.Close()
+		s.upstream.Close()
+	})
+}
+
+// Realtime handles GET /v1/realtime by relaying the WebSocket to Azure
+func (h *ProxyHandler) Realtime(c *gin.Context) {
+	withRequestRoute(c, "/v1/realtime")
+	
+	// Azure clients pass the deployment, OpenAI clients the model
+	requestedModel := c.Query("model")
+	if requestedModel == "" {
+		requestedModel = c.Query("deployment")
+	}
+	if requestedModel == "" {
+		h.sendErrorResponse(c, errors.NewClientError("model query parameter is required for realtime sessions", 400, nil))
+		return
+	}
+	modelName := h.instanceManager.ResolveModel(requestedModel)
+	
+	selectedInstance, err := h.instanceManager.SelectRealtimeInstance(c.Request.Context(), modelName)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInstanceError("no suitable instance available", map[string]interface{}{
+			"model":    modelName,
+			"endpoint": "/v1/realtime",
+			"error":    err.Error(),
+		}))
+		return
+	}
+	
+	// Another session may have taken the last slot since selection
+	if !h.instanceManager.AcquireRealtimeSession(selectedInstance) {
+		h.sendErrorResponse(c, errors.NewUpstreamError("realtime session limit reached", 429, map[string]interface{}{
+			"instance": selectedInstance,
+		}))
+		return
+	}
+	defer h.instanceManager.ReleaseRealtimeSession(selectedInstance)
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(selectedInstance)
+	if err != nil {
+		h.sendErrorResponse(c, errors.NewInternalError("failed to get instance config", map[string]interface{}{
+			"instance": selectedInstance,
+			"error":    err.Error(),
+		}))
+		return
+	}
+	
+	azureService, exists := h.azureServices[selectedInstance]
+	if !exists {
+		h.sendErrorResponse(c, errors.NewInternalError("Azure service not found for instance", map[string]interface{}{
+			"instance": selectedInstance,
+		}))
+		return
+	}
+	
+	// Connect upstream first so failures are still reported as HTTP errors
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	upstream, resp, err := azureService.DialRealtime(c.Request.Context(), deploymentName)
+	if err != nil {
+		if resp != nil {
+			h.sendErrorResponse(c, azureService.ParseErrorResponse(resp))
+			h.recordError(selectedInstance, resp.StatusCode)
+		} else if proxyErr, ok := err.(*errors.ProxyError); ok {
+			h.sendErrorResponse(c, proxyErr)
+		} else {
+			h.sendErrorResponse(c, errors.NewUpstreamError("realtime connection failed", 502, map[string]interface{}{
+				"error":    err.Error(),
+				"instance": selectedInstance,
+			}))
+		}
+		return
+	}
+	
+	client, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written an HTTP error
+		logrus.WithError(err).Warn("Failed to upgrade realtime connection")
+		upstream.Close()
+		return
+	}
+	
+	session := &realtimeSession{
+		instance: selectedInstance,
+		client:   client,
+		upstream: upstream,
+	}
+	if !h.registerRealtimeSession(session) {
+		session.close(websocket.CloseGoingAway, "proxy shutting down")
+		return
+	}
+	defer h.unregisterRealtimeSession(session)
+	
+	h.recordRealtimeSession(selectedInstance)
+	logrus.WithFields(logrus.Fields{
+		"instance":   selectedInstance,
+		"deployment": deploymentName,
+	}).Info("Realtime session started")
+	
+	h.relayRealtime(session)
+	
+	logrus.WithField("instance", selectedInstance).Info("Realtime session ended")
+}
+
+// relayRealtime copies frames in both directions until either side closes
+func (h *ProxyHandler) relayRealtime(session *realtimeSession) {
+	done := make(chan struct{}, 2)
+	
+	// Client -> Azure
+	go func() {
+		defer func() { done <- struct{}{} }()
+		relayFrames(session.client, session.upstream, nil)
+	}()
+	
+	// Azure -> client, accounting usage from response.done events
+	go func() {
+		defer func() { done <- struct{}{} }()
+		relayFrames(session.upstream, session.client, func(data []byte) {
+			h.inspectRealtimeEvent(session.instance, data)
+		})
+	}()
+	
+	// When one direction ends, close the other with a normal closure
+	<-done
+	session.close(websocket.CloseNormalClosure, "")
+	<-done
+}
+
+// relayFrames copies messages from src to dst, passing text messages to inspect.
+// Close frames from src are forwarded with their code.
+func relayFrames(src, dst *websocket.Conn, inspect func([]byte)) {
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				dst.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(closeErr.Code, closeErr.Text),
+					time.Now().Add(realtimeCloseTimeout))
+			}
+			return
+		}
+		
+		if inspect != nil && messageType == websocket.TextMessage {
+			inspect(data)
+		}
+		
+		if err := dst.WriteMessage(messageType, data); err != nil {
+			return
+		}
+	}
+}
+
+// inspectRealtimeEvent records token usage reported by response.done events
+func (h *ProxyHandler) inspectRealtimeEvent(instanceName string, data []byte) {
+	var event struct {
+		Type     string `json:"type"`
+		Response struct {
+			Usage *struct {
+				TotalTokens        int `json:"total_tokens"`
+				InputTokenDetails  struct {
+					TextTokens  int `json:"text_tokens"`
+					AudioTokens int `json:"audio_tokens"`
+				} `json:"input_token_details"`
+				OutputTokenDetails struct {
+					TextTokens  int `json:"text_tokens"`
+					AudioTokens int `json:"audio_tokens"`
+				} `json:"output_token_details"`
+			} `json:"usage"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Type != "response.done" || event.Response.Usage == nil {
+		return
+	}
+	
+	usage := event.Response.Usage
+	textTokens := usage.InputTokenDetails.TextTokens + usage.OutputTokenDetails.TextTokens
+	audioTokens := usage.InputTokenDetails.AudioTokens + usage.OutputTokenDetails.AudioTokens
+	h.recordRealtimeUsage(instanceName, usage.TotalTokens, textTokens, audioTokens)
+}
+
+// recordRealtimeSession counts a started realtime session
+func (h *ProxyHandler) recordRealtimeSession(instanceName string) {
+	ctx := context.Background()
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	state.TotalRealtimeSessions++
+	state.LastUsed = time.Now()
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
+
+// recordRealtimeUsage records the tokens of one realtime response
+func (h *ProxyHandler) recordRealtimeUsage(instanceName string, totalTokens, textTokens, audioTokens int) {
+	ctx := context.Background()
+	
+	if err := h.instanceManager.UpdateUsage(ctx, instanceName, totalTokens); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update usage")
+	}
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	state.TotalTokensServed += int64(totalTokens)
+	state.RealtimeTextTokens += int64(textTokens)
+	state.RealtimeAudioTokens += int64(audioTokens)
+	state.LastUsed = time.Now()
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
+
+// registerRealtimeSession tracks a session for shutdown, returning false if
+// the handler is already closing
+func (h *ProxyHandler) registerRealtimeSession(session *realtimeSession) bool {
+	h.realtimeMutex.Lock()
+	defer h.realtimeMutex.Unlock()
+	
+	if h.realtimeClosing {
+		return false
+	}
+	h.realtimeSessions[session] = struct{}{}
+	h.realtimeWG.Add(1)
+	return true
+}
+
+// unregisterRealtimeSession removes a finished session
+func (h *ProxyHandler) unregisterRealtimeSession(session *realtimeSession) {
+	h.realtimeMutex.Lock()
+	delete(h.realtimeSessions, session)
+	h.realtimeMutex.Unlock()
+	h.realtimeWG.Done()
+}
+
+// closeRealtimeSessions sends a going-away close frame to every open session
+// and waits for their relays to finish
+func (h *ProxyHandler) closeRealtimeSessions() {
+	h.realtimeMutex.Lock()
+	h.realtimeClosing = true
+	sessions := make([]*realtimeSession, 0, len(h.realtimeSessions))
+	for session := range h.realtimeSessions {
+		sessions = append(sessions, session)
+	}
+	h.realtimeMutex.Unlock()
+	
+	if len(sessions) > 0 {
+		logrus.WithField("sessions", len(sessions)).Info("Closing realtime sessions")
+	}
+	for _, session := range sessions {
+		session.close(websocket.CloseGoingAway, "proxy shutting down")
+	}
+	
+	h.realtimeWG.Wait()
+}
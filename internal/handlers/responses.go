@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// affinityResponse marks stored responses, which previous_response_id can only
+// reference on the instance that created them
+const affinityResponse = "response"
+
+// Responses handles POST /v1/responses
+func (h *ProxyHandler) Responses(c *gin.Context) {
+	h.handleProxyRequest(c, "/v1/responses")
+}
+
+// GetResponse handles GET /v1/responses/:id
+func (h *ProxyHandler) GetResponse(c *gin.Context) {
+	responseID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityResponse, responseID)
+	if !ok {
+		return
+	}
+	
+	h.proxyResource(c, instanceName, "GET", "/v1/responses/"+responseID, nil, "")
+}
+
+// DeleteResponse handles DELETE /v1/responses/:id
+func (h *ProxyHandler) DeleteResponse(c *gin.Context) {
+	responseID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityResponse, responseID)
+	if !ok {
+		return
+	}
+	
+	if _, ok := h.proxyResource(c, instanceName, "DELETE", "/v1/responses/"+responseID, nil, ""); ok {
+		if err := h.instanceManager.DeleteAffinity(context.Background(), affinityResponse, responseID); err != nil {
+			logrus.WithError(err).WithField("response_id", responseID).Warn("Failed to delete response affinity")
+		}
+	}
+}
+
+// CancelResponse handles POST /v1/responses/:id/cancel for background responses
+func (h *ProxyHandler) CancelResponse(c *gin.Context) {
+	responseID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityResponse, responseID)
+	if !ok {
+		return
+	}
+	
+	h.proxyResource(c, instanceName, "POST", "/v1/responses/"+responseID+"/cancel", nil, "")
+}
+
+// ListResponseInputItems handles GET /v1/responses/:id/input_items
+func (h *ProxyHandler) ListResponseInputItems(c *gin.Context) {
+	responseID := c.Param("id")
+	instanceName, ok := h.resolveAffinity(c, affinityResponse, responseID)
+	if !ok {
+		return
+	}
+	
+	h.proxyResource(c, instanceName, "GET", "/v1/responses/"+responseID+"/input_items", nil, "")
+}
+
+// responseEventObserver returns a stream observer that records affinity,
+// usage and cost from Responses streaming events, or nil for other endpoints
+func (h *ProxyHandler) responseEventObserver(c *gin.Context, endpoint, instanceName, model string, estimatedTokens int) func(map[string]interface{}) {
+	if endpoint != "/v1/responses" {
+		return nil
+	}
+	
+	return func(event map[string]interface{}) {
+		response, ok := event["response"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		
+		switch event["type"] {
+		case "response.created":
+			if responseID, _ := response["id"].(string); responseID != "" {
+				h.saveAffinity(c, affinityResponse, responseID, instanceName)
+			}
+		case "response.completed", "response.incomplete", "response.failed":
+			h.recordResponseResult(c, instanceName, estimatedTokens, response)
+			if usage, ok := response["usage"].(map[string]interface{}); ok {
+				h.recordCost(c, instanceName, model, usage)
+			}
+		}
+	}
+}
+
+// recordResponseResult stores the affinity of a response object and reconciles
+// the estimated token usage with the usage it reports
+func (h *ProxyHandler) recordResponseResult(c *gin.Context, instanceName string, estimatedTokens int, response map[string]interface{}) {
+	if responseID, _ := response["id"].(string); responseID != "" {
+		h.saveAffinity(c, affinityResponse, responseID, instanceName)
+	}
+	
+	usage, ok := response["usage"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	h.recordPromptCache(instanceName, usage)
+	
+	actualTokens := 0
+	if total, ok := usage["total_tokens"].(float64); ok {
+		actualTokens = int(total)
+	} else {
+		inputTokens, _ := usage["input_tokens"].(float64)
+		outputTokens, _ := usage["output_tokens"].(float64)
+		actualTokens = int(inputTokens + outputTokens)
+	}
+	
+	if actualTokens > 0 {
+		h.reconcileUsage(instanceName, estimatedTokens, actualTokens)
+	}
+}
+
+// reconcileUsage corrects recorded token usage once the upstream reports the
+// actual count
+func (h *ProxyHandler) reconcileUsage(instanceName string, estimatedTokens, actualTokens int) {
+	delta := actualTokens - estimatedTokens
+	if delta == 0 {
+		return
+	}
+	ctx := context.Background()
+	
+	// The rate limiter sums signed entries, so a negative delta releases capacity
+	if err := h.instanceManager.UpdateUsage(ctx, instanceName, delta); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to reconcile usage")
+	}
+	
+	state, err := h.instanceManager.GetInstanceState(ctx, instanceName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to get instance state")
+		return
+	}
+	
+	state.TotalTokensServed += int64(delta)
+	
+	if err := h.instanceManager.UpdateInstanceState(ctx, instanceName, state); err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failed to update instance state")
+	}
+}
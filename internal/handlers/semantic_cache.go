@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/storage"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// semanticQuery is the embedding of a chat request that missed the semantic
+// cache, kept to store the answer under
+type semanticQuery struct {
+	namespace string
+	vector    []float32
+}
+
+// SetSemanticCache enables the semantic cache for chat completions
+func (h *ProxyHandler) SetSemanticCache(index storage.VectorIndex, cfg config.CacheConfig) {
+	h.vectorIndex = index
+	h.cacheConfig = cfg
+}
+
+// serveSemanticCache answers a chat request with the cached answer to the most
+// similar earlier request, if it is similar enough. On a miss it returns the
+// query to store the upstream answer under, or nil if the request does not
+// use the semantic cache.
+func (h *ProxyHandler) serveSemanticCache(c *gin.Context, endpoint string, payload map[string]interface{}) (*semanticQuery, bool) {
+	if h.vectorIndex == nil || endpoint != "/v1/chat/completions" {
+		return nil, false
+	}
+	if _, semantic := cacheOptIn(c); !semantic {
+		return nil, false
+	}
+	
+	text := lastUserMessage(payload)
+	if text == "" {
+		return nil, false
+	}
+	
+	vector, err := h.embedText(c, text)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to embed request for semantic cache")
+		return nil, false
+	}
+	
+	// Answers are only reused for the same model and conversation context
+	modelName, _ := payload["model"].(string)
+	query := &semanticQuery{
+		namespace: cacheNamespace(c) + "/" + modelName + "/" + conversationContext(payload),
+		vector:    vector,
+	}
+	
+	match, err := h.vectorIndex.SearchVectors(c.Request.Context(), query.namespace, vector)
+	if err != nil {
+		logrus.WithError(err).Warn("Semantic cache lookup failed")
+	}
+	
+	hit := match != nil && match.Score >= h.cacheConfig.Semantic.GetThreshold()
+	h.instanceManager.RecordSemanticCacheLookup(hit)
+	if !hit {
+		c.Header("X-Cache", "MISS")
+		return query, false
+	}
+	
+	logrus.WithFields(logrus.Fields{
+		"entry":      match.ID,
+		"similarity": match.Score,
+	}).Debug("Semantic cache hit")
+	
+	c.Header("X-Cache-Similarity", fmt.Sprintf("%.4f", match.Score))
+	return query, writeCachedResponse(c, endpoint, payload, match.Response)
+}
+
+// storeSemanticResponse indexes an answer under the embedding of its request
+func (h *ProxyHandler) storeSemanticResponse(query *semanticQuery, data []byte) {
+	err := h.vectorIndex.AddVector(context.Background(), query.namespace, query.vector, data, h.cacheConfig.Semantic.GetTTL())
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to store semantic cache entry")
+	}
+}
+
+// embedText computes an embedding with the configured embedding model, routed
+// to one of the proxy's own instances like a client request and costed to the
+// client
+func (h *ProxyHandler) embedText(c *gin.Context, text string) ([]float32, error) {
+	ctx := c.Request.Context()
+	modelName := h.cacheConfig.Semantic.EmbeddingModel
+	
+	instanceName, err := h.instanceManager.SelectInstance(ctx, modelName, 0, "azure")
+	if err != nil {
+		return nil, fmt.Errorf("no instance for embedding model %s: %w", modelName, err)
+	}
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		return nil, fmt.Errorf("Azure service not found for instance %s", instanceName)
+	}
+	
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	transformResult, err := h.transformer.TransformOpenAIToAzure(ctx, "/v1/embeddings", map[string]interface{}{
+		"model": modelName,
+		"input": text,
+	}, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	
+	hasCapacity, err := h.instanceManager.CheckRateLimit(ctx, instanceName, transformResult.RequiredTokens)
+	if err != nil {
+		logrus.WithError(err).Warn("Rate limit check failed")
+	}
+	if !hasCapacity {
+		return nil, fmt.Errorf("rate limit exceeded on instance %s", instanceName)
+	}
+	
+	startTime := time.Now()
+	cleanPayload := h.transformer.CleanRequestMetadata(transformResult.Payload)
+	resp, err := azureService.ProxyRequest(ctx, "/v1/embeddings", cleanPayload, deploymentName)
+	if err != nil {
+		// A cancelled client request says nothing about the instance
+		if ctx.Err() == nil {
+			h.recordError(instanceName, 0)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode >= 400 {
+		h.recordError(instanceName, resp.StatusCode)
+		return nil, azureService.ParseErrorResponse(resp)
+	}
+	
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage map[string]interface{} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response contained no vector")
+	}
+	
+	h.recordUsage(instanceName, transformResult.RequiredTokens, startTime)
+	if result.Usage != nil {
+		h.recordCost(c, instanceName, modelName, result.Usage)
+	}
+	return result.Data[0].Embedding, nil
+}
+
+// contextFields are the chat request fields besides the messages that shape
+// the answer to the last user message
+var contextFields = []string{"tools", "tool_choice", "functions", "function_call", "response_format"}
+
+// conversationContext returns a hash of everything around the last user
+// message of a chat request: the system prompt, earlier turns and tools. Only
+// the last user message is compared by similarity, so the same question asked
+// in another context must not match.
+func conversationContext(payload map[string]interface{}) string {
+	messages, _ := payload["messages"].([]interface{})
+	last := lastUserMessageIndex(messages)
+	conversation := make(map[string]interface{}, len(contextFields)+1)
+	surrounding := make([]interface{}, 0, len(messages))
+	for i, message := range messages {
+		if i != last {
+			surrounding = append(surrounding, message)
+		}
+	}
+	conversation["messages"] = surrounding
+	for _, field := range contextFields {
+		if value, ok := payload[field]; ok {
+			conversation[field] = value
+		}
+	}
+	// A short prefix keeps namespaces readable in the admin listing
+	return requestHash("/v1/chat/completions", "", conversation)[:16]
+}
+
+// lastUserMessageIndex returns the index of the last user message, or -1
+func lastUserMessageIndex(messages []interface{}) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if message, ok := messages[i].(map[string]interface{}); ok && message["role"] == "user" {
+			return i
+		}
+	}
+	return -1
+}
+
+// lastUserMessage returns the text of the last user message of a chat request
+func lastUserMessage(payload map[string]interface{}) string {
+	messages, _ := payload["messages"].([]interface{})
+	if i := lastUserMessageIndex(messages); i >= 0 {
+		message := messages[i].(map[string]interface{})
+		switch content := message["content"].(type) {
+		case string:
+			return content
+		case []interface{}:
+			// Multimodal content: join the text parts
+			var parts []string
+			for _, part := range content {
+				if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+					if text, ok := partMap["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+			return strings.Join(parts, "\n")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// deploymentUtilizationHeader reports how busy a provisioned-throughput deployment is
+const deploymentUtilizationHeader = "azure-openai-deployment-utilization"
+
+// spillOver resends a request throttled by a provisioned-throughput instance
+// to another instance with capacity, returning its response and instance. The
+// throttled instance is passed over until its retry-after expires.
+func (h *ProxyHandler) spillOver(c *gin.Context, endpoint string, payload map[string]interface{}, throttled string, resp *http.Response, isStreaming bool, tokens int) (*http.Response, string, bool) {
+	ctx := c.Request.Context()
+	retryAfter := time.Duration(h.azureServices[throttled].GetRetryAfter(resp)) * time.Second
+	h.instanceManager.RecordProvisionedThrottle(throttled, retryAfter)
+	
+	modelName, _ := payload["model"].(string)
+	instanceName, err := h.instanceManager.SelectInstanceExcluding(ctx, modelName, tokens, "azure", []string{throttled})
+	if err != nil {
+		logrus.WithError(err).WithField("instance", throttled).Debug("No instance to spill over to")
+		return nil, "", false
+	}
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(instanceName)
+	if err != nil {
+		return nil, "", false
+	}
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		return nil, "", false
+	}
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	transformResult, err := h.transformer.TransformOpenAIToAzure(ctx, endpoint, payload, deploymentName)
+	if err != nil {
+		return nil, "", false
+	}
+	
+	cleanPayload := h.transformer.CleanRequestMetadata(transformResult.Payload)
+	var spilled *http.Response
+	if isStreaming {
+		if endpoint == "/v1/chat/completions" {
+			requestStreamUsage(azureService, cleanPayload)
+		}
+		spilled, err = azureService.StreamRequest(ctx, endpoint, cleanPayload, deploymentName)
+	} else {
+		spilled, err = azureService.ProxyRequest(ctx, endpoint, cleanPayload, deploymentName)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Spillover request failed")
+		return nil, "", false
+	}
+	
+	h.instanceManager.RecordSpillover(true)
+	h.instanceManager.RecordDeploymentUtilization(instanceName, spilled.Header.Get(deploymentUtilizationHeader))
+	logrus.WithFields(logrus.Fields{
+		"throttled": throttled,
+		"instance":  instanceName,
+	}).Info("Provisioned instance throttled, spilled request over")
+	return spilled, instanceName, true
+}
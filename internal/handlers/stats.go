@@ -63,8 +63,19 @@ func (h *StatsHandler) GetOverallStats(c *gin.Context) {
 			"total_tokens_served":   totalTokens,
 			"avg_tokens_per_request": avgTokensPerRequest,
 		},
-		"instances": stats["instances"],
-		"timestamp": time.Now().Unix(),
+		"cache":              stats["cache"],
+		"coalesced_requests": stats["coalesced_requests"],
+		"embedding_batches":  stats["embedding_batches"],
+		"admission_queue":    stats["admission_queue"],
+		"hedging":            stats["hedging"],
+		"spillover":          stats["spillover"],
+		"prompt_cache":       stats["prompt_cache"],
+		"outlier_detection":  stats["outlier_detection"],
+		"instances":          stats["instances"],
+		"timestamp":          time.Now().Unix(),
+	}
+	if costs, ok := stats["costs"]; ok {
+		response["costs"] = costs
 	}
 	
 	c.JSON(http.StatusOK, response)
@@ -139,6 +150,7 @@ func (h *StatsHandler) getSingleInstanceStats(c *gin.Context, instanceName strin
 			"current_rpm":         state.CurrentRPM,
 			"total_requests":      state.TotalRequests,
 			"successful_requests": state.SuccessfulRequests,
+			"cancelled_requests":  state.CancelledRequests,
 			"total_tokens_served": state.TotalTokensServed,
 		},
 		"errors": gin.H{
@@ -153,6 +165,10 @@ func (h *StatsHandler) getSingleInstanceStats(c *gin.Context, instanceName strin
 			"upstream_errors_400":  state.TotalUpstream400Errors,
 			"upstream_errors_500":  state.TotalUpstream500Errors,
 		},
+		"images": gin.H{
+			"total_images":    state.TotalImagesGenerated,
+			"by_size_quality": state.ImagesBySizeQuality,
+		},
 		"rate_limiting": gin.H{
 			"rate_limited_until": state.RateLimitedUntil,
 		},
@@ -186,6 +202,7 @@ func (h *StatsHandler) getAllInstanceStats(c *gin.Context, windowMinutes int) {
 			"healthy_instances": stats["healthy_instances"],
 			"total_requests":    stats["total_requests"],
 			"total_tokens":      stats["total_tokens"],
+			"total_images":      stats["total_images"],
 		},
 		"instances": stats["instances"],
 	}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	
+	"azure-openai-proxy/internal/config"
+	"azure-openai-proxy/internal/instance"
+	
+	"github.com/gin-gonic/gin"
+)
+
+// withSessionKey adds the key the consistent_hash strategy routes a request
+// by to the request's route, if the strategy is in use
+func (h *ProxyHandler) withSessionKey(c *gin.Context, payload map[string]interface{}) {
+	routing := h.instanceManager.GetRoutingConfig()
+	if !routing.UsesStrategy("consistent_hash") {
+		return
+	}
+	key := sessionKey(c, payload, routing.Sticky)
+	if key == "" {
+		return
+	}
+	
+	ctx := c.Request.Context()
+	route, _ := instance.RequestRouteFromContext(ctx)
+	route.SessionKey = key
+	c.Request = c.Request.WithContext(instance.WithRequestRoute(ctx, route))
+}
+
+// sessionKey returns the session header, the user field, or a hash of the
+// system prompt and the first messages, so requests sharing a prompt prefix
+// get the same key
+func sessionKey(c *gin.Context, payload map[string]interface{}, sticky config.StickyConfig) string {
+	if session := c.GetHeader(sticky.GetHeader()); session != "" {
+		return "session:" + session
+	}
+	if user, _ := payload["user"].(string); user != "" {
+		return "user:" + user
+	}
+	
+	var prefix []interface{}
+	if instructions, ok := payload["instructions"]; ok {
+		prefix = append(prefix, instructions)
+	}
+	messages, _ := payload["messages"].([]interface{})
+	if input, ok := payload["input"].([]interface{}); ok && messages == nil {
+		messages = input
+	} else if input, ok := payload["input"].(string); ok {
+		prefix = append(prefix, input)
+	}
+	
+	remaining := sticky.GetPrefixMessages()
+	for _, message := range messages {
+		messageMap, _ := message.(map[string]interface{})
+		role, _ := messageMap["role"].(string)
+		if role != "system" && role != "developer" {
+			if remaining == 0 {
+				break
+			}
+			remaining--
+		}
+		prefix = append(prefix, message)
+	}
+	if len(prefix) == 0 {
+		return ""
+	}
+	
+	data, err := json.Marshal(prefix)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "prefix:" + hex.EncodeToString(sum[:])
+}
+
+// recordPromptCache records the prompt tokens reported in a chat completion
+// or Responses usage object and how many of them were cached
+func (h *ProxyHandler) recordPromptCache(instanceName string, usage map[string]interface{}) {
+	promptTokens, ok := usage["prompt_tokens"].(float64)
+	details, _ := usage["prompt_tokens_details"].(map[string]interface{})
+	if !ok {
+		promptTokens, ok = usage["input_tokens"].(float64)
+		details, _ = usage["input_tokens_details"].(map[string]interface{})
+	}
+	if !ok || promptTokens <= 0 {
+		return
+	}
+	
+	cachedTokens, _ := details["cached_tokens"].(float64)
+	h.instanceManager.RecordPromptCache(instanceName, int(promptTokens), int(cachedTokens))
+}
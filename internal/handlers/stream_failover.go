@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+	
+	"azure-openai-proxy/internal/services"
+	
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// streamChatWithFailover relays a streamed chat completion. If the upstream
+// stream dies before [DONE], the request is re-issued on another instance and
+// its output spliced into the same client stream: transparently if no content
+// was sent yet, or with the partial assistant text appended when continuation
+// is enabled. It returns the relay state once the client stream ends.
+func (h *ProxyHandler) streamChatWithFailover(c *gin.Context, resp *http.Response, instanceName string, payload map[string]interface{}, originalModel string) *streamRelay {
+	failover := h.instanceManager.GetRoutingConfig().StreamFailover
+	h.writeStreamHeaders(c, resp)
+	
+	relay := &streamRelay{originalModel: originalModel, instance: instanceName, hideUsage: !streamIncludesUsage(payload)}
+	tried := []string{instanceName}
+	for {
+		err := h.relayStream(c, resp, relay)
+		resp.Body.Close()
+		if relay.clientGone {
+			h.recordCancelled(instanceName, true)
+			return relay
+		}
+		if relay.done {
+			return relay
+		}
+		if err == nil {
+			// Chat streams always end with [DONE]; a bare EOF is a dropped connection
+			err = io.ErrUnexpectedEOF
+		}
+		
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"instance":     instanceName,
+			"content_sent": relay.contentSent(),
+		}).Warn("Upstream stream failed")
+		// The request was counted as successful when the upstream accepted it
+		h.recordErrorOutcome(instanceName, streamErrorStatus(err), true)
+		
+		retryable := !relay.toolCallsSent && (relay.content.Len() == 0 || failover.Continuation)
+		if !failover.Enabled || !retryable || len(tried) > failover.GetMaxAttempts() {
+			h.writeStreamError(c, err, relay.namedEvents)
+			return relay
+		}
+		
+		nextInstance, nextResp := h.reissueStream(c, payload, tried, relay.content.String())
+		if nextResp == nil && clientCancelled(c) {
+			return relay
+		}
+		if nextResp == nil {
+			h.writeStreamError(c, err, relay.namedEvents)
+			return relay
+		}
+		
+		logrus.WithFields(logrus.Fields{
+			"from":         instanceName,
+			"to":           nextInstance,
+			"continuation": relay.content.Len() > 0,
+		}).Info("Failing over stream to another instance")
+		
+		// The abandoned attempt reports no usage; cost it from estimates
+		h.recordAttemptCost(c, relay, payload)
+		
+		instanceName = nextInstance
+		relay.instance = nextInstance
+		tried = append(tried, nextInstance)
+		resp = nextResp
+		relay.attempt++
+		relay.attemptStart = relay.content.Len()
+	}
+}
+
+// reissueStream sends a chat completion stream to an instance not yet tried.
+// When partial text was already relayed, it is appended as an assistant
+// message so the new stream continues it. It returns nil on failure.
+func (h *ProxyHandler) reissueStream(c *gin.Context, payload map[string]interface{}, tried []string, partial string) (string, *http.Response) {
+	ctx := c.Request.Context()
+	modelName, _ := payload["model"].(string)
+	
+	retryPayload := continuationPayload(payload, partial)
+	
+	instanceName, err := h.instanceManager.SelectInstanceExcluding(ctx, modelName, 0, "azure", tried)
+	if err != nil {
+		logrus.WithError(err).Warn("No instance available for stream failover")
+		return "", nil
+	}
+	
+	instanceConfig, err := h.instanceManager.GetInstanceConfig(instanceName)
+	if err != nil {
+		return "", nil
+	}
+	azureService, exists := h.azureServices[instanceName]
+	if !exists {
+		return "", nil
+	}
+	
+	deploymentName := h.transformer.GetDeploymentName(modelName, instanceConfig.ModelDeployments)
+	transformResult, err := h.transformer.TransformOpenAIToAzure(ctx, "/v1/chat/completions", retryPayload, deploymentName)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to transform failover request")
+		return "", nil
+	}
+	
+	hasCapacity, err := h.instanceManager.CheckRateLimit(ctx, instanceName, transformResult.RequiredTokens)
+	if err != nil {
+		logrus.WithError(err).Warn("Rate limit check failed")
+	}
+	if !hasCapacity {
+		return "", nil
+	}
+	
+	startTime := time.Now()
+	cleanPayload := h.transformer.CleanRequestMetadata(transformResult.Payload)
+	requestStreamUsage(azureService, cleanPayload)
+	resp, err := azureService.StreamRequest(ctx, "/v1/chat/completions", cleanPayload, deploymentName)
+	if err != nil {
+		logrus.WithError(err).WithField("instance", instanceName).Warn("Failover stream request failed")
+		return "", nil
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		h.recordError(instanceName, resp.StatusCode)
+		return "", nil
+	}
+	
+	h.recordUsage(instanceName, transformResult.RequiredTokens, startTime)
+	return instanceName, resp
+}
+
+// continuationPayload returns a copy of a chat completion request with the
+// partial text already relayed appended as an assistant message
+func continuationPayload(payload map[string]interface{}, partial string) map[string]interface{} {
+	continuation := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		continuation[k] = v
+	}
+	if partial != "" {
+		messages, _ := payload["messages"].([]interface{})
+		continued := make([]interface{}, 0, len(messages)+1)
+		continued = append(continued, messages...)
+		continued = append(continued, map[string]interface{}{
+			"role":    "assistant",
+			"content": partial,
+		})
+		continuation["messages"] = continued
+	}
+	return continuation
+}
+
+// recordAttemptCost costs the current stream of a relay from its estimated
+// prompt, including any partial text it continued, and the text it relayed
+func (h *ProxyHandler) recordAttemptCost(c *gin.Context, relay *streamRelay, payload map[string]interface{}) {
+	content := relay.content.String()
+	attemptPayload := continuationPayload(payload, content[:relay.attemptStart])
+	h.recordEstimatedCost(c, relay.instance, "/v1/chat/completions", attemptPayload, content[relay.attemptStart:])
+}
+
+// streamErrorStatus maps a stream failure to the status recorded for the instance
+func streamErrorStatus(err error) int {
+	if err == services.ErrStreamIdleTimeout || err == services.ErrStreamDeadline {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
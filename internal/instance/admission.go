@@ -0,0 +1,296 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+)
+
+// admissionPollInterval is how often queued requests are checked for capacity
+const admissionPollInterval = 100 * time.Millisecond
+
+var (
+	// ErrQueueFull is returned when a request is shed because the admission queue is full
+	ErrQueueFull = errors.New("admission queue is full")
+	// ErrQueueTimeout is returned when no capacity freed up within the maximum wait
+	ErrQueueTimeout = errors.New("timed out waiting for capacity")
+)
+
+// admissionWaiter is a request waiting for an instance with capacity
+type admissionWaiter struct {
+	model        string
+	tokens       int
+	providerType string
+	priority     int
+	route        RequestRoute // for routing rules, as the request's context is not used to admit it
+	enqueued     time.Time
+	ready        chan string // receives the selected instance
+	done         bool        // admitted or given up, guarded by the queue mutex
+}
+
+// admissionQueue holds requests that found no capacity, admitting them in
+// priority order, then arrival order, as rate limits free up
+type admissionQueue struct {
+	manager *Manager
+	config  config.AdmissionConfig
+	
+	waiters     []*admissionWaiter // sorted by priority, then arrival
+	dispatching bool
+	mutex       sync.Mutex
+	
+	// Statistics
+	admitted    int64
+	shed        int64
+	timedOut    int64
+	totalWaitMs int64
+	maxWaitMs   int64
+}
+
+// newAdmissionQueue creates a new admission queue
+func newAdmissionQueue(manager *Manager, cfg config.AdmissionConfig) *admissionQueue {
+	return &admissionQueue{
+		manager: manager,
+		config:  cfg,
+	}
+}
+
+// SetAdmissionConfig enables queueing requests for capacity
+func (m *Manager) SetAdmissionConfig(cfg config.AdmissionConfig) {
+	m.admission = newAdmissionQueue(m, cfg)
+}
+
+// SelectInstanceQueued selects an instance with capacity for the tokens. If
+// there is none it waits in the admission queue, ahead of lower-priority
+// requests, until capacity frees up or the maximum wait expires. Without an
+// admission queue it behaves like SelectInstance.
+func (m *Manager) SelectInstanceQueued(ctx context.Context, model string, tokens int, providerType string, priority int) (string, error) {
+	if m.admission == nil {
+		return m.SelectInstance(ctx, model, tokens, providerType)
+	}
+	return m.admission.admit(ctx, model, tokens, providerType, priority)
+}
+
+// QueuesRequests reports whether requests wait in an admission queue for capacity
+func (m *Manager) QueuesRequests() bool {
+	return m.admission != nil
+}
+
+// AdmissionStats returns the admission queue depth, outcomes and wait times
+func (m *Manager) AdmissionStats() map[string]interface{} {
+	if m.admission == nil {
+		return nil
+	}
+	return m.admission.stats()
+}
+
+// admit selects an instance directly unless the request has to queue behind
+// others for the same model or no instance has capacity
+func (q *admissionQueue) admit(ctx context.Context, model string, tokens int, providerType string, priority int) (string, error) {
+	if !q.hasPrecedingWaiter(model, priority) {
+		instanceName, err := q.manager.SelectInstance(ctx, model, tokens, providerType)
+		if !errors.Is(err, ErrNoCapacity) {
+			return instanceName, err
+		}
+	}
+	
+	route, _ := RequestRouteFromContext(ctx)
+	waiter, err := q.enqueue(model, tokens, providerType, priority, route)
+	if err != nil {
+		return "", err
+	}
+	
+	timer := time.NewTimer(q.config.GetMaxWait())
+	defer timer.Stop()
+	
+	select {
+	case instanceName := <-waiter.ready:
+		return instanceName, nil
+	case <-timer.C:
+		if q.abandon(waiter, true) {
+			return "", ErrQueueTimeout
+		}
+	case <-ctx.Done():
+		if q.abandon(waiter, false) {
+			return "", ctx.Err()
+		}
+	}
+	// Admitted while giving up
+	return <-waiter.ready, nil
+}
+
+// hasPrecedingWaiter reports whether a request of at least the same priority
+// is already waiting for the model, so a new request must not overtake it
+func (q *admissionQueue) hasPrecedingWaiter(model string, priority int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	
+	for _, waiter := range q.waiters {
+		if waiter.priority > priority {
+			break
+		}
+		if waiter.model == model {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue adds a waiter in priority order, shedding it if the queue is full
+func (q *admissionQueue) enqueue(model string, tokens int, providerType string, priority int, route RequestRoute) (*admissionWaiter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	
+	if len(q.waiters) >= q.config.GetMaxQueueSize() {
+		q.shed++
+		return nil, ErrQueueFull
+	}
+	
+	waiter := &admissionWaiter{
+		model:        model,
+		tokens:       tokens,
+		providerType: providerType,
+		priority:     priority,
+		route:        route,
+		enqueued:     time.Now(),
+		ready:        make(chan string, 1),
+	}
+	position := sort.Search(len(q.waiters), func(i int) bool {
+		return q.waiters[i].priority > priority
+	})
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[position+1:], q.waiters[position:])
+	q.waiters[position] = waiter
+	
+	if !q.dispatching {
+		q.dispatching = true
+		go q.dispatch()
+	}
+	return waiter, nil
+}
+
+// abandon removes a waiter that gave up, returning false if it was admitted
+// in the meantime
+func (q *admissionQueue) abandon(waiter *admissionWaiter, timedOut bool) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	
+	if waiter.done {
+		return false
+	}
+	waiter.done = true
+	q.remove(waiter)
+	if timedOut {
+		q.timedOut++
+	}
+	return true
+}
+
+// remove deletes a waiter from the queue. The caller must hold the mutex.
+func (q *admissionQueue) remove(waiter *admissionWaiter) {
+	for i, queued := range q.waiters {
+		if queued == waiter {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch periodically admits queued requests until the queue is empty
+func (q *admissionQueue) dispatch() {
+	ticker := time.NewTicker(admissionPollInterval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		q.mutex.Lock()
+		if len(q.waiters) == 0 {
+			q.dispatching = false
+			q.mutex.Unlock()
+			return
+		}
+		waiters := append([]*admissionWaiter(nil), q.waiters...)
+		q.mutex.Unlock()
+		
+		q.admitWaiting(waiters)
+	}
+}
+
+// admitWaiting admits waiters in queue order. Once a waiter for a model
+// cannot be placed, later waiters for that model and routing rule are held
+// back so they do not take capacity ahead of it. Tokens admitted in this pass
+// are counted against the capacity left for the next waiter, as they are not
+// yet recorded as usage.
+func (q *admissionQueue) admitWaiting(waiters []*admissionWaiter) {
+	blocked := make(map[string]bool)
+	admittedTokens := make(map[string]int)
+	
+	for _, waiter := range waiters {
+		ctx := WithRequestRoute(context.Background(), waiter.route)
+		key := waiter.model
+		if rule := q.manager.matchRoutingRule(ctx, waiter.model); rule != nil {
+			key += "/" + rule.Name
+		}
+		if blocked[key] {
+			continue
+		}
+		
+		instanceName, err := q.manager.SelectInstance(ctx, waiter.model, waiter.tokens+admittedTokens[key], waiter.providerType)
+		if err != nil {
+			blocked[key] = true
+			continue
+		}
+		
+		q.mutex.Lock()
+		if waiter.done {
+			q.mutex.Unlock()
+			continue
+		}
+		waiter.done = true
+		q.remove(waiter)
+		waitMs := time.Since(waiter.enqueued).Milliseconds()
+		q.admitted++
+		q.totalWaitMs += waitMs
+		if waitMs > q.maxWaitMs {
+			q.maxWaitMs = waitMs
+		}
+		q.mutex.Unlock()
+		
+		admittedTokens[key] += waiter.tokens
+		waiter.ready <- instanceName
+	}
+}
+
+// stats returns the queue depth per priority, outcomes and wait times
+func (q *admissionQueue) stats() map[string]interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	
+	depthByPriority := make(map[string]int)
+	oldestWaitMs := int64(0)
+	for _, waiter := range q.waiters {
+		depthByPriority[config.PriorityName(waiter.priority)]++
+		if waitMs := time.Since(waiter.enqueued).Milliseconds(); waitMs > oldestWaitMs {
+			oldestWaitMs = waitMs
+		}
+	}
+	
+	avgWaitMs := 0.0
+	if q.admitted > 0 {
+		avgWaitMs = float64(q.totalWaitMs) / float64(q.admitted)
+	}
+	
+	return map[string]interface{}{
+		"depth":             len(q.waiters),
+		"depth_by_priority": depthByPriority,
+		"max_queue_size":    q.config.GetMaxQueueSize(),
+		"oldest_wait_ms":    oldestWaitMs,
+		"admitted":          q.admitted,
+		"shed":              q.shed,
+		"timed_out":         q.timedOut,
+		"avg_wait_ms":       avgWaitMs,
+		"max_wait_ms":       q.maxWaitMs,
+	}
+}
@@ -0,0 +1,182 @@
+package instance
+
+import (
+	"context"
+	"sort"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	
+	"github.com/sirupsen/logrus"
+)
+
+// costMonthFormat is the period costs are accumulated by
+const costMonthFormat = "2006-01"
+
+// RecordCost prices the tokens a request used on an instance and adds them
+// to the month's costs for the instance, model and client in the config
+// store, so totals survive restarts and are shared by proxies using the same
+// store. It returns the cost, which is 0 if the instance has no price for the
+// model.
+func (m *Manager) RecordCost(ctx context.Context, instanceName, model, client string, inputTokens, cachedTokens, outputTokens int) (float64, error) {
+	usage := &config.CostUsage{
+		Month:        time.Now().UTC().Format(costMonthFormat),
+		Instance:     instanceName,
+		Model:        model,
+		Client:       client,
+		Requests:     1,
+		InputTokens:  int64(inputTokens),
+		CachedTokens: int64(cachedTokens),
+		OutputTokens: int64(outputTokens),
+	}
+	if cfg, err := m.GetInstanceConfig(instanceName); err == nil {
+		if price, ok := cfg.GetPrice(model); ok {
+			usage.Cost = price.Cost(inputTokens, cachedTokens, outputTokens)
+		} else {
+			usage.UnpricedRequests = 1
+		}
+	}
+	
+	if err := m.configStore.AddCostUsage(ctx, usage); err != nil {
+		return usage.Cost, err
+	}
+	return usage.Cost, nil
+}
+
+// CostStats returns the costs of a month ("2006-01"), or of all months if
+// month is empty, in total and broken down by instance, model and client,
+// plus the full breakdown by all three
+func (m *Manager) CostStats(ctx context.Context, month string) (map[string]interface{}, error) {
+	records, err := m.configStore.ListCostUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	total := &config.CostUsage{}
+	byInstance := make(map[string]*config.CostUsage)
+	byModel := make(map[string]*config.CostUsage)
+	byClient := make(map[string]*config.CostUsage)
+	months := make(map[string]bool)
+	breakdown := make([]map[string]interface{}, 0, len(records))
+	for i := range records {
+		usage := &records[i]
+		months[usage.Month] = true
+		if month != "" && usage.Month != month {
+			continue
+		}
+		addCostUsage(total, usage)
+		addGroupCostUsage(byInstance, usage.Instance, usage)
+		addGroupCostUsage(byModel, usage.Model, usage)
+		addGroupCostUsage(byClient, usage.Client, usage)
+		
+		entry := costUsageStats(usage)
+		entry["month"] = usage.Month
+		entry["instance"] = usage.Instance
+		entry["model"] = usage.Model
+		entry["client"] = usage.Client
+		breakdown = append(breakdown, entry)
+	}
+	
+	monthList := make([]string, 0, len(months))
+	for name := range months {
+		monthList = append(monthList, name)
+	}
+	sort.Strings(monthList)
+	
+	stats := costUsageStats(total)
+	stats["month"] = month
+	stats["months"] = monthList
+	stats["by_instance"] = groupCostUsageStats(byInstance)
+	stats["by_model"] = groupCostUsageStats(byModel)
+	stats["by_client"] = groupCostUsageStats(byClient)
+	stats["breakdown"] = breakdown
+	return stats, nil
+}
+
+// monthCostTotals returns the current month's costs without the full
+// breakdown, or nil if they cannot be read
+func (m *Manager) monthCostTotals(ctx context.Context) map[string]interface{} {
+	costs, err := m.CostStats(ctx, time.Now().UTC().Format(costMonthFormat))
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get costs")
+		return nil
+	}
+	delete(costs, "months")
+	delete(costs, "breakdown")
+	return costs
+}
+
+// addCostUsage accumulates another usage
+func addCostUsage(total, usage *config.CostUsage) {
+	total.Requests += usage.Requests
+	total.InputTokens += usage.InputTokens
+	total.CachedTokens += usage.CachedTokens
+	total.OutputTokens += usage.OutputTokens
+	total.Cost += usage.Cost
+	total.UnpricedRequests += usage.UnpricedRequests
+}
+
+// addGroupCostUsage adds usage to the group's total
+func addGroupCostUsage(groups map[string]*config.CostUsage, name string, usage *config.CostUsage) {
+	group, exists := groups[name]
+	if !exists {
+		group = &config.CostUsage{}
+		groups[name] = group
+	}
+	addCostUsage(group, usage)
+}
+
+// costUsageStats returns the usage as reported in the cost breakdowns
+func costUsageStats(usage *config.CostUsage) map[string]interface{} {
+	return map[string]interface{}{
+		"requests":          usage.Requests,
+		"input_tokens":      usage.InputTokens,
+		"cached_tokens":     usage.CachedTokens,
+		"output_tokens":     usage.OutputTokens,
+		"cost":              usage.Cost,
+		"unpriced_requests": usage.UnpricedRequests,
+	}
+}
+
+// groupCostUsageStats returns the stats of each group
+func groupCostUsageStats(groups map[string]*config.CostUsage) map[string]interface{} {
+	stats := make(map[string]interface{}, len(groups))
+	for name, usage := range groups {
+		stats[name] = costUsageStats(usage)
+	}
+	return stats
+}
+
+// selectByCheapest selects the instance with the lowest price for the model,
+// comparing the input and output prices per 1K tokens combined. Instances
+// without a price for the model come last; ties go by priority.
+func (is *InstanceSelector) selectByCheapest(model string, instances []instanceWithState) string {
+	type instancePrice struct {
+		instance instanceWithState
+		price    float64
+		priced   bool
+	}
+	
+	prices := make([]instancePrice, len(instances))
+	for i, instance := range instances {
+		price, ok := instance.Config.GetPrice(model)
+		prices[i] = instancePrice{
+			instance: instance,
+			price:    price.InputPer1K + price.OutputPer1K,
+			priced:   ok,
+		}
+	}
+	
+	sort.SliceStable(prices, func(i, j int) bool {
+		a, b := prices[i], prices[j]
+		if a.priced != b.priced {
+			return a.priced
+		}
+		if a.price != b.price {
+			return a.price < b.price
+		}
+		return a.instance.Config.Priority < b.instance.Config.Priority
+	})
+	
+	return prices[0].instance.Config.Name
+}
@@ -0,0 +1,245 @@
+package instance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons an instance is drained
+const (
+	DrainReasonAdmin       = "admin"       // drained through the admin API
+	DrainReasonMaintenance = "maintenance" // inside a maintenance window
+)
+
+// drain is a period during which an instance gets no new requests
+type drain struct {
+	reason   string
+	since    time.Time
+	until    time.Time // end of the maintenance window, zero for admin drains
+	reported bool      // drain completion was logged
+}
+
+// maintenanceCheck caches whether an instance is in a maintenance window for a minute
+type maintenanceCheck struct {
+	minute time.Time
+	window *drain // nil outside maintenance windows
+}
+
+// drainTracker keeps drained instances and the requests still in flight on them
+type drainTracker struct {
+	drains      map[string]*drain // admin drains
+	maintenance map[string]*maintenanceCheck
+	schedules   map[string]*config.Schedule // parsed maintenance schedules by expression
+	inFlight    map[string]int              // upstream requests in flight (this process)
+	mutex       sync.Mutex
+}
+
+// newDrainTracker creates a new drain tracker
+func newDrainTracker() *drainTracker {
+	return &drainTracker{
+		drains:      make(map[string]*drain),
+		maintenance: make(map[string]*maintenanceCheck),
+		schedules:   make(map[string]*config.Schedule),
+		inFlight:    make(map[string]int),
+	}
+}
+
+// Drain stops new requests from being routed to an instance while those in
+// flight finish
+func (m *Manager) Drain(instanceName string) error {
+	if _, err := m.GetInstanceConfig(instanceName); err != nil {
+		return err
+	}
+	
+	m.drains.mutex.Lock()
+	if _, exists := m.drains.drains[instanceName]; !exists {
+		m.drains.drains[instanceName] = &drain{reason: DrainReasonAdmin, since: time.Now()}
+	}
+	m.drains.mutex.Unlock()
+	
+	logrus.WithField("instance", instanceName).Info("Draining instance")
+	m.reportDrained(instanceName)
+	return nil
+}
+
+// Resume puts an instance drained through Drain back into rotation. It stays
+// out while a maintenance window is open.
+func (m *Manager) Resume(instanceName string) error {
+	if _, err := m.GetInstanceConfig(instanceName); err != nil {
+		return err
+	}
+	
+	m.drains.mutex.Lock()
+	_, drained := m.drains.drains[instanceName]
+	delete(m.drains.drains, instanceName)
+	m.drains.mutex.Unlock()
+	
+	if drained {
+		logrus.WithField("instance", instanceName).Info("Instance resumed")
+	}
+	return nil
+}
+
+// BeginRequest counts an upstream request in flight on an instance until the
+// returned function is called. It implements services.RequestTracker.
+func (m *Manager) BeginRequest(instanceName string) func() {
+	m.drains.mutex.Lock()
+	m.drains.inFlight[instanceName]++
+	m.drains.mutex.Unlock()
+	
+	return func() {
+		m.drains.mutex.Lock()
+		if m.drains.inFlight[instanceName] > 0 {
+			m.drains.inFlight[instanceName]--
+		}
+		m.drains.mutex.Unlock()
+		
+		m.reportDrained(instanceName)
+	}
+}
+
+// InFlightRequests returns the number of upstream requests in flight on an instance
+func (m *Manager) InFlightRequests(instanceName string) int {
+	m.drains.mutex.Lock()
+	defer m.drains.mutex.Unlock()
+	
+	return m.drains.inFlight[instanceName]
+}
+
+// isDraining reports whether an instance is drained by an admin or a
+// maintenance window
+func (m *Manager) isDraining(instanceName string) bool {
+	return m.currentDrain(instanceName) != nil
+}
+
+// currentDrain returns the instance's admin drain or open maintenance window,
+// or nil if it is in rotation
+func (m *Manager) currentDrain(instanceName string) *drain {
+	m.drains.mutex.Lock()
+	if d, exists := m.drains.drains[instanceName]; exists {
+		m.drains.mutex.Unlock()
+		return d
+	}
+	check, exists := m.drains.maintenance[instanceName]
+	minute := time.Now().Truncate(time.Minute)
+	if exists && check.minute.Equal(minute) {
+		m.drains.mutex.Unlock()
+		return check.window
+	}
+	m.drains.mutex.Unlock()
+	
+	window := m.openMaintenanceWindow(instanceName)
+	
+	m.drains.mutex.Lock()
+	defer m.drains.mutex.Unlock()
+	
+	// Keep the window already known, so its completion is logged once
+	if exists && check.window != nil && window != nil && check.window.until.Equal(window.until) {
+		window = check.window
+	}
+	if exists && (check.window == nil) != (window == nil) {
+		if window != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance": instanceName,
+				"until":    window.until,
+			}).Info("Maintenance window started, draining instance")
+		} else {
+			logrus.WithField("instance", instanceName).Info("Maintenance window ended, instance back in rotation")
+		}
+	}
+	m.drains.maintenance[instanceName] = &maintenanceCheck{minute: minute, window: window}
+	return window
+}
+
+// openMaintenanceWindow returns the instance's maintenance window open now, if any
+func (m *Manager) openMaintenanceWindow(instanceName string) *drain {
+	cfg, err := m.GetInstanceConfig(instanceName)
+	if err != nil || len(cfg.MaintenanceWindows) == 0 {
+		return nil
+	}
+	
+	now := time.Now()
+	for _, window := range cfg.MaintenanceWindows {
+		schedule, err := m.maintenanceSchedule(window.Schedule)
+		if err != nil {
+			logrus.WithError(err).WithField("instance", instanceName).Warn("Invalid maintenance window")
+			continue
+		}
+		if active, until := window.ActiveAt(schedule, now); active {
+			return &drain{
+				reason: DrainReasonMaintenance,
+				since:  until.Add(-window.GetDuration()),
+				until:  until,
+			}
+		}
+	}
+	return nil
+}
+
+// maintenanceSchedule returns the parsed cron schedule of a maintenance window
+func (m *Manager) maintenanceSchedule(expr string) (*config.Schedule, error) {
+	m.drains.mutex.Lock()
+	defer m.drains.mutex.Unlock()
+	
+	if schedule, exists := m.drains.schedules[expr]; exists {
+		return schedule, nil
+	}
+	schedule, err := config.ParseSchedule(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse maintenance schedule: %w", err)
+	}
+	m.drains.schedules[expr] = schedule
+	return schedule, nil
+}
+
+// reportDrained logs once when a draining instance has no requests or
+// realtime sessions left
+func (m *Manager) reportDrained(instanceName string) {
+	d := m.currentDrain(instanceName)
+	if d == nil || m.ActiveRealtimeSessions(instanceName) > 0 {
+		return
+	}
+	
+	m.drains.mutex.Lock()
+	complete := m.drains.inFlight[instanceName] == 0 && !d.reported
+	if complete {
+		d.reported = true
+	}
+	m.drains.mutex.Unlock()
+	
+	if complete {
+		logrus.WithFields(logrus.Fields{
+			"instance": instanceName,
+			"reason":   d.reason,
+		}).Info("Instance drained")
+	}
+}
+
+// DrainStatus returns whether an instance is draining, why, and whether the
+// drain is complete, i.e. no requests or realtime sessions are left on it
+func (m *Manager) DrainStatus(instanceName string) map[string]interface{} {
+	d := m.currentDrain(instanceName)
+	if d == nil {
+		return map[string]interface{}{"draining": false}
+	}
+	
+	inFlight := m.InFlightRequests(instanceName)
+	sessions := m.ActiveRealtimeSessions(instanceName)
+	status := map[string]interface{}{
+		"draining":          true,
+		"reason":            d.reason,
+		"since":             d.since,
+		"in_flight":         inFlight,
+		"realtime_sessions": sessions,
+		"complete":          inFlight == 0 && sessions == 0,
+	}
+	if !d.until.IsZero() {
+		status["until"] = d.until
+	}
+	return status
+}
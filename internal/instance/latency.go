@@ -0,0 +1,119 @@
+package instance
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySampleSize is the number of recent latencies kept per instance
+const latencySampleSize = 256
+
+// minLatencySamples is the number of samples needed before percentiles are reported
+const minLatencySamples = 10
+
+// latencyWindow is a ring buffer of an instance's recent request latencies
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// latencyTracker keeps recent latencies per instance to compute percentiles
+type latencyTracker struct {
+	windows map[string]*latencyWindow
+	mutex   sync.Mutex
+}
+
+// newLatencyTracker creates a new latency tracker
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		windows: make(map[string]*latencyWindow),
+	}
+}
+
+// record adds a latency sample, replacing the oldest once the window is full
+func (lt *latencyTracker) record(instanceName string, latency time.Duration) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	
+	window, exists := lt.windows[instanceName]
+	if !exists {
+		window = &latencyWindow{samples: make([]time.Duration, 0, latencySampleSize)}
+		lt.windows[instanceName] = window
+	}
+	if len(window.samples) < latencySampleSize {
+		window.samples = append(window.samples, latency)
+		return
+	}
+	window.samples[window.next] = latency
+	window.next = (window.next + 1) % latencySampleSize
+}
+
+// reset forgets an instance's latencies, so an ejected instance is judged
+// afresh when it returns
+func (lt *latencyTracker) reset(instanceName string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	
+	delete(lt.windows, instanceName)
+}
+
+// percentiles returns the given percentiles (0-100) of an instance's recent
+// latencies, or false if too few samples were recorded
+func (lt *latencyTracker) percentiles(instanceName string, percentiles ...float64) ([]time.Duration, bool) {
+	lt.mutex.Lock()
+	window, exists := lt.windows[instanceName]
+	var samples []time.Duration
+	if exists {
+		samples = append(samples, window.samples...)
+	}
+	lt.mutex.Unlock()
+	
+	if len(samples) < minLatencySamples {
+		return nil, false
+	}
+	
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	results := make([]time.Duration, len(percentiles))
+	for i, percentile := range percentiles {
+		// Nearest-rank percentile
+		rank := int(percentile/100*float64(len(samples)) + 0.5)
+		if rank < 1 {
+			rank = 1
+		}
+		if rank > len(samples) {
+			rank = len(samples)
+		}
+		results[i] = samples[rank-1]
+	}
+	return results, true
+}
+
+// RecordLatency records the latency of a successful request to an instance
+func (m *Manager) RecordLatency(instanceName string, latency time.Duration) {
+	m.latencies.record(instanceName, latency)
+}
+
+// LatencyPercentile returns a percentile (0-100) of an instance's recent
+// latencies, or false if too few requests were recorded
+func (m *Manager) LatencyPercentile(instanceName string, percentile float64) (time.Duration, bool) {
+	results, ok := m.latencies.percentiles(instanceName, percentile)
+	if !ok {
+		return 0, false
+	}
+	return results[0], true
+}
+
+// latencyStats returns the p50, p95 and p99 latencies of an instance in
+// milliseconds, or nil if too few requests were recorded
+func (m *Manager) latencyStats(instanceName string) map[string]interface{} {
+	results, ok := m.latencies.percentiles(instanceName, 50, 95, 99)
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"p50_ms": results[0].Milliseconds(),
+		"p95_ms": results[1].Milliseconds(),
+		"p99_ms": results[2].Milliseconds(),
+	}
+}
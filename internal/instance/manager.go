@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 	
 	"azure-openai-proxy/internal/config"
@@ -11,29 +12,69 @@ import (
 	"azure-openai-proxy/internal/utils"
 )
 
+// Capacity units limited per minute in addition to tokens
+const (
+	UnitImages       = "images"
+	UnitAudioSeconds = "audio_seconds"
+	UnitCharacters   = "characters"
+)
+
 // Manager manages API instances and their states
 type Manager struct {
-	configs         []config.InstanceConfig
-	routingStrategy string
-	stateStore      storage.StateStore
-	configStore     storage.ConfigStore
-	rateLimiters    map[string]*utils.RateLimiter
-	mutex           sync.RWMutex
-	selector        *InstanceSelector
-	redisURL        string
-	redisPassword   string
+	configs          []config.InstanceConfig
+	routingStrategy  string
+	routing          config.RoutingConfig
+	models           *modelRegistry // aliases and fallback chains, nil when none are configured
+	stateStore       storage.StateStore
+	configStore      storage.ConfigStore
+	rateLimiters     map[string]*utils.RateLimiter
+	unitRateLimiters map[string]map[string]*utils.RateLimiter // instance -> unit -> limiter
+	realtimeSessions map[string]int                           // active WebSocket sessions per instance (this process)
+	sessionMutex     sync.Mutex
+	cacheHits        atomic.Int64 // response cache lookups served from the cache
+	cacheMisses      atomic.Int64 // cacheable requests that went upstream
+	semanticHits     atomic.Int64 // chat requests answered by a similar cached one
+	semanticMisses   atomic.Int64
+	coalesced        atomic.Int64 // requests answered by an identical in-flight request
+	embeddingBatches atomic.Int64 // upstream calls carrying batched embeddings requests
+	embeddingBatched atomic.Int64 // embeddings requests sent as part of a batch
+	hedgesSent       atomic.Int64 // hedge requests fired at a second instance
+	hedgeWins        atomic.Int64 // hedged requests answered by the hedge
+	hedgesSkipped    atomic.Int64 // hedges not sent because the client's budget was spent
+	latencies        *latencyTracker
+	spillover        *spilloverTracker // load of provisioned-throughput instances
+	spillSaturated   atomic.Int64      // requests routed past saturated provisioned instances
+	spillThrottled   atomic.Int64      // requests resent after a provisioned instance returned 429
+	promptCache      *promptCacheTracker
+	recovery         *recoveryTracker // slow start and outlier ejection state
+	drains           *drainTracker    // drained instances and requests in flight
+	mutex            sync.RWMutex
+	selector         *InstanceSelector
+	redisURL         string
+	redisPassword    string
+	
+	// Queues requests for capacity instead of rejecting them, nil when disabled
+	admission *admissionQueue
 }
 
 // NewManager creates a new instance manager
 func NewManager(instances []config.InstanceConfig, strategy string, stateStore storage.StateStore, configStore storage.ConfigStore) (*Manager, error) {
 	manager := &Manager{
-		configs:         instances,
-		routingStrategy: strategy,
-		stateStore:      stateStore,
-		configStore:     configStore,
-		rateLimiters:    make(map[string]*utils.RateLimiter),
-		redisURL:        "redis://localhost:6379", // TODO: Get from config
-		redisPassword:   "",                       // TODO: Get from config
+		configs:          instances,
+		routingStrategy:  strategy,
+		routing:          config.RoutingConfig{Strategy: strategy},
+		stateStore:       stateStore,
+		configStore:      configStore,
+		rateLimiters:     make(map[string]*utils.RateLimiter),
+		unitRateLimiters: make(map[string]map[string]*utils.RateLimiter),
+		latencies:        newLatencyTracker(),
+		spillover:        newSpilloverTracker(),
+		promptCache:      newPromptCacheTracker(),
+		recovery:         newRecoveryTracker(),
+		drains:           newDrainTracker(),
+		realtimeSessions: make(map[string]int),
+		redisURL:         "redis://localhost:6379", // TODO: Get from config
+		redisPassword:    "",                       // TODO: Get from config
 	}
 	
 	// Initialize rate limiters for enabled instances
@@ -50,6 +91,10 @@ func NewManager(instances []config.InstanceConfig, strategy string, stateStore s
 				return nil, fmt.Errorf("failed to create rate limiter for instance %s: %w", instance.Name, err)
 			}
 			manager.rateLimiters[instance.Name] = rateLimiter
+			
+			if err := manager.initUnitRateLimiters(instance); err != nil {
+				return nil, err
+			}
 		}
 	}
 	
@@ -59,6 +104,37 @@ func NewManager(instances []config.InstanceConfig, strategy string, stateStore s
 	return manager, nil
 }
 
+// initUnitRateLimiters creates per-minute limiters for non-token capacity units
+func (m *Manager) initUnitRateLimiters(instance config.InstanceConfig) error {
+	unitLimits := map[string]int{
+		UnitImages:       instance.MaxImagesPerMinute,
+		UnitAudioSeconds: instance.MaxAudioSecondsPerMinute,
+		UnitCharacters:   instance.MaxCharactersPerMinute,
+	}
+	
+	for unit, limit := range unitLimits {
+		if limit <= 0 {
+			continue
+		}
+		rateLimiter, err := utils.NewRateLimiter(
+			fmt.Sprintf("%s:%s", instance.Name, unit),
+			limit,
+			0,
+			m.redisURL,
+			m.redisPassword,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create %s rate limiter for instance %s: %w", unit, instance.Name, err)
+		}
+		if m.unitRateLimiters[instance.Name] == nil {
+			m.unitRateLimiters[instance.Name] = make(map[string]*utils.RateLimiter)
+		}
+		m.unitRateLimiters[instance.Name][unit] = rateLimiter
+	}
+	
+	return nil
+}
+
 // StartHealthMonitoring starts the health monitoring goroutine
 func (m *Manager) StartHealthMonitoring() {
 	go func() {
@@ -172,6 +248,11 @@ func (m *Manager) GetInstanceState(ctx context.Context, instanceName string) (*c
 	return m.stateStore.Get(ctx, instanceName)
 }
 
+// GetAllInstanceStates returns the current state of every instance in the state store
+func (m *Manager) GetAllInstanceStates(ctx context.Context) (map[string]*config.InstanceState, error) {
+	return m.stateStore.GetAll(ctx)
+}
+
 // UpdateInstanceState updates the state of an instance
 func (m *Manager) UpdateInstanceState(ctx context.Context, instanceName string, state *config.InstanceState) error {
 	return m.stateStore.Set(ctx, instanceName, state)
@@ -192,6 +273,25 @@ func (m *Manager) CheckRateLimit(ctx context.Context, instanceName string, token
 	return hasCapacity, err
 }
 
+// Utilization returns the share of an instance's TPM limit used in the current
+// rate limit window as a percentage, or false if the instance is not rate limited
+func (m *Manager) Utilization(ctx context.Context, instanceName string) (float64, bool) {
+	m.mutex.RLock()
+	rateLimiter, exists := m.rateLimiters[instanceName]
+	m.mutex.RUnlock()
+	
+	if !exists {
+		return 0, false
+	}
+	
+	tokensPerMinute, _ := rateLimiter.GetLimits()
+	usage, err := rateLimiter.GetCurrentUsage(ctx)
+	if err != nil || tokensPerMinute <= 0 {
+		return 0, false
+	}
+	return float64(usage) / float64(tokensPerMinute) * 100, true
+}
+
 // UpdateUsage records token usage for an instance
 func (m *Manager) UpdateUsage(ctx context.Context, instanceName string, tokens int) error {
 	m.mutex.RLock()
@@ -206,11 +306,225 @@ func (m *Manager) UpdateUsage(ctx context.Context, instanceName string, tokens i
 	return rateLimiter.UpdateUsage(ctx, tokens)
 }
 
+// CheckUnitRateLimit checks if an instance has capacity for the given amount of a unit
+func (m *Manager) CheckUnitRateLimit(ctx context.Context, instanceName, unit string, amount int) (bool, error) {
+	m.mutex.RLock()
+	rateLimiter, exists := m.unitRateLimiters[instanceName][unit]
+	m.mutex.RUnlock()
+	
+	if !exists {
+		// No limit configured for this unit
+		return true, nil
+	}
+	
+	hasCapacity, _, err := rateLimiter.CheckCapacity(ctx, amount)
+	return hasCapacity, err
+}
+
+// UpdateUnitUsage records usage of a non-token capacity unit for an instance
+func (m *Manager) UpdateUnitUsage(ctx context.Context, instanceName, unit string, amount int) error {
+	m.mutex.RLock()
+	rateLimiter, exists := m.unitRateLimiters[instanceName][unit]
+	m.mutex.RUnlock()
+	
+	if !exists {
+		return nil
+	}
+	
+	return rateLimiter.UpdateUsage(ctx, amount)
+}
+
 // SelectInstance selects the best instance for a request
 func (m *Manager) SelectInstance(ctx context.Context, model string, tokens int, providerType string) (string, error) {
 	return m.selector.SelectInstanceForRequest(ctx, model, tokens, providerType)
 }
 
+// SelectInstanceExcluding selects an instance like SelectInstance, skipping the
+// named instances (e.g. ones that already failed for this request)
+func (m *Manager) SelectInstanceExcluding(ctx context.Context, model string, tokens int, providerType string, exclude []string) (string, error) {
+	return m.selector.SelectInstanceExcluding(ctx, model, tokens, providerType, exclude)
+}
+
+// SetRoutingConfig sets the routing options beyond the strategy
+func (m *Manager) SetRoutingConfig(routing config.RoutingConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.routing = routing
+}
+
+// GetRoutingConfig returns the routing options
+func (m *Manager) GetRoutingConfig() config.RoutingConfig {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	return m.routing
+}
+
+// SelectRealtimeInstance selects an instance for a realtime session, skipping
+// instances at their concurrent session limit
+func (m *Manager) SelectRealtimeInstance(ctx context.Context, model string) (string, error) {
+	return m.selector.SelectRealtimeInstance(ctx, model)
+}
+
+// AcquireRealtimeSession reserves a realtime session slot on an instance,
+// returning false if the instance is at its limit
+func (m *Manager) AcquireRealtimeSession(instanceName string) bool {
+	cfg, err := m.GetInstanceConfig(instanceName)
+	if err != nil {
+		return false
+	}
+	
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	
+	if cfg.MaxRealtimeSessions > 0 && m.realtimeSessions[instanceName] >= cfg.MaxRealtimeSessions {
+		return false
+	}
+	m.realtimeSessions[instanceName]++
+	return true
+}
+
+// ReleaseRealtimeSession frees a slot reserved by AcquireRealtimeSession
+func (m *Manager) ReleaseRealtimeSession(instanceName string) {
+	m.sessionMutex.Lock()
+	if m.realtimeSessions[instanceName] > 0 {
+		m.realtimeSessions[instanceName]--
+	}
+	m.sessionMutex.Unlock()
+	
+	m.reportDrained(instanceName)
+}
+
+// ActiveRealtimeSessions returns the number of open realtime sessions on an instance
+func (m *Manager) ActiveRealtimeSessions(instanceName string) int {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	
+	return m.realtimeSessions[instanceName]
+}
+
+// RecordCacheLookup counts a response cache hit or miss
+func (m *Manager) RecordCacheLookup(hit bool) {
+	if hit {
+		m.cacheHits.Add(1)
+	} else {
+		m.cacheMisses.Add(1)
+	}
+}
+
+// RecordSemanticCacheLookup counts a semantic cache hit or miss
+func (m *Manager) RecordSemanticCacheLookup(hit bool) {
+	if hit {
+		m.semanticHits.Add(1)
+	} else {
+		m.semanticMisses.Add(1)
+	}
+}
+
+// RecordCoalescedRequest counts a request that shared another's upstream call
+func (m *Manager) RecordCoalescedRequest() {
+	m.coalesced.Add(1)
+}
+
+// RecordEmbeddingBatch counts an upstream call merging embeddings requests
+func (m *Manager) RecordEmbeddingBatch(requests int) {
+	m.embeddingBatches.Add(1)
+	m.embeddingBatched.Add(int64(requests))
+}
+
+// EmbeddingBatchStats returns the number of batched upstream calls and the
+// requests they carried
+func (m *Manager) EmbeddingBatchStats() map[string]interface{} {
+	batches, requests := m.embeddingBatches.Load(), m.embeddingBatched.Load()
+	avgBatchSize := 0.0
+	if batches > 0 {
+		avgBatchSize = float64(requests) / float64(batches)
+	}
+	
+	return map[string]interface{}{
+		"batches":        batches,
+		"requests":       requests,
+		"avg_batch_size": avgBatchSize,
+	}
+}
+
+// RecordHedge counts a hedge request and whether it beat the original
+func (m *Manager) RecordHedge(won bool) {
+	m.hedgesSent.Add(1)
+	if won {
+		m.hedgeWins.Add(1)
+	}
+}
+
+// RecordHedgeSkipped counts a hedge withheld by the client's hedging budget
+func (m *Manager) RecordHedgeSkipped() {
+	m.hedgesSkipped.Add(1)
+}
+
+// HedgingStats returns hedge request counts and outcomes
+func (m *Manager) HedgingStats() map[string]interface{} {
+	sent, wins := m.hedgesSent.Load(), m.hedgeWins.Load()
+	return map[string]interface{}{
+		"hedges_sent":    sent,
+		"wins":           wins,
+		"losses":         sent - wins,
+		"budget_skipped": m.hedgesSkipped.Load(),
+	}
+}
+
+// CacheStats returns response cache hit and miss counts
+func (m *Manager) CacheStats() map[string]interface{} {
+	stats := cacheLookupStats(m.cacheHits.Load(), m.cacheMisses.Load())
+	stats["semantic"] = cacheLookupStats(m.semanticHits.Load(), m.semanticMisses.Load())
+	return stats
+}
+
+// cacheLookupStats formats hit and miss counts with the hit rate
+func cacheLookupStats(hits, misses int64) map[string]interface{} {
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses) * 100
+	}
+	
+	return map[string]interface{}{
+		"hits":             hits,
+		"misses":           misses,
+		"hit_rate_percent": hitRate,
+	}
+}
+
+// SelectBatchInstance selects an instance for new Files and Batch API objects
+func (m *Manager) SelectBatchInstance(ctx context.Context) (string, error) {
+	return m.selector.SelectBatchInstance(ctx)
+}
+
+// SaveAffinity records which instance holds an upstream object and which
+// client owns it
+func (m *Manager) SaveAffinity(ctx context.Context, kind, objectID, instanceName, owner string) error {
+	return m.configStore.SaveAffinity(ctx, kind, objectID, instanceName, owner)
+}
+
+// LookupAffinity returns the instance that holds an upstream object and its owner
+func (m *Manager) LookupAffinity(ctx context.Context, kind, objectID string) (string, string, error) {
+	return m.configStore.LoadAffinity(ctx, kind, objectID)
+}
+
+// DeleteAffinity forgets which instance owns an upstream object
+func (m *Manager) DeleteAffinity(ctx context.Context, kind, objectID string) error {
+	return m.configStore.DeleteAffinity(ctx, kind, objectID)
+}
+
+// TrackBatchJob stores the latest known state of a batch job
+func (m *Manager) TrackBatchJob(ctx context.Context, job *config.BatchJob) error {
+	return m.configStore.SaveBatchJob(ctx, job)
+}
+
+// ListBatchJobs returns all tracked batch jobs
+func (m *Manager) ListBatchJobs(ctx context.Context) ([]config.BatchJob, error) {
+	return m.configStore.ListBatchJobs(ctx)
+}
+
 // GetInstanceConfig returns the configuration for a specific instance
 func (m *Manager) GetInstanceConfig(instanceName string) (*config.InstanceConfig, error) {
 	m.mutex.RLock()
@@ -243,6 +557,16 @@ func (m *Manager) ResetInstance(ctx context.Context, instanceName string) error
 		}
 	}
 	
+	m.mutex.RLock()
+	unitLimiters := m.unitRateLimiters[instanceName]
+	m.mutex.RUnlock()
+	
+	for unit, unitLimiter := range unitLimiters {
+		if err := unitLimiter.Reset(ctx); err != nil {
+			return fmt.Errorf("failed to reset %s rate limiter: %w", unit, err)
+		}
+	}
+	
 	return nil
 }
 
@@ -252,13 +576,24 @@ func (m *Manager) GetStats(ctx context.Context) (map[string]interface{}, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get instance states: %w", err)
 	}
-	
 	stats := map[string]interface{}{
-		"total_instances":   len(m.configs),
-		"healthy_instances": 0,
-		"total_requests":    0,
-		"total_tokens":      int64(0),
-		"instances":         make(map[string]interface{}),
+		"total_instances":    len(m.configs),
+		"healthy_instances":  0,
+		"total_requests":     0,
+		"total_tokens":       int64(0),
+		"total_images":       0,
+		"cache":              m.CacheStats(),
+		"coalesced_requests": m.coalesced.Load(),
+		"embedding_batches":  m.EmbeddingBatchStats(),
+		"admission_queue":    m.AdmissionStats(),
+		"hedging":            m.HedgingStats(),
+		"spillover":          m.SpilloverStats(),
+		"prompt_cache":       m.PromptCacheStats(),
+		"outlier_detection":  m.OutlierStats(),
+		"instances":          make(map[string]interface{}),
+	}
+	if costs := m.monthCostTotals(ctx); costs != nil {
+		stats["costs"] = costs
 	}
 	
 	for _, state := range states {
@@ -267,18 +602,30 @@ func (m *Manager) GetStats(ctx context.Context) (map[string]interface{}, error)
 		}
 		stats["total_requests"] = stats["total_requests"].(int) + state.TotalRequests
 		stats["total_tokens"] = stats["total_tokens"].(int64) + state.TotalTokensServed
+		stats["total_images"] = stats["total_images"].(int) + state.TotalImagesGenerated
 		
 		instanceStats := map[string]interface{}{
-			"status":               state.Status,
-			"health_status":        state.HealthStatus,
-			"total_requests":       state.TotalRequests,
-			"successful_requests":  state.SuccessfulRequests,
-			"total_tokens_served":  state.TotalTokensServed,
-			"current_tpm":          state.CurrentTPM,
-			"current_rpm":          state.CurrentRPM,
-			"error_count":          state.ErrorCount,
-			"utilization_percent":  state.UtilizationPercentage,
-			"last_used":            state.LastUsed,
+			"status":                  state.Status,
+			"health_status":           state.HealthStatus,
+			"total_requests":          state.TotalRequests,
+			"successful_requests":     state.SuccessfulRequests,
+			"cancelled_requests":      state.CancelledRequests,
+			"total_tokens_served":     state.TotalTokensServed,
+			"current_tpm":             state.CurrentTPM,
+			"current_rpm":             state.CurrentRPM,
+			"error_count":             state.ErrorCount,
+			"utilization_percent":     state.UtilizationPercentage,
+			"last_used":               state.LastUsed,
+			"total_images":            state.TotalImagesGenerated,
+			"images_by_size":          state.ImagesBySizeQuality,
+			"total_audio_seconds":     state.TotalAudioSeconds,
+			"total_characters":        state.TotalSpeechCharacters,
+			"realtime_sessions":       m.ActiveRealtimeSessions(state.Name),
+			"total_realtime_sessions": state.TotalRealtimeSessions,
+			"realtime_text_tokens":    state.RealtimeTextTokens,
+			"realtime_audio_tokens":   state.RealtimeAudioTokens,
+			"latency":                 m.latencyStats(state.Name),
+			"drain":                   m.DrainStatus(state.Name),
 		}
 		
 		stats["instances"].(map[string]interface{})[state.Name] = instanceStats
@@ -300,6 +647,13 @@ func (m *Manager) Close() error {
 			errors = append(errors, fmt.Errorf("failed to close rate limiter for %s: %w", name, err))
 		}
 	}
+	for name, unitLimiters := range m.unitRateLimiters {
+		for unit, rateLimiter := range unitLimiters {
+			if err := rateLimiter.Close(); err != nil {
+				errors = append(errors, fmt.Errorf("failed to close %s rate limiter for %s: %w", unit, name, err))
+			}
+		}
+	}
 	
 	// Close storage connections
 	if err := m.stateStore.Close(); err != nil {
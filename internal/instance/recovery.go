@@ -0,0 +1,318 @@
+package instance
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/config"
+	
+	"github.com/sirupsen/logrus"
+)
+
+// instanceRecovery tracks an instance's recent outcomes for outlier detection
+// and when it last came back into rotation for slow start
+type instanceRecovery struct {
+	observed     bool      // health was seen at least once
+	healthy      bool      // health at the last selection
+	recoveredAt  time.Time // last unhealthy to healthy transition
+	requests     int       // requests in the current interval
+	failures     int       // 5xx and transport failures in the current interval
+	ejectedUntil time.Time // skipped by selection until then
+	ejections    int       // consecutive ejections, lowered by each interval without one
+}
+
+// recoveryTracker keeps slow start and outlier ejection state per instance
+type recoveryTracker struct {
+	instances     map[string]*instanceRecovery
+	lastEvaluated time.Time
+	ejections     int64 // ejections since startup
+	mutex         sync.Mutex
+}
+
+// newRecoveryTracker creates a new recovery tracker
+func newRecoveryTracker() *recoveryTracker {
+	return &recoveryTracker{
+		instances: make(map[string]*instanceRecovery),
+	}
+}
+
+// instance returns the instance's recovery state, creating it on first use.
+// The caller must hold the mutex.
+func (rt *recoveryTracker) instance(instanceName string) *instanceRecovery {
+	recovery, exists := rt.instances[instanceName]
+	if !exists {
+		recovery = &instanceRecovery{}
+		rt.instances[instanceName] = recovery
+	}
+	return recovery
+}
+
+// observeHealth records an instance's health as seen by selection, starting
+// its slow start window when it turns healthy again
+func (m *Manager) observeHealth(instanceName string, healthy bool) {
+	m.recovery.mutex.Lock()
+	defer m.recovery.mutex.Unlock()
+	
+	recovery := m.recovery.instance(instanceName)
+	if recovery.observed && !recovery.healthy && healthy {
+		recovery.recoveredAt = time.Now()
+	}
+	recovery.observed = true
+	recovery.healthy = healthy
+}
+
+// RecordRequestResult counts a request to an instance for outlier detection.
+// Only server errors and failed connections count as failures; client errors
+// and rate limiting say nothing about the instance's health.
+func (m *Manager) RecordRequestResult(instanceName string, failed bool) {
+	if !m.GetRoutingConfig().OutlierDetection.Enabled {
+		return
+	}
+	
+	m.recovery.mutex.Lock()
+	defer m.recovery.mutex.Unlock()
+	
+	recovery := m.recovery.instance(instanceName)
+	recovery.requests++
+	if failed {
+		recovery.failures++
+	}
+}
+
+// RecordLateFailure turns a request already counted as successful by
+// RecordRequestResult into a failure, e.g. a stream that died after the
+// upstream accepted it
+func (m *Manager) RecordLateFailure(instanceName string) {
+	if !m.GetRoutingConfig().OutlierDetection.Enabled {
+		return
+	}
+	
+	m.recovery.mutex.Lock()
+	defer m.recovery.mutex.Unlock()
+	
+	recovery := m.recovery.instance(instanceName)
+	recovery.failures++
+	// The success may have been counted in an earlier interval
+	if recovery.requests < recovery.failures {
+		recovery.requests = recovery.failures
+	}
+}
+
+// isEjected reports whether outlier detection has taken an instance out of rotation
+func (m *Manager) isEjected(instanceName string) bool {
+	m.recovery.mutex.Lock()
+	defer m.recovery.mutex.Unlock()
+	
+	recovery, exists := m.recovery.instances[instanceName]
+	return exists && time.Now().Before(recovery.ejectedUntil)
+}
+
+// evaluateOutliers ejects instances whose error rate or p95 latency over the
+// last interval deviates from their peers, the instances sharing a model with
+// them. It runs at most once per interval, from instance selection.
+func (m *Manager) evaluateOutliers() {
+	detection := m.GetRoutingConfig().OutlierDetection
+	if !detection.Enabled {
+		return
+	}
+	
+	m.recovery.mutex.Lock()
+	defer m.recovery.mutex.Unlock()
+	
+	now := time.Now()
+	if m.recovery.lastEvaluated.IsZero() {
+		m.recovery.lastEvaluated = now
+		return
+	}
+	if now.Sub(m.recovery.lastEvaluated) < detection.GetInterval() {
+		return
+	}
+	m.recovery.lastEvaluated = now
+	
+	var configs []config.InstanceConfig
+	ejected := 0
+	for _, cfg := range m.GetAllConfigs() {
+		if !cfg.Enabled {
+			continue
+		}
+		configs = append(configs, cfg)
+		if now.Before(m.recovery.instance(cfg.Name).ejectedUntil) {
+			ejected++
+		}
+	}
+	maxEjected := int(float64(len(configs)) * detection.GetMaxEjectionPercent() / 100)
+	
+	// p95 latencies of the instances in rotation
+	p95 := make(map[string]time.Duration)
+	for _, cfg := range configs {
+		if results, ok := m.latencies.percentiles(cfg.Name, 95); ok {
+			p95[cfg.Name] = results[0]
+		}
+	}
+	
+	var outliers []string
+	for _, cfg := range configs {
+		recovery := m.recovery.instance(cfg.Name)
+		if now.Before(recovery.ejectedUntil) {
+			continue
+		}
+		
+		peerRequests, peerFailures := 0, 0
+		var peerLatencies []time.Duration
+		for _, peer := range configs {
+			if peer.Name == cfg.Name || !sharesModel(cfg, peer) || now.Before(m.recovery.instance(peer.Name).ejectedUntil) {
+				continue
+			}
+			peerRequests += m.recovery.instance(peer.Name).requests
+			peerFailures += m.recovery.instance(peer.Name).failures
+			if latency, ok := p95[peer.Name]; ok {
+				peerLatencies = append(peerLatencies, latency)
+			}
+		}
+		
+		reason := ""
+		if recovery.requests >= detection.GetMinRequests() {
+			errorRate := float64(recovery.failures) / float64(recovery.requests) * 100
+			peerErrorRate := 0.0
+			if peerRequests > 0 {
+				peerErrorRate = float64(peerFailures) / float64(peerRequests) * 100
+			}
+			if errorRate-peerErrorRate >= detection.GetErrorRateDeviation() {
+				reason = "error_rate"
+			}
+		}
+		if latency, ok := p95[cfg.Name]; ok && reason == "" && len(peerLatencies) > 0 {
+			if float64(latency) > detection.GetLatencyFactor()*float64(medianDuration(peerLatencies)) {
+				reason = "latency"
+			}
+		}
+		if reason != "" {
+			outliers = append(outliers, cfg.Name)
+			logrus.WithFields(logrus.Fields{
+				"instance": cfg.Name,
+				"reason":   reason,
+				"requests": recovery.requests,
+				"failures": recovery.failures,
+			}).Debug("Outlier instance detected")
+		}
+	}
+	
+	ejectedNow := make(map[string]bool)
+	for _, name := range outliers {
+		if ejected >= maxEjected {
+			logrus.WithField("instance", name).Warn("Outlier instance not ejected, too many instances already ejected")
+			break
+		}
+		recovery := m.recovery.instance(name)
+		recovery.ejections++
+		duration := detection.GetEjectionDuration(recovery.ejections)
+		recovery.ejectedUntil = now.Add(duration)
+		m.latencies.reset(name)
+		m.recovery.ejections++
+		ejected++
+		ejectedNow[name] = true
+		logrus.WithFields(logrus.Fields{
+			"instance": name,
+			"duration": duration,
+		}).Warn("Ejected outlier instance")
+	}
+	
+	// Start the next interval, forgiving one earlier ejection of each instance
+	// that behaved during this one
+	for name, recovery := range m.recovery.instances {
+		if !ejectedNow[name] && recovery.ejections > 0 && !now.Before(recovery.ejectedUntil) {
+			recovery.ejections--
+		}
+		recovery.requests = 0
+		recovery.failures = 0
+	}
+}
+
+// sharesModel reports whether two instances serve any model in common
+func sharesModel(a, b config.InstanceConfig) bool {
+	for _, modelA := range a.SupportedModels {
+		for _, modelB := range b.SupportedModels {
+			if strings.EqualFold(modelA, modelB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// medianDuration returns the median of the durations
+func medianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// slowStartFactor returns the share of its weight an instance gets while it
+// ramps up after recovering or returning from ejection, 1 once the window ended
+func (m *Manager) slowStartFactor(instanceName string) float64 {
+	slowStart := m.GetRoutingConfig().SlowStart
+	if !slowStart.Enabled {
+		return 1
+	}
+	
+	m.recovery.mutex.Lock()
+	recovery, exists := m.recovery.instances[instanceName]
+	var start time.Time
+	if exists {
+		start = recovery.recoveredAt
+		if recovery.ejectedUntil.After(start) {
+			start = recovery.ejectedUntil
+		}
+	}
+	m.recovery.mutex.Unlock()
+	
+	elapsed := time.Since(start)
+	if start.IsZero() || elapsed < 0 || elapsed >= slowStart.GetWindow() {
+		return 1
+	}
+	return math.Max(slowStart.GetMinWeightPercent()/100, float64(elapsed)/float64(slowStart.GetWindow()))
+}
+
+// OutlierStats returns ejection counts and the instances currently ejected or
+// ramping up after recovery
+func (m *Manager) OutlierStats() map[string]interface{} {
+	m.recovery.mutex.Lock()
+	names := make([]string, 0, len(m.recovery.instances))
+	for name := range m.recovery.instances {
+		names = append(names, name)
+	}
+	total := m.recovery.ejections
+	m.recovery.mutex.Unlock()
+	
+	now := time.Now()
+	instances := make(map[string]interface{})
+	for _, name := range names {
+		m.recovery.mutex.Lock()
+		recovery := *m.recovery.instances[name]
+		m.recovery.mutex.Unlock()
+		
+		ejected := now.Before(recovery.ejectedUntil)
+		factor := m.slowStartFactor(name)
+		if !ejected && factor == 1 && recovery.ejections == 0 {
+			continue
+		}
+		instances[name] = map[string]interface{}{
+			"ejected":               ejected,
+			"ejected_until":         recovery.ejectedUntil,
+			"consecutive_ejections": recovery.ejections,
+			"weight_factor":         factor,
+		}
+	}
+	
+	return map[string]interface{}{
+		"ejections": total,
+		"instances": instances,
+	}
+}
@@ -0,0 +1,93 @@
+package instance
+
+import (
+	"strings"
+	
+	"azure-openai-proxy/internal/config"
+)
+
+// modelRegistry resolves model aliases and fallback chains
+type modelRegistry struct {
+	models    map[string]string   // lowercased name or alias -> model name
+	fallbacks map[string][]string // lowercased model name -> fallback model names in order
+}
+
+// newModelRegistry builds a registry from the configured models
+func newModelRegistry(models []config.ModelConfig) *modelRegistry {
+	registry := &modelRegistry{
+		models:    make(map[string]string),
+		fallbacks: make(map[string][]string),
+	}
+	for _, model := range models {
+		registry.models[strings.ToLower(model.Name)] = model.Name
+		for _, alias := range model.Aliases {
+			registry.models[strings.ToLower(alias)] = model.Name
+		}
+	}
+	
+	// Fallbacks may be given by alias
+	for _, model := range models {
+		modelLower := strings.ToLower(model.Name)
+		for _, fallback := range model.Fallbacks {
+			registry.fallbacks[modelLower] = append(registry.fallbacks[modelLower], registry.resolve(fallback))
+		}
+	}
+	return registry
+}
+
+// resolve returns the model an alias refers to, or the name itself
+func (r *modelRegistry) resolve(model string) string {
+	if resolved, exists := r.models[strings.ToLower(model)]; exists {
+		return resolved
+	}
+	return model
+}
+
+// SetModelRegistry sets the model aliases and fallback chains
+func (m *Manager) SetModelRegistry(models []config.ModelConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.models = newModelRegistry(models)
+}
+
+// ResolveModel returns the model a requested name refers to. Names that are
+// not registered are returned unchanged.
+func (m *Manager) ResolveModel(model string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	if m.models == nil {
+		return model
+	}
+	return m.models.resolve(model)
+}
+
+// ModelFallbacks returns the models to try, in order, when a model has no
+// instance with capacity
+func (m *Manager) ModelFallbacks(model string) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	if m.models == nil {
+		return nil
+	}
+	return m.models.fallbacks[strings.ToLower(model)]
+}
+
+// ModelAliases returns the registered aliases and the models they refer to
+func (m *Manager) ModelAliases() map[string]string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	aliases := make(map[string]string)
+	if m.models == nil {
+		return aliases
+	}
+	for name, model := range m.models.models {
+		if name != strings.ToLower(model) {
+			aliases[name] = model
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,120 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	
+	"azure-openai-proxy/internal/config"
+)
+
+// RequestRoute describes the parts of a request routing rules match on,
+// other than the model
+type RequestRoute struct {
+	Endpoint   string
+	Client     string // client key name, empty when client keys are not configured
+	Headers    http.Header
+	SessionKey string // routes the consistent_hash strategy, empty when the request has none
+}
+
+// requestRouteKey is the context key of the request's route
+type requestRouteKey struct{}
+
+// WithRequestRoute returns a context carrying the request's route for instance
+// selection
+func WithRequestRoute(ctx context.Context, route RequestRoute) context.Context {
+	return context.WithValue(ctx, requestRouteKey{}, route)
+}
+
+// RequestRouteFromContext returns the route attached to the context, if any
+func RequestRouteFromContext(ctx context.Context) (RequestRoute, bool) {
+	route, ok := ctx.Value(requestRouteKey{}).(RequestRoute)
+	return route, ok
+}
+
+// matchRoutingRule returns the first routing rule matching a request for the
+// model, or nil if none does
+func (m *Manager) matchRoutingRule(ctx context.Context, model string) *config.RoutingRule {
+	rules := m.GetRoutingConfig().Rules
+	if len(rules) == 0 {
+		return nil
+	}
+	
+	route, _ := RequestRouteFromContext(ctx)
+	for i := range rules {
+		if ruleMatches(rules[i].Match, model, route) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// RoutingRuleName returns the name of the routing rule applying to a request
+// for the model, or an empty string if none does
+func (m *Manager) RoutingRuleName(ctx context.Context, model string) string {
+	if rule := m.matchRoutingRule(ctx, model); rule != nil {
+		return rule.Name
+	}
+	return ""
+}
+
+// ruleMatches reports whether a request satisfies every condition of a rule
+func ruleMatches(match config.RoutingRuleMatch, model string, route RequestRoute) bool {
+	if len(match.Models) > 0 && !containsFold(match.Models, model) {
+		return false
+	}
+	if len(match.Endpoints) > 0 && !containsFold(match.Endpoints, route.Endpoint) {
+		return false
+	}
+	if len(match.Clients) > 0 && !containsFold(match.Clients, route.Client) {
+		return false
+	}
+	for name, value := range match.Headers {
+		if route.Headers == nil || route.Headers.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRoutingRule narrows the instances to the rule's candidates and
+// reorders their priorities by the rule's priority order
+func applyRoutingRule(rule *config.RoutingRule, configs []config.InstanceConfig) []config.InstanceConfig {
+	if rule == nil {
+		return configs
+	}
+	
+	candidates := make([]config.InstanceConfig, 0, len(configs))
+	for _, cfg := range configs {
+		if len(rule.Instances) > 0 && !containsFold(rule.Instances, cfg.Name) {
+			continue
+		}
+		
+		// Listed instances come first in the rule's order, the others keep
+		// their relative priority behind them
+		if len(rule.PriorityOrder) > 0 {
+			cfg.Priority += len(rule.PriorityOrder)
+			for position, name := range rule.PriorityOrder {
+				if strings.EqualFold(name, cfg.Name) {
+					cfg.Priority = position
+					break
+				}
+			}
+		}
+		candidates = append(candidates, cfg)
+	}
+	return candidates
+}
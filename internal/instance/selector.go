@@ -2,7 +2,9 @@ package instance
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"strings"
@@ -11,6 +13,10 @@ import (
 	"azure-openai-proxy/internal/config"
 )
 
+// ErrNoCapacity is returned when instances serve the model but none is both
+// healthy and within its rate limit
+var ErrNoCapacity = errors.New("no healthy instances with capacity found")
+
 // InstanceSelector implements different instance selection algorithms
 type InstanceSelector struct {
 	manager *Manager
@@ -31,8 +37,41 @@ type instanceWithState struct {
 
 // SelectInstanceForRequest selects the best instance for a given request
 func (is *InstanceSelector) SelectInstanceForRequest(ctx context.Context, model string, tokens int, providerType string) (string, error) {
-	// Get all instances (configs only first)
-	configs := is.manager.GetAllConfigs()
+	return is.selectInstance(ctx, model, tokens, providerType, nil)
+}
+
+// SelectInstanceExcluding selects the best instance that is not in exclude
+func (is *InstanceSelector) SelectInstanceExcluding(ctx context.Context, model string, tokens int, providerType string, exclude []string) (string, error) {
+	return is.selectInstance(ctx, model, tokens, providerType, func(cfg config.InstanceConfig) bool {
+		for _, name := range exclude {
+			if cfg.Name == name {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// SelectRealtimeInstance selects an instance with a free realtime session slot
+func (is *InstanceSelector) SelectRealtimeInstance(ctx context.Context, model string) (string, error) {
+	return is.selectInstance(ctx, model, 0, "azure", func(cfg config.InstanceConfig) bool {
+		return cfg.MaxRealtimeSessions == 0 || is.manager.ActiveRealtimeSessions(cfg.Name) < cfg.MaxRealtimeSessions
+	})
+}
+
+// selectInstance filters instances by model, health and capacity plus an
+// optional extra filter, then applies the routing strategy. A routing rule
+// matching the request may narrow the candidates and override the strategy.
+func (is *InstanceSelector) selectInstance(ctx context.Context, model string, tokens int, providerType string, filter func(config.InstanceConfig) bool) (string, error) {
+	is.manager.evaluateOutliers()
+	
+	// Get all instances (configs only first), narrowed by the matching rule
+	rule := is.manager.matchRoutingRule(ctx, model)
+	configs := applyRoutingRule(rule, is.manager.GetAllConfigs())
+	strategy := is.manager.routingStrategy
+	if rule != nil && rule.Strategy != "" {
+		strategy = rule.Strategy
+	}
 	
 	// Pre-filter by provider type, model support, and enabled status
 	filteredConfigs := make([]config.InstanceConfig, 0)
@@ -65,6 +104,15 @@ func (is *InstanceSelector) SelectInstanceForRequest(ctx context.Context, model
 			continue
 		}
 		
+		if filter != nil && !filter(cfg) {
+			continue
+		}
+		
+		// Skip drained and ejected instances
+		if is.manager.isDraining(cfg.Name) || is.manager.isEjected(cfg.Name) {
+			continue
+		}
+		
 		filteredConfigs = append(filteredConfigs, cfg)
 	}
 	
@@ -81,6 +129,7 @@ func (is *InstanceSelector) SelectInstanceForRequest(ctx context.Context, model
 		}
 		
 		// Skip unhealthy instances
+		is.manager.observeHealth(cfg.Name, state.IsHealthy())
 		if !state.IsHealthy() {
 			continue
 		}
@@ -98,21 +147,83 @@ func (is *InstanceSelector) SelectInstanceForRequest(ctx context.Context, model
 	}
 	
 	if len(eligibleInstances) == 0 {
-		return "", fmt.Errorf("no healthy instances with capacity found for model %s", model)
+		return "", fmt.Errorf("%w for model %s", ErrNoCapacity, model)
 	}
 	
-	// Apply routing strategy
-	strategy := is.manager.routingStrategy
+	return is.applyStrategy(ctx, strategy, model, eligibleInstances), nil
+}
+
+// SelectBatchInstance selects a healthy instance that serves the Files and Batch APIs
+func (is *InstanceSelector) SelectBatchInstance(ctx context.Context) (string, error) {
+	eligibleInstances := make([]instanceWithState, 0)
+	for _, cfg := range is.manager.GetAllConfigs() {
+		if !cfg.Enabled || !cfg.BatchEnabled || is.manager.isDraining(cfg.Name) {
+			continue
+		}
+		
+		state, err := is.manager.GetInstanceState(ctx, cfg.Name)
+		if err != nil || !state.IsHealthy() {
+			continue
+		}
+		
+		eligibleInstances = append(eligibleInstances, instanceWithState{
+			Config: cfg,
+			State:  *state,
+		})
+	}
+	
+	if len(eligibleInstances) == 0 {
+		return "", fmt.Errorf("no healthy batch-enabled instances found")
+	}
+	
+	return is.applyStrategy(ctx, is.manager.routingStrategy, "", eligibleInstances), nil
+}
+
+// applyStrategy picks one of the eligible instances for the model using the
+// routing strategy
+func (is *InstanceSelector) applyStrategy(ctx context.Context, strategy, model string, eligibleInstances []instanceWithState) string {
 	switch strategy {
 	case "failover":
-		return is.selectByFailover(eligibleInstances), nil
+		return is.selectByFailover(eligibleInstances)
 	case "weighted":
-		return is.selectByWeight(eligibleInstances), nil
+		return is.selectByWeight(eligibleInstances)
 	case "round_robin":
-		return is.selectByRoundRobin(eligibleInstances), nil
+		return is.selectByRoundRobin(eligibleInstances)
+	case "lowest_latency":
+		return is.selectByLowestLatency(is.withLiveMetrics(ctx, eligibleInstances))
+	case "lowest_utilization":
+		return is.selectByLowestUtilization(is.withLiveMetrics(ctx, eligibleInstances))
+	case "composite":
+		return is.selectByComposite(is.withLiveMetrics(ctx, eligibleInstances))
+	case "power_of_two":
+		return is.selectByPowerOfTwo(ctx, eligibleInstances)
+	case "spillover":
+		return is.selectBySpillover(eligibleInstances)
+	case "consistent_hash":
+		return is.selectByConsistentHash(ctx, eligibleInstances)
+	case "cheapest":
+		return is.selectByCheapest(model, eligibleInstances)
 	default:
-		return is.selectByFailover(eligibleInstances), nil
+		return is.selectByFailover(eligibleInstances)
+	}
+}
+
+// withLiveMetrics replaces the stored utilization and average latency of the
+// instances with the current rate limit window and the configured percentile
+// of their recent latencies, where those are available
+func (is *InstanceSelector) withLiveMetrics(ctx context.Context, instances []instanceWithState) []instanceWithState {
+	percentile := is.manager.GetRoutingConfig().GetLatencyPercentile()
+	for i := range instances {
+		name := instances[i].Config.Name
+		if utilization, ok := is.manager.Utilization(ctx, name); ok {
+			instances[i].State.UtilizationPercentage = utilization
+		}
+		if latency, ok := is.manager.LatencyPercentile(name, percentile); ok {
+			latencyMs := float64(latency) / float64(time.Millisecond)
+			instances[i].State.AvgLatencyMs = &latencyMs
+		}
 	}
+	return instances
 }
 
 // selectByFailover selects the instance with the highest priority (lowest number)
@@ -125,22 +236,25 @@ func (is *InstanceSelector) selectByFailover(instances []instanceWithState) stri
 	return instances[0].Config.Name
 }
 
-// selectByWeight selects an instance based on weighted random selection
+// selectByWeight selects an instance based on weighted random selection.
+// Instances in slow start count with part of their weight.
 func (is *InstanceSelector) selectByWeight(instances []instanceWithState) string {
 	// Calculate total weight
-	totalWeight := 0
-	for _, instance := range instances {
-		totalWeight += instance.Config.Weight
+	weights := make([]float64, len(instances))
+	totalWeight := 0.0
+	for i, instance := range instances {
+		weights[i] = float64(instance.Config.Weight) * is.manager.slowStartFactor(instance.Config.Name)
+		totalWeight += weights[i]
 	}
 	
 	// Generate random number
 	rand.Seed(time.Now().UnixNano())
-	target := rand.Intn(totalWeight)
+	target := rand.Float64() * totalWeight
 	
 	// Select instance based on weight
-	current := 0
-	for _, instance := range instances {
-		current += instance.Config.Weight
+	current := 0.0
+	for i, instance := range instances {
+		current += weights[i]
 		if current > target {
 			return instance.Config.Name
 		}
@@ -170,21 +284,16 @@ func (is *InstanceSelector) selectByLowestUtilization(instances []instanceWithSt
 	return instances[0].Config.Name
 }
 
-// selectByLowestLatency selects the instance with the lowest average latency
+// selectByLowestLatency selects the instance with the lowest latency
 func (is *InstanceSelector) selectByLowestLatency(instances []instanceWithState) string {
-	// Sort by average latency (lowest first)
-	sort.Slice(instances, func(i, j int) bool {
-		latencyI := float64(100) // Default latency if not available
-		if instances[i].State.AvgLatencyMs != nil {
-			latencyI = *instances[i].State.AvgLatencyMs
-		}
-		
-		latencyJ := float64(100)
-		if instances[j].State.AvgLatencyMs != nil {
-			latencyJ = *instances[j].State.AvgLatencyMs
+	// Sort by latency (lowest first). Instances without measurements go first
+	// so they get measured.
+	sort.SliceStable(instances, func(i, j int) bool {
+		latencyI, latencyJ := instances[i].State.AvgLatencyMs, instances[j].State.AvgLatencyMs
+		if latencyI == nil || latencyJ == nil {
+			return latencyI == nil && latencyJ != nil
 		}
-		
-		return latencyI < latencyJ
+		return *latencyI < *latencyJ
 	})
 	
 	return instances[0].Config.Name
@@ -192,6 +301,19 @@ func (is *InstanceSelector) selectByLowestLatency(instances []instanceWithState)
 
 // selectByComposite selects an instance using a composite scoring algorithm
 func (is *InstanceSelector) selectByComposite(instances []instanceWithState) string {
+	weights := is.manager.GetRoutingConfig().CompositeWeights.GetWeights()
+	
+	// Weight and latency are scored relative to the other candidates
+	maxWeight, fastestLatencyMs := 0, 0.0
+	for _, instance := range instances {
+		if instance.Config.Weight > maxWeight {
+			maxWeight = instance.Config.Weight
+		}
+		if latency := instance.State.AvgLatencyMs; latency != nil && (fastestLatencyMs == 0 || *latency < fastestLatencyMs) {
+			fastestLatencyMs = *latency
+		}
+	}
+	
 	// Calculate composite scores for each instance
 	type instanceScore struct {
 		instance instanceWithState
@@ -201,7 +323,7 @@ func (is *InstanceSelector) selectByComposite(instances []instanceWithState) str
 	scores := make([]instanceScore, len(instances))
 	
 	for i, instance := range instances {
-		score := is.calculateCompositeScore(instance)
+		score := is.calculateCompositeScore(instance, weights, maxWeight, fastestLatencyMs)
 		scores[i] = instanceScore{
 			instance: instance,
 			score:    score,
@@ -209,7 +331,7 @@ func (is *InstanceSelector) selectByComposite(instances []instanceWithState) str
 	}
 	
 	// Sort by score (higher is better)
-	sort.Slice(scores, func(i, j int) bool {
+	sort.SliceStable(scores, func(i, j int) bool {
 		return scores[i].score > scores[j].score
 	})
 	
@@ -217,36 +339,86 @@ func (is *InstanceSelector) selectByComposite(instances []instanceWithState) str
 }
 
 // calculateCompositeScore calculates a composite score for an instance
-func (is *InstanceSelector) calculateCompositeScore(instance instanceWithState) float64 {
+func (is *InstanceSelector) calculateCompositeScore(instance instanceWithState, weights config.CompositeWeights, maxWeight int, fastestLatencyMs float64) float64 {
 	score := 0.0
 	
 	// Weight factor (higher weight = better)
-	weightScore := float64(instance.Config.Weight) / 20.0 // Normalize to 0-1 range
-	score += weightScore * 0.3
+	weightScore := 1.0
+	if maxWeight > 0 {
+		weightScore = float64(instance.Config.Weight) / float64(maxWeight)
+	}
+	weightScore *= is.manager.slowStartFactor(instance.Config.Name)
+	score += weightScore * weights.Weight
 	
 	// Utilization factor (lower utilization = better)
-	utilizationScore := (100.0 - instance.State.UtilizationPercentage) / 100.0
-	score += utilizationScore * 0.4
+	utilization := math.Min(math.Max(instance.State.UtilizationPercentage, 0), 100)
+	utilizationScore := (100.0 - utilization) / 100.0
+	score += utilizationScore * weights.Utilization
 	
 	// Error rate factor (lower error rate = better)
 	errorRate := instance.State.CurrentErrorRate
-	errorScore := (100.0 - errorRate) / 100.0
-	score += errorScore * 0.2
+	if errorRate == 0 && instance.State.TotalRequests > 0 {
+		errorRate = float64(instance.State.ErrorCount) / float64(instance.State.TotalRequests) * 100
+	}
+	errorScore := (100.0 - math.Min(errorRate, 100)) / 100.0
+	score += errorScore * weights.ErrorRate
 	
-	// Latency factor (lower latency = better)
+	// Latency factor (lower latency = better); unmeasured instances score
+	// as the fastest so they get measured
 	latencyScore := 1.0
-	if instance.State.AvgLatencyMs != nil {
-		// Normalize latency: 0ms = 1.0, 1000ms = 0.0
-		latencyScore = 1.0 - (*instance.State.AvgLatencyMs / 1000.0)
-		if latencyScore < 0 {
-			latencyScore = 0
-		}
+	if latency := instance.State.AvgLatencyMs; latency != nil && *latency > 0 && fastestLatencyMs > 0 {
+		latencyScore = fastestLatencyMs / *latency
 	}
-	score += latencyScore * 0.1
+	score += latencyScore * weights.Latency
 	
 	return score
 }
 
+// selectByPowerOfTwo scores two randomly chosen instances and picks the better
+// one. In large pools this avoids fetching metrics for every instance and
+// sending all traffic to whichever one currently scores best.
+func (is *InstanceSelector) selectByPowerOfTwo(ctx context.Context, instances []instanceWithState) string {
+	if len(instances) <= 2 {
+		return is.selectByComposite(is.withLiveMetrics(ctx, instances))
+	}
+	
+	first := rand.Intn(len(instances))
+	second := rand.Intn(len(instances) - 1)
+	if second >= first {
+		second++
+	}
+	return is.selectByComposite(is.withLiveMetrics(ctx, []instanceWithState{instances[first], instances[second]}))
+}
+
+// selectBySpillover keeps provisioned-throughput instances busy first, in
+// priority order, and spills over to standard instances by weight once every
+// provisioned one is throttled or above the utilization threshold
+func (is *InstanceSelector) selectBySpillover(instances []instanceWithState) string {
+	var provisioned, saturated, standard []instanceWithState
+	for _, instance := range instances {
+		switch {
+		case !instance.Config.IsProvisioned():
+			standard = append(standard, instance)
+		case is.manager.provisionedSaturated(instance.Config.Name):
+			saturated = append(saturated, instance)
+		default:
+			provisioned = append(provisioned, instance)
+		}
+	}
+	
+	if len(provisioned) > 0 {
+		return is.selectByFailover(provisioned)
+	}
+	if len(standard) > 0 {
+		if len(saturated) > 0 {
+			is.manager.RecordSpillover(false)
+		}
+		return is.selectByWeight(standard)
+	}
+	// Without standard instances a saturated provisioned one is still better than none
+	return is.selectByFailover(saturated)
+}
+
 // GetEligibleInstances returns all instances eligible for a request
 func (is *InstanceSelector) GetEligibleInstances(ctx context.Context, model string, tokens int, providerType string) ([]instanceWithState, error) {
 	configs := is.manager.GetAllConfigs()
@@ -0,0 +1,132 @@
+package instance
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// provisionedLoad is the last known load of a provisioned-throughput instance
+type provisionedLoad struct {
+	utilization    float64   // azure-openai-deployment-utilization of the last response
+	reportedAt     time.Time // when the utilization was reported
+	throttledUntil time.Time // passed over until then after a 429
+}
+
+// spilloverTracker keeps the load of provisioned instances reported by Azure
+type spilloverTracker struct {
+	loads map[string]*provisionedLoad
+	mutex sync.Mutex
+}
+
+// newSpilloverTracker creates a new spillover tracker
+func newSpilloverTracker() *spilloverTracker {
+	return &spilloverTracker{
+		loads: make(map[string]*provisionedLoad),
+	}
+}
+
+// load returns the instance's load, creating it on first use. The caller must
+// hold the mutex.
+func (st *spilloverTracker) load(instanceName string) *provisionedLoad {
+	load, exists := st.loads[instanceName]
+	if !exists {
+		load = &provisionedLoad{}
+		st.loads[instanceName] = load
+	}
+	return load
+}
+
+// IsProvisioned reports whether the named instance is a provisioned-throughput deployment
+func (m *Manager) IsProvisioned(instanceName string) bool {
+	cfg, err := m.GetInstanceConfig(instanceName)
+	return err == nil && cfg.IsProvisioned()
+}
+
+// RecordDeploymentUtilization records the azure-openai-deployment-utilization
+// header of a response from a provisioned instance, e.g. "42.5%"
+func (m *Manager) RecordDeploymentUtilization(instanceName, header string) {
+	if header == "" || !m.IsProvisioned(instanceName) {
+		return
+	}
+	utilization, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(header), "%"), 64)
+	if err != nil {
+		return
+	}
+	
+	m.spillover.mutex.Lock()
+	defer m.spillover.mutex.Unlock()
+	
+	load := m.spillover.load(instanceName)
+	load.utilization = utilization
+	load.reportedAt = time.Now()
+}
+
+// RecordProvisionedThrottle passes over a provisioned instance that returned
+// 429 for retryAfter, or the configured cooldown if it gave none
+func (m *Manager) RecordProvisionedThrottle(instanceName string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = m.GetRoutingConfig().Spillover.GetCooldown()
+	}
+	
+	m.spillover.mutex.Lock()
+	defer m.spillover.mutex.Unlock()
+	
+	m.spillover.load(instanceName).throttledUntil = time.Now().Add(retryAfter)
+}
+
+// provisionedSaturated reports whether a provisioned instance recently
+// returned 429 or reported utilization at or above the threshold. Readings
+// older than the cooldown are ignored, as no traffic reaches a saturated
+// instance to refresh them.
+func (m *Manager) provisionedSaturated(instanceName string) bool {
+	spillover := m.GetRoutingConfig().Spillover
+	
+	m.spillover.mutex.Lock()
+	defer m.spillover.mutex.Unlock()
+	
+	load, exists := m.spillover.loads[instanceName]
+	if !exists {
+		return false
+	}
+	now := time.Now()
+	if now.Before(load.throttledUntil) {
+		return true
+	}
+	return now.Sub(load.reportedAt) < spillover.GetCooldown() && load.utilization >= spillover.GetUtilizationThreshold()
+}
+
+// RecordSpillover counts a request sent to a standard instance because the
+// provisioned ones were saturated, or resent after a provisioned one returned 429
+func (m *Manager) RecordSpillover(throttled bool) {
+	if throttled {
+		m.spillThrottled.Add(1)
+		return
+	}
+	m.spillSaturated.Add(1)
+}
+
+// SpilloverStats returns spillover counts and the load of provisioned instances
+func (m *Manager) SpilloverStats() map[string]interface{} {
+	m.spillover.mutex.Lock()
+	defer m.spillover.mutex.Unlock()
+	
+	now := time.Now()
+	instances := make(map[string]interface{})
+	for name, load := range m.spillover.loads {
+		instances[name] = map[string]interface{}{
+			"utilization_percent": load.utilization,
+			"reported_at":         load.reportedAt,
+			"throttled":           now.Before(load.throttledUntil),
+		}
+	}
+	
+	saturated, throttled := m.spillSaturated.Load(), m.spillThrottled.Load()
+	return map[string]interface{}{
+		"spillovers":  saturated + throttled,
+		"saturated":   saturated,
+		"after_429":   throttled,
+		"provisioned": instances,
+	}
+}
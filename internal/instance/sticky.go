@@ -0,0 +1,113 @@
+package instance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// selectByConsistentHash picks the instance with the highest weighted
+// rendezvous hash for the request's session key. A session stays on the same
+// instance while it is eligible, and when it is not only its sessions move.
+// Requests without a session key are routed by weight.
+func (is *InstanceSelector) selectByConsistentHash(ctx context.Context, instances []instanceWithState) string {
+	route, _ := RequestRouteFromContext(ctx)
+	if route.SessionKey == "" {
+		return is.selectByWeight(instances)
+	}
+	
+	best, bestScore := instances[0].Config.Name, math.Inf(-1)
+	for _, instance := range instances {
+		if score := rendezvousScore(route.SessionKey, instance.Config.Name, instance.Config.Weight); score > bestScore {
+			best, bestScore = instance.Config.Name, score
+		}
+	}
+	return best
+}
+
+// rendezvousScore returns the weighted rendezvous hash of a key for an
+// instance, so that each instance wins a share of keys proportional to its weight
+func rendezvousScore(key, instanceName string, weight int) float64 {
+	sum := sha256.Sum256([]byte(key + "\x00" + instanceName))
+	
+	// Map the hash into (0, 1)
+	unit := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(unit)
+}
+
+// promptCacheUsage counts prompt tokens and those served from Azure's prompt cache
+type promptCacheUsage struct {
+	requests     int64
+	hits         int64 // requests with any cached prompt tokens
+	promptTokens int64
+	cachedTokens int64
+}
+
+// stats returns the counts and the share of prompt tokens that were cached
+func (u *promptCacheUsage) stats() map[string]interface{} {
+	hitRate := 0.0
+	if u.promptTokens > 0 {
+		hitRate = float64(u.cachedTokens) / float64(u.promptTokens)
+	}
+	return map[string]interface{}{
+		"requests":      u.requests,
+		"hits":          u.hits,
+		"prompt_tokens": u.promptTokens,
+		"cached_tokens": u.cachedTokens,
+		"hit_rate":      hitRate,
+	}
+}
+
+// promptCacheTracker keeps prompt cache usage per instance
+type promptCacheTracker struct {
+	instances map[string]*promptCacheUsage
+	mutex     sync.Mutex
+}
+
+// newPromptCacheTracker creates a new prompt cache tracker
+func newPromptCacheTracker() *promptCacheTracker {
+	return &promptCacheTracker{
+		instances: make(map[string]*promptCacheUsage),
+	}
+}
+
+// RecordPromptCache records the prompt tokens of a response and how many of
+// them were served from the instance's prompt cache
+func (m *Manager) RecordPromptCache(instanceName string, promptTokens, cachedTokens int) {
+	m.promptCache.mutex.Lock()
+	defer m.promptCache.mutex.Unlock()
+	
+	usage, exists := m.promptCache.instances[instanceName]
+	if !exists {
+		usage = &promptCacheUsage{}
+		m.promptCache.instances[instanceName] = usage
+	}
+	usage.requests++
+	if cachedTokens > 0 {
+		usage.hits++
+	}
+	usage.promptTokens += int64(promptTokens)
+	usage.cachedTokens += int64(cachedTokens)
+}
+
+// PromptCacheStats returns the prompt cache hit rate overall and per instance
+func (m *Manager) PromptCacheStats() map[string]interface{} {
+	m.promptCache.mutex.Lock()
+	defer m.promptCache.mutex.Unlock()
+	
+	total := &promptCacheUsage{}
+	instances := make(map[string]interface{})
+	for name, usage := range m.promptCache.instances {
+		total.requests += usage.requests
+		total.hits += usage.hits
+		total.promptTokens += usage.promptTokens
+		total.cachedTokens += usage.cachedTokens
+		instances[name] = usage.stats()
+	}
+	
+	stats := total.stats()
+	stats["instances"] = instances
+	return stats
+}
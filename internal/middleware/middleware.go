@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"strings"
 	"time"
 	
+	"azure-openai-proxy/internal/config"
+	
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -89,6 +92,28 @@ func AdminAuth() gin.HandlerFunc {
 	}
 }
 
+// ClientIdentity identifies the calling client from its API key, so its
+// per-client settings apply. Requests without a known key are served
+// anonymously.
+func ClientIdentity(clients []config.ClientConfig) gin.HandlerFunc {
+	clientsByKey := make(map[string]*config.ClientConfig, len(clients))
+	for i := range clients {
+		clientsByKey[clients[i].APIKey] = &clients[i]
+	}
+	
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("api-key")
+		if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+		
+		if client, exists := clientsByKey[apiKey]; exists {
+			c.Set("client", client)
+		}
+		c.Next()
+	}
+}
+
 // Metrics middleware to collect request metrics
 func Metrics() gin.HandlerFunc {
 	return func(c *gin.Context) {
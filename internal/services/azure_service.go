@@ -7,18 +7,42 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 	
 	"azure-openai-proxy/internal/config"
 	"azure-openai-proxy/internal/errors"
+	
+	"github.com/gorilla/websocket"
+)
+
+// Oldest Azure API versions serving the Responses and Realtime APIs, and
+// accepting stream_options on chat completions
+const (
+	responsesAPIVersion   = "2025-03-01-preview"
+	realtimeAPIVersion    = "2024-10-01-preview"
+	streamUsageAPIVersion = "2024-09-01-preview"
 )
 
+// defaultAPIVersion is used for instances without a configured API version
+const defaultAPIVersion = "2024-05-01-preview"
+
+// RequestTracker counts upstream requests in flight per instance. The
+// function returned by BeginRequest is called once the request is over.
+type RequestTracker interface {
+	BeginRequest(instanceName string) func()
+}
+
 // AzureService handles communication with Azure OpenAI API
 type AzureService struct {
-	client *http.Client
-	config config.InstanceConfig
+	client       *http.Client
+	streamClient *http.Client // no overall timeout; streams are bounded by stream deadlines
+	config       config.InstanceConfig
+	tracker      RequestTracker // nil when requests are not tracked
 }
 
 // NewAzureService creates a new Azure OpenAI service client
@@ -27,9 +51,15 @@ func NewAzureService(cfg config.InstanceConfig) *AzureService {
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: false,
 		},
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.GetConnectTimeout(),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   cfg.GetConnectTimeout(),
+		ResponseHeaderTimeout: cfg.GetFirstByteTimeout(),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
 	}
 	
 	// Configure proxy if specified
@@ -40,22 +70,27 @@ func NewAzureService(cfg config.InstanceConfig) *AzureService {
 		}
 	}
 	
+	// timeout_seconds bounds the whole exchange of non-streaming requests
 	client := &http.Client{
 		Transport: transport,
-		Timeout:   time.Duration(cfg.TimeoutSeconds) * time.Second,
+		Timeout:   time.Duration(cfg.TimeoutSeconds * float64(time.Second)),
 	}
 	
 	return &AzureService{
-		client: client,
-		config: cfg,
+		client:       client,
+		streamClient: &http.Client{Transport: transport},
+		config:       cfg,
 	}
 }
 
+// SetRequestTracker counts the service's requests in flight until their
+// response bodies are closed
+func (as *AzureService) SetRequestTracker(tracker RequestTracker) {
+	as.tracker = tracker
+}
+
 // ProxyRequest sends a request to Azure OpenAI and returns the response
 func (as *AzureService) ProxyRequest(ctx context.Context, endpoint string, payload map[string]interface{}, deploymentName string) (*http.Response, error) {
-	// Build Azure URL
-	azureURL := as.buildAzureURL(endpoint, deploymentName)
-	
 	// Serialize payload
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
@@ -64,8 +99,38 @@ func (as *AzureService) ProxyRequest(ctx context.Context, endpoint string, paylo
 		})
 	}
 	
+	return as.sendRequest(ctx, endpoint, deploymentName, bytes.NewBuffer(jsonData), "application/json")
+}
+
+// ProxyRawRequest sends a pre-encoded body (e.g. multipart/form-data) to Azure
+// OpenAI. The body is streamed upstream as it is read.
+func (as *AzureService) ProxyRawRequest(ctx context.Context, endpoint string, body io.Reader, contentType string, deploymentName string) (*http.Response, error) {
+	return as.sendRequest(ctx, endpoint, deploymentName, body, contentType)
+}
+
+// ProxyResourceRequest forwards a request for a deployment-independent resource
+// (files, batches) with the given method and query string
+func (as *AzureService) ProxyResourceRequest(ctx context.Context, method, endpoint, rawQuery string, body io.Reader, contentType string) (*http.Response, error) {
+	azureURL := as.buildAzureURL(endpoint, "")
+	if rawQuery != "" {
+		azureURL += "&" + rawQuery
+	}
+	
+	return as.doRequest(ctx, as.client, method, endpoint, azureURL, "", body, contentType)
+}
+
+// sendRequest sends a POST request with the given body to the Azure endpoint
+func (as *AzureService) sendRequest(ctx context.Context, endpoint string, deploymentName string, body io.Reader, contentType string) (*http.Response, error) {
+	// Build Azure URL
+	azureURL := as.buildAzureURL(endpoint, deploymentName)
+	
+	return as.doRequest(ctx, as.client, "POST", endpoint, azureURL, deploymentName, body, contentType)
+}
+
+// doRequest creates and sends an authenticated request to Azure OpenAI
+func (as *AzureService) doRequest(ctx context.Context, client *http.Client, method, endpoint, azureURL, deploymentName string, body io.Reader, contentType string) (*http.Response, error) {
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, "POST", azureURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, azureURL, body)
 	if err != nil {
 		return nil, errors.NewInternalError("failed to create request", map[string]interface{}{
 			"error": err.Error(),
@@ -74,18 +139,32 @@ func (as *AzureService) ProxyRequest(ctx context.Context, endpoint string, paylo
 	}
 	
 	// Set headers
-	req.Header.Set("Content-Type", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	req.Header.Set("api-key", as.config.APIKey)
 	req.Header.Set("User-Agent", "Azure-OpenAI-Proxy/1.0")
 	
-	// Add any custom headers
-	if as.config.ProviderType == "azure" {
+	// Add any custom headers (speech and file content responses are not JSON)
+	if as.config.ProviderType == "azure" && endpoint != "/v1/audio/speech" && !strings.HasSuffix(endpoint, "/content") {
 		req.Header.Set("Accept", "application/json")
 	}
 	
 	// Send request
-	resp, err := as.client.Do(req)
+	done := func() {}
+	if as.tracker != nil {
+		done = as.tracker.BeginRequest(as.config.Name)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
+		done()
+		if kind := timeoutKind(err); kind != "" {
+			return nil, errors.NewUpstreamError("upstream "+kind+" timeout", 504, map[string]interface{}{
+				"timeout":    kind,
+				"url":        azureURL,
+				"deployment": deploymentName,
+			})
+		}
 		return nil, errors.NewUpstreamError("request to Azure OpenAI failed", 500, map[string]interface{}{
 			"error":      err.Error(),
 			"url":        azureURL,
@@ -93,15 +172,97 @@ func (as *AzureService) ProxyRequest(ctx context.Context, endpoint string, paylo
 		})
 	}
 	
+	if as.tracker != nil {
+		resp.Body = &trackedBody{ReadCloser: resp.Body, done: done}
+	}
 	return resp, nil
 }
 
-// StreamRequest sends a streaming request to Azure OpenAI
+// trackedBody ends the request's tracking when the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+// Close closes the body and marks the request as over
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// StreamRequest sends a streaming request to Azure OpenAI. Instead of the
+// request timeout, the stream is bounded by a per-chunk idle timeout and a
+// total deadline; reads past either fail with ErrStreamIdleTimeout or
+// ErrStreamDeadline.
 func (as *AzureService) StreamRequest(ctx context.Context, endpoint string, payload map[string]interface{}, deploymentName string) (*http.Response, error) {
 	// Ensure streaming is enabled
 	payload["stream"] = true
 	
-	return as.ProxyRequest(ctx, endpoint, payload, deploymentName)
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.NewInternalError("failed to marshal request payload", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	
+	deadline := newStreamDeadline(ctx, as.config.GetStreamIdleTimeout(), as.config.GetStreamTimeout())
+	azureURL := as.buildAzureURL(endpoint, deploymentName)
+	resp, err := as.doRequest(deadline.ctx, as.streamClient, "POST", endpoint, azureURL, deploymentName, bytes.NewBuffer(jsonData), "application/json")
+	if err != nil {
+		deadline.release()
+		if streamErr := deadline.translate(nil); streamErr != nil {
+			return nil, errors.NewUpstreamError(streamErr.Error(), 504, map[string]interface{}{
+				"url":        azureURL,
+				"deployment": deploymentName,
+			})
+		}
+		return nil, err
+	}
+	
+	resp.Body = &deadlineBody{body: resp.Body, deadline: deadline}
+	return resp, nil
+}
+
+// DialRealtime opens a WebSocket to the realtime deployment. On a failed
+// handshake the upstream HTTP response is returned for error reporting.
+func (as *AzureService) DialRealtime(ctx context.Context, deploymentName string) (*websocket.Conn, *http.Response, error) {
+	azureURL := as.buildAzureURL("/v1/realtime", deploymentName) + "&deployment=" + url.QueryEscape(deploymentName)
+	azureURL = "ws" + strings.TrimPrefix(azureURL, "http")
+	
+	// The handshake connects and then waits for the upgrade response, like the
+	// first byte of a request; without a first byte limit the request timeout applies
+	firstByteTimeout := as.config.GetFirstByteTimeout()
+	if firstByteTimeout == 0 {
+		firstByteTimeout = time.Duration(as.config.TimeoutSeconds * float64(time.Second))
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: as.config.GetConnectTimeout() + firstByteTimeout,
+	}
+	if as.config.ProxyURL != nil && *as.config.ProxyURL != "" {
+		if proxyURL, err := url.Parse(*as.config.ProxyURL); err == nil {
+			dialer.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	
+	header := http.Header{}
+	header.Set("api-key", as.config.APIKey)
+	header.Set("User-Agent", "Azure-OpenAI-Proxy/1.0")
+	
+	conn, resp, err := dialer.DialContext(ctx, azureURL, header)
+	if err != nil {
+		if resp != nil {
+			return nil, resp, err
+		}
+		return nil, nil, errors.NewUpstreamError("realtime connection to Azure OpenAI failed", 502, map[string]interface{}{
+			"error":      err.Error(),
+			"deployment": deploymentName,
+		})
+	}
+	
+	return conn, resp, nil
 }
 
 // buildAzureURL constructs the complete Azure OpenAI URL
@@ -122,19 +283,52 @@ func (as *AzureService) buildAzureURL(endpoint string, deploymentName string) st
 		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/completions", deploymentName)
 	case "/v1/embeddings":
 		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/embeddings", deploymentName)
+	case "/v1/images/generations":
+		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/images/generations", deploymentName)
+	case "/v1/audio/transcriptions":
+		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/audio/transcriptions", deploymentName)
+	case "/v1/audio/translations":
+		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/audio/translations", deploymentName)
+	case "/v1/audio/speech":
+		azureEndpoint = fmt.Sprintf("/openai/deployments/%s/audio/speech", deploymentName)
+	case "/v1/realtime":
+		// The deployment is passed as a query parameter by DialRealtime
+		azureEndpoint = "/openai/realtime"
 	default:
-		azureEndpoint = endpoint
+		// Deployment-independent resources live directly under /openai
+		if strings.HasPrefix(endpoint, "/v1/files") || strings.HasPrefix(endpoint, "/v1/batches") || strings.HasPrefix(endpoint, "/v1/responses") {
+			azureEndpoint = "/openai" + strings.TrimPrefix(endpoint, "/v1")
+		} else {
+			azureEndpoint = endpoint
+		}
 	}
 	
 	// Add API version
-	apiVersion := as.config.APIVersion
-	if apiVersion == "" {
-		apiVersion = "2024-05-01-preview"
+	apiVersion := as.apiVersion()
+	if strings.HasPrefix(endpoint, "/v1/responses") && apiVersion < responsesAPIVersion {
+		apiVersion = responsesAPIVersion
+	}
+	if endpoint == "/v1/realtime" && apiVersion < realtimeAPIVersion {
+		apiVersion = realtimeAPIVersion
 	}
 	
 	return fmt.Sprintf("%s%s?api-version=%s", baseURL, azureEndpoint, apiVersion)
 }
 
+// apiVersion returns the instance's configured API version, or the default
+func (as *AzureService) apiVersion() string {
+	if as.config.APIVersion != "" {
+		return as.config.APIVersion
+	}
+	return defaultAPIVersion
+}
+
+// SupportsStreamUsage reports whether the instance's API version accepts
+// stream_options, which asks for the usage chunk at the end of chat streams
+func (as *AzureService) SupportsStreamUsage() bool {
+	return as.apiVersion() >= streamUsageAPIVersion
+}
+
 // HealthCheck performs a health check against the Azure OpenAI endpoint
 func (as *AzureService) HealthCheck(ctx context.Context) error {
 	// Use a simple request to check health
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Errors reported when a streamed response is cut off by a deadline
+var (
+	ErrStreamIdleTimeout = errors.New("upstream stream idle timeout")
+	ErrStreamDeadline    = errors.New("upstream stream deadline exceeded")
+)
+
+// streamDeadline enforces a per-chunk idle timeout and a total deadline on a
+// streaming request by cancelling its context
+type streamDeadline struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	idle      time.Duration
+	timer     *time.Timer
+	idleFired atomic.Bool
+}
+
+// newStreamDeadline derives a context bounded by the total deadline. The idle
+// timer starts immediately, so it also bounds the wait for the first byte.
+func newStreamDeadline(parent context.Context, idle, total time.Duration) *streamDeadline {
+	ctx, cancel := context.WithTimeout(parent, total)
+	sd := &streamDeadline{
+		ctx:    ctx,
+		cancel: cancel,
+		idle:   idle,
+	}
+	sd.timer = time.AfterFunc(idle, func() {
+		sd.idleFired.Store(true)
+		cancel()
+	})
+	return sd
+}
+
+// touch restarts the idle timer after data arrived
+func (sd *streamDeadline) touch() {
+	if !sd.idleFired.Load() {
+		sd.timer.Reset(sd.idle)
+	}
+}
+
+// translate maps a context cancellation caused by a deadline to its stream error
+func (sd *streamDeadline) translate(err error) error {
+	switch {
+	case sd.idleFired.Load():
+		return ErrStreamIdleTimeout
+	case errors.Is(sd.ctx.Err(), context.DeadlineExceeded):
+		return ErrStreamDeadline
+	default:
+		return err
+	}
+}
+
+// release stops the timer and cancels the context
+func (sd *streamDeadline) release() {
+	sd.timer.Stop()
+	sd.cancel()
+}
+
+// deadlineBody wraps a streamed response body, resetting the idle timer on
+// every read and reporting deadline errors
+type deadlineBody struct {
+	body     io.ReadCloser
+	deadline *streamDeadline
+}
+
+// Read reads from the upstream body
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.deadline.touch()
+	}
+	if err != nil && err != io.EOF {
+		err = b.deadline.translate(err)
+	}
+	return n, err
+}
+
+// Close closes the body and releases the deadline timers
+func (b *deadlineBody) Close() error {
+	err := b.body.Close()
+	b.deadline.release()
+	return err
+}
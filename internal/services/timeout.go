@@ -0,0 +1,35 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// Kinds of upstream timeouts, as reported in error details
+const (
+	TimeoutConnect   = "connect"
+	TimeoutFirstByte = "first_byte"
+	TimeoutTotal     = "total"
+)
+
+// timeoutKind classifies a failed upstream call by the timeout that ended it,
+// returning "" if it did not time out
+func timeoutKind(err error) string {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return ""
+	}
+	
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return TimeoutConnect
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return TimeoutConnect
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return TimeoutFirstByte
+	default:
+		return TimeoutTotal
+	}
+}
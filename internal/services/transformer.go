@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 	
 	"azure-openai-proxy/internal/utils"
 )
@@ -27,6 +28,14 @@ type TransformResult struct {
 	RequiredTokens int                    `json:"required_tokens"`
 	Endpoint       string                 `json:"endpoint"`
 	Method         string                 `json:"method"`
+	
+	// Image generation is rate limited per image rather than per token
+	RequiredImages int    `json:"required_images,omitempty"`
+	ImageSize      string `json:"image_size,omitempty"`
+	ImageQuality   string `json:"image_quality,omitempty"`
+	
+	// Speech synthesis is rate limited per input character
+	RequiredCharacters int `json:"required_characters,omitempty"`
 }
 
 // TransformOpenAIToAzure transforms an OpenAI request to Azure OpenAI format
@@ -63,13 +72,27 @@ func (rt *RequestTransformer) TransformOpenAIToAzure(ctx context.Context, endpoi
 		}
 	}
 	
-	return &TransformResult{
+	result := &TransformResult{
 		OriginalModel:  strings.ToLower(modelName),
 		Payload:        azurePayload,
 		RequiredTokens: requiredTokens,
 		Endpoint:       endpoint,
 		Method:         "POST",
-	}, nil
+	}
+	
+	switch endpoint {
+	case "/v1/responses":
+		// The Responses API addresses the deployment in the body, not the URL
+		azurePayload["model"] = deploymentName
+	case "/v1/images/generations":
+		result.RequiredImages, result.ImageSize, result.ImageQuality = rt.estimateImages(azurePayload)
+	case "/v1/audio/speech":
+		if input, ok := azurePayload["input"].(string); ok {
+			result.RequiredCharacters = utf8.RuneCountInString(input)
+		}
+	}
+	
+	return result, nil
 }
 
 // TransformAzureToOpenAI transforms an Azure response back to OpenAI format
@@ -83,6 +106,18 @@ func (rt *RequestTransformer) TransformAzureToOpenAI(ctx context.Context, azureR
 	// Restore the original model name
 	openaiResponse["model"] = originalModel
 	
+	// Responses streaming events nest the response object
+	if response, ok := openaiResponse["response"].(map[string]interface{}); ok {
+		if _, hasModel := response["model"]; hasModel {
+			restored := make(map[string]interface{})
+			for k, v := range response {
+				restored[k] = v
+			}
+			restored["model"] = originalModel
+			openaiResponse["response"] = restored
+		}
+	}
+	
 	// Handle specific Azure response fields that need transformation
 	if choices, ok := openaiResponse["choices"].([]interface{}); ok {
 		for i, choice := range choices {
@@ -149,11 +184,131 @@ func (rt *RequestTransformer) estimateTokens(endpoint string, payload map[string
 		}
 		return 50, nil
 		
+	case "/v1/responses":
+		messages, functions := responsesToMessages(payload)
+		return rt.tokenEstimator.EstimateChatTokens(messages, functions, modelName, "azure")
+		
+	case "/v1/images/generations":
+		// Images do not consume TPM; capacity is counted per image instead
+		return 0, nil
+		
+	case "/v1/audio/speech":
+		// Speech is counted per input character instead of tokens
+		return 0, nil
+		
 	default:
 		return 100, nil // Minimum for unknown endpoints
 	}
 }
 
+// responsesToMessages converts a Responses API payload (instructions, input
+// items and tools) into chat messages and functions for token estimation
+func responsesToMessages(payload map[string]interface{}) ([]map[string]interface{}, []map[string]interface{}) {
+	var messages []map[string]interface{}
+	if instructions, ok := payload["instructions"].(string); ok && instructions != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": instructions})
+	}
+	
+	switch input := payload["input"].(type) {
+	case string:
+		messages = append(messages, map[string]interface{}{"role": "user", "content": input})
+	case []interface{}:
+		for _, item := range input {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			
+			switch itemMap["type"] {
+			case "function_call":
+				arguments, _ := itemMap["arguments"].(string)
+				name, _ := itemMap["name"].(string)
+				messages = append(messages, map[string]interface{}{"role": "assistant", "content": name + arguments})
+			case "function_call_output":
+				output, _ := itemMap["output"].(string)
+				messages = append(messages, map[string]interface{}{"role": "tool", "content": output})
+			default:
+				// Message items; content is a string or a list of input parts
+				role, _ := itemMap["role"].(string)
+				messages = append(messages, map[string]interface{}{"role": role, "content": responsesContent(itemMap["content"])})
+			}
+		}
+	}
+	
+	var functions []map[string]interface{}
+	if tools, ok := payload["tools"].([]interface{}); ok {
+		for _, tool := range tools {
+			if toolMap, ok := tool.(map[string]interface{}); ok && toolMap["type"] == "function" {
+				// Responses tools are flat rather than nested under "function"
+				functions = append(functions, toolMap)
+			}
+		}
+	}
+	
+	return messages, functions
+}
+
+// responsesContent maps Responses content parts onto the chat content shape
+func responsesContent(content interface{}) interface{} {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content
+	}
+	
+	mapped := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch partMap["type"] {
+		case "input_image":
+			mapped = append(mapped, map[string]interface{}{"type": "image_url", "detail": partMap["detail"]})
+		default:
+			// input_text and output_text carry a "text" field
+			mapped = append(mapped, map[string]interface{}{"type": "text", "text": partMap["text"]})
+		}
+	}
+	return mapped
+}
+
+// estimateImages returns the number, size and quality of images requested
+func (rt *RequestTransformer) estimateImages(payload map[string]interface{}) (int, string, string) {
+	count := 1
+	if n, ok := payload["n"].(float64); ok && n >= 1 {
+		count = int(n)
+	}
+	
+	size := "1024x1024"
+	if s, ok := payload["size"].(string); ok && s != "" {
+		size = s
+	}
+	
+	quality := "standard"
+	if q, ok := payload["quality"].(string); ok && q != "" {
+		quality = q
+	}
+	
+	return count, size, quality
+}
+
+// audioBytesPerSecond approximates compressed audio bitrate (128 kbps) for
+// estimating duration before the upstream reports the real value
+const audioBytesPerSecond = 16000
+
+// EstimateAudioSeconds estimates the duration of an uploaded audio file from its size
+func (rt *RequestTransformer) EstimateAudioSeconds(sizeBytes int64) int {
+	if sizeBytes <= 0 {
+		return 60 // Unknown size, assume one minute
+	}
+	
+	seconds := int(sizeBytes / audioBytesPerSecond)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
 // GetDeploymentName maps a model name to its deployment name
 func (rt *RequestTransformer) GetDeploymentName(modelName string, deployments map[string]string) string {
 	modelLower := strings.ToLower(modelName)
@@ -181,6 +336,11 @@ func (rt *RequestTransformer) GetDeploymentName(modelName string, deployments ma
 	return modelLower
 }
 
+// GetModelInfo returns token limits and capabilities for a model
+func (rt *RequestTransformer) GetModelInfo(modelName string) map[string]interface{} {
+	return rt.tokenEstimator.GetModelInfo(modelName)
+}
+
 // ValidateRequest validates a request payload
 func (rt *RequestTransformer) ValidateRequest(endpoint string, payload map[string]interface{}) error {
 	switch endpoint {
@@ -220,6 +380,31 @@ func (rt *RequestTransformer) ValidateRequest(endpoint string, payload map[strin
 		if _, ok := payload["input"]; !ok {
 			return fmt.Errorf("input field is required for embeddings")
 		}
+		
+	case "/v1/responses":
+		switch payload["input"].(type) {
+		case string, []interface{}:
+		default:
+			return fmt.Errorf("input field is required for responses and must be a string or an array")
+		}
+		
+	case "/v1/images/generations":
+		if prompt, ok := payload["prompt"].(string); !ok || prompt == "" {
+			return fmt.Errorf("prompt field is required for image generations")
+		}
+		if n, ok := payload["n"]; ok {
+			if nFloat, ok := n.(float64); !ok || nFloat < 1 {
+				return fmt.Errorf("n must be a positive integer")
+			}
+		}
+		
+	case "/v1/audio/speech":
+		if input, ok := payload["input"].(string); !ok || input == "" {
+			return fmt.Errorf("input field is required for speech")
+		}
+		if _, ok := payload["voice"].(string); !ok {
+			return fmt.Errorf("voice field is required for speech")
+		}
 	}
 	
 	return nil
@@ -250,6 +435,24 @@ func (rt *RequestTransformer) CleanRequestMetadata(payload map[string]interface{
 	return cleaned
 }
 
+// EstimateRequestTokens estimates the prompt tokens of an OpenAI request
+func (rt *RequestTransformer) EstimateRequestTokens(endpoint string, payload map[string]interface{}) (int, error) {
+	modelName, _ := payload["model"].(string)
+	return rt.estimateTokens(endpoint, payload, modelName)
+}
+
+// EstimateOutputTokens estimates the tokens of generated text
+func (rt *RequestTransformer) EstimateOutputTokens(text, modelName string) int {
+	if text == "" {
+		return 0
+	}
+	tokens, err := rt.tokenEstimator.EstimateCompletionTokens(text, modelName, "azure")
+	if err != nil {
+		return 0
+	}
+	return tokens
+}
+
 // EstimateResponseTokens estimates response tokens based on request
 func (rt *RequestTransformer) EstimateResponseTokens(payload map[string]interface{}) int {
 	// Check max_tokens setting
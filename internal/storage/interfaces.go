@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"time"
+	
 	"azure-openai-proxy/internal/config"
 )
 
@@ -46,6 +48,69 @@ type ConfigStore interface {
 	// ListInstanceConfigs returns all instance configuration names
 	ListInstanceConfigs(ctx context.Context) ([]string, error)
 	
+	// SaveAffinity records the instance that holds an upstream object (file,
+	// batch) and the client that created it ("" without client keys)
+	SaveAffinity(ctx context.Context, kind, objectID, instanceName, owner string) error
+	
+	// LoadAffinity returns the instance that holds an upstream object and its owner
+	LoadAffinity(ctx context.Context, kind, objectID string) (string, string, error)
+	
+	// DeleteAffinity removes the instance affinity of an upstream object
+	DeleteAffinity(ctx context.Context, kind, objectID string) error
+	
+	// SaveBatchJob stores the latest known state of a batch job
+	SaveBatchJob(ctx context.Context, job *config.BatchJob) error
+	
+	// ListBatchJobs returns all tracked batch jobs
+	ListBatchJobs(ctx context.Context) ([]config.BatchJob, error)
+	
+	// AddCostUsage adds the usage of requests to the totals of its month,
+	// instance, model and client
+	AddCostUsage(ctx context.Context, usage *config.CostUsage) error
+	
+	// ListCostUsage returns the usage totals of all months
+	ListCostUsage(ctx context.Context) ([]config.CostUsage, error)
+	
 	// Close closes the storage connection
 	Close() error
-}
\ No newline at end of file
+}
+
+// ResponseCache defines the interface for storing cached upstream responses
+type ResponseCache interface {
+	// Get returns a cached response, or nil if there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+	
+	// Set stores a response for the given time
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	
+	// Close closes the storage connection
+	Close() error
+}
+
+// VectorMatch is the most similar entry found in a VectorIndex
+type VectorMatch struct {
+	ID       string
+	Score    float64 // cosine similarity
+	Response []byte
+}
+
+// VectorIndex defines the interface for the semantic cache's embedding index
+type VectorIndex interface {
+	// AddVector stores an embedding with the response it answered
+	AddVector(ctx context.Context, namespace string, vector []float32, response []byte, ttl time.Duration) error
+	
+	// SearchVectors returns the unexpired entry of a namespace most similar to
+	// the vector, or nil if the namespace is empty
+	SearchVectors(ctx context.Context, namespace string, vector []float32) (*VectorMatch, error)
+	
+	// DeleteVector removes a single entry, reporting whether it existed
+	DeleteVector(ctx context.Context, id string) (bool, error)
+	
+	// DeleteVectors removes all entries of a namespace and its sub-namespaces
+	// ("ns" covers "ns/model"), or every entry if namespace is empty,
+	// returning how many were removed
+	DeleteVectors(ctx context.Context, namespace string) (int, error)
+	
+	// CountVectors returns the number of unexpired entries per namespace
+	CountVectors(ctx context.Context) (map[string]int, error)
+}
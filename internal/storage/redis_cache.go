@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+	
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache implements ResponseCache using Redis. Entries expire by TTL and
+// the oldest are evicted once more than maxEntries are stored.
+type RedisCache struct {
+	client     *redis.Client
+	prefix     string
+	indexKey   string
+	maxEntries int
+}
+
+// NewRedisCache creates a new Redis-based response cache
+func NewRedisCache(redisURL, password string, maxEntries int) (*RedisCache, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+	
+	if password != "" {
+		opt.Password = password
+	}
+	
+	client := redis.NewClient(opt)
+	
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	
+	return &RedisCache{
+		client:     client,
+		prefix:     "proxy:cache:entry:",
+		indexKey:   "proxy:cache:index",
+		maxEntries: maxEntries,
+	}, nil
+}
+
+// Get returns a cached response, or nil if there is none
+func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get cached response from Redis: %w", err)
+	}
+	
+	return data, nil
+}
+
+// Set stores a response and evicts the oldest entries beyond the size limit
+func (r *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	
+	pipe := r.client.Pipeline()
+	pipe.Set(ctx, r.prefix+key, value, ttl)
+	pipe.ZAdd(ctx, r.indexKey, &redis.Z{
+		Score:  float64(now.Unix()),
+		Member: key,
+	})
+	
+	// Entries older than the TTL have expired on their own
+	pipe.ZRemRangeByScore(ctx, r.indexKey, "-inf", fmt.Sprintf("%d", now.Add(-ttl).Unix()))
+	count := pipe.ZCard(ctx, r.indexKey)
+	
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to set cached response in Redis: %w", err)
+	}
+	
+	excess := count.Val() - int64(r.maxEntries)
+	if excess <= 0 {
+		return nil
+	}
+	
+	evicted, err := r.client.ZPopMin(ctx, r.indexKey, excess).Result()
+	if err != nil {
+		return fmt.Errorf("failed to evict cached responses from Redis: %w", err)
+	}
+	
+	keys := make([]string, 0, len(evicted))
+	for _, entry := range evicted {
+		if member, ok := entry.Member.(string); ok {
+			keys = append(keys, r.prefix+member)
+		}
+	}
+	if len(keys) > 0 {
+		if err := r.client.Del(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("failed to evict cached responses from Redis: %w", err)
+		}
+	}
+	
+	return nil
+}
+
+// Close closes the Redis connection
+func (r *RedisCache) Close() error {
+	return r.client.Close()
+}
@@ -11,6 +11,7 @@ import (
 	
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -29,6 +30,45 @@ type ConfigRecord struct {
 	UpdatedAt time.Time
 }
 
+// AffinityRecord maps an upstream object (file, batch) to the instance that owns it
+type AffinityRecord struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"uniqueIndex:idx_affinity_object;not null"`
+	ObjectID  string `gorm:"uniqueIndex:idx_affinity_object;not null"`
+	Instance  string `gorm:"not null"`
+	Owner     string // client that created the object, empty without client keys
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BatchRecord stores the latest known state of a batch job
+type BatchRecord struct {
+	ID        uint   `gorm:"primaryKey"`
+	BatchID   string `gorm:"uniqueIndex;not null"`
+	Instance  string `gorm:"not null"`
+	Status    string `gorm:"index"`
+	Data      string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CostRecord stores the usage totals of a month, instance, model and client
+type CostRecord struct {
+	ID               uint   `gorm:"primaryKey"`
+	Month            string `gorm:"uniqueIndex:idx_cost_key;not null"`
+	Instance         string `gorm:"uniqueIndex:idx_cost_key;not null"`
+	Model            string `gorm:"uniqueIndex:idx_cost_key;not null"`
+	Client           string `gorm:"uniqueIndex:idx_cost_key;not null"`
+	Requests         int64
+	InputTokens      int64
+	CachedTokens     int64
+	OutputTokens     int64
+	Cost             float64
+	UnpricedRequests int64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
 // NewSQLiteStore creates a new SQLite-based config store
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
@@ -39,7 +79,7 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 	}
 	
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&ConfigRecord{})
+	err = db.AutoMigrate(&ConfigRecord{}, &AffinityRecord{}, &BatchRecord{}, &CostRecord{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
 	}
@@ -195,6 +235,197 @@ func (s *SQLiteStore) ListInstanceConfigs(ctx context.Context) ([]string, error)
 	return names, nil
 }
 
+// SaveAffinity records the instance that holds an upstream object and its owner
+func (s *SQLiteStore) SaveAffinity(ctx context.Context, kind, objectID, instanceName, owner string) error {
+	record := AffinityRecord{
+		Kind:     kind,
+		ObjectID: objectID,
+		Instance: instanceName,
+		Owner:    owner,
+	}
+	
+	err := s.db.WithContext(ctx).
+		Where("kind = ? AND object_id = ?", kind, objectID).
+		FirstOrCreate(&record).Error
+	
+	if err != nil {
+		return fmt.Errorf("failed to save %s affinity: %w", kind, err)
+	}
+	
+	// Update the instance and owner if record already existed
+	if record.Instance != instanceName || record.Owner != owner {
+		err = s.db.WithContext(ctx).
+			Model(&record).
+			Updates(map[string]interface{}{"instance": instanceName, "owner": owner}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update %s affinity: %w", kind, err)
+		}
+	}
+	
+	return nil
+}
+
+// LoadAffinity returns the instance that holds an upstream object and its owner
+func (s *SQLiteStore) LoadAffinity(ctx context.Context, kind, objectID string) (string, string, error) {
+	var record AffinityRecord
+	
+	err := s.db.WithContext(ctx).
+		Where("kind = ? AND object_id = ?", kind, objectID).
+		First(&record).Error
+	
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", "", fmt.Errorf("%s affinity not found: %s", kind, objectID)
+		}
+		return "", "", fmt.Errorf("failed to load %s affinity: %w", kind, err)
+	}
+	
+	return record.Instance, record.Owner, nil
+}
+
+// DeleteAffinity removes the instance affinity of an upstream object
+func (s *SQLiteStore) DeleteAffinity(ctx context.Context, kind, objectID string) error {
+	err := s.db.WithContext(ctx).
+		Where("kind = ? AND object_id = ?", kind, objectID).
+		Delete(&AffinityRecord{}).Error
+	
+	if err != nil {
+		return fmt.Errorf("failed to delete %s affinity: %w", kind, err)
+	}
+	
+	return nil
+}
+
+// SaveBatchJob stores the latest known state of a batch job
+func (s *SQLiteStore) SaveBatchJob(ctx context.Context, job *config.BatchJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch job: %w", err)
+	}
+	
+	record := BatchRecord{
+		BatchID:  job.ID,
+		Instance: job.Instance,
+		Status:   job.Status,
+		Data:     string(data),
+	}
+	
+	err = s.db.WithContext(ctx).
+		Where("batch_id = ?", job.ID).
+		FirstOrCreate(&record).Error
+	
+	if err != nil {
+		return fmt.Errorf("failed to save batch job: %w", err)
+	}
+	
+	// Update the data if record already existed
+	err = s.db.WithContext(ctx).
+		Model(&record).
+		Updates(map[string]interface{}{
+			"status": job.Status,
+			"data":   string(data),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update batch job: %w", err)
+	}
+	
+	return nil
+}
+
+// ListBatchJobs returns all tracked batch jobs, newest first
+func (s *SQLiteStore) ListBatchJobs(ctx context.Context) ([]config.BatchJob, error) {
+	var records []BatchRecord
+	
+	err := s.db.WithContext(ctx).
+		Order("created_at DESC").
+		Find(&records).Error
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to list batch jobs: %w", err)
+	}
+	
+	jobs := make([]config.BatchJob, 0, len(records))
+	for _, record := range records {
+		var job config.BatchJob
+		if err := json.Unmarshal([]byte(record.Data), &job); err != nil {
+			continue // Skip corrupt records
+		}
+		jobs = append(jobs, job)
+	}
+	
+	return jobs, nil
+}
+
+// AddCostUsage adds the usage of requests to the totals of its month,
+// instance, model and client in a single upsert, so concurrent requests and
+// proxies sharing the database do not lose updates
+func (s *SQLiteStore) AddCostUsage(ctx context.Context, usage *config.CostUsage) error {
+	record := CostRecord{
+		Month:            usage.Month,
+		Instance:         usage.Instance,
+		Model:            usage.Model,
+		Client:           usage.Client,
+		Requests:         usage.Requests,
+		InputTokens:      usage.InputTokens,
+		CachedTokens:     usage.CachedTokens,
+		OutputTokens:     usage.OutputTokens,
+		Cost:             usage.Cost,
+		UnpricedRequests: usage.UnpricedRequests,
+	}
+	
+	err := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "month"}, {Name: "instance"}, {Name: "model"}, {Name: "client"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"requests":          gorm.Expr("requests + excluded.requests"),
+				"input_tokens":      gorm.Expr("input_tokens + excluded.input_tokens"),
+				"cached_tokens":     gorm.Expr("cached_tokens + excluded.cached_tokens"),
+				"output_tokens":     gorm.Expr("output_tokens + excluded.output_tokens"),
+				"cost":              gorm.Expr("cost + excluded.cost"),
+				"unpriced_requests": gorm.Expr("unpriced_requests + excluded.unpriced_requests"),
+				"updated_at":        gorm.Expr("excluded.updated_at"),
+			}),
+		}).
+		Create(&record).Error
+	
+	if err != nil {
+		return fmt.Errorf("failed to save cost usage: %w", err)
+	}
+	
+	return nil
+}
+
+// ListCostUsage returns the usage totals of all months
+func (s *SQLiteStore) ListCostUsage(ctx context.Context) ([]config.CostUsage, error) {
+	var records []CostRecord
+	
+	err := s.db.WithContext(ctx).
+		Order("month, instance, model, client").
+		Find(&records).Error
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cost usage: %w", err)
+	}
+	
+	usage := make([]config.CostUsage, len(records))
+	for i, record := range records {
+		usage[i] = config.CostUsage{
+			Month:            record.Month,
+			Instance:         record.Instance,
+			Model:            record.Model,
+			Client:           record.Client,
+			Requests:         record.Requests,
+			InputTokens:      record.InputTokens,
+			CachedTokens:     record.CachedTokens,
+			OutputTokens:     record.OutputTokens,
+			Cost:             record.Cost,
+			UnpricedRequests: record.UnpricedRequests,
+		}
+	}
+	
+	return usage, nil
+}
+
 // Close closes the SQLite connection
 func (s *SQLiteStore) Close() error {
 	sqlDB, err := s.db.DB()
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	
+	"azure-openai-proxy/internal/utils"
+	
+	"gorm.io/gorm"
+)
+
+// newVectorID returns a random identifier for an index entry
+func newVectorID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "sc_" + hex.EncodeToString(buf)
+}
+
+// memoryVector is an entry of MemoryVectorIndex
+type memoryVector struct {
+	id        string
+	namespace string
+	vector    []float32
+	response  []byte
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// MemoryVectorIndex implements VectorIndex in process memory with brute-force
+// search. The oldest entries are evicted beyond maxEntries.
+type MemoryVectorIndex struct {
+	entries    []*memoryVector // oldest first
+	maxEntries int
+	mutex      sync.RWMutex
+}
+
+// NewMemoryVectorIndex creates a new in-memory vector index
+func NewMemoryVectorIndex(maxEntries int) *MemoryVectorIndex {
+	return &MemoryVectorIndex{
+		maxEntries: maxEntries,
+	}
+}
+
+// AddVector stores an embedding with the response it answered
+func (m *MemoryVectorIndex) AddVector(ctx context.Context, namespace string, vector []float32, response []byte, ttl time.Duration) error {
+	now := time.Now()
+	
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.entries = append(m.entries, &memoryVector{
+		id:        newVectorID(),
+		namespace: namespace,
+		vector:    vector,
+		response:  response,
+		createdAt: now,
+		expiresAt: now.Add(ttl),
+	})
+	
+	// Drop expired entries, then the oldest beyond the limit
+	live := m.entries[:0]
+	for _, entry := range m.entries {
+		if now.Before(entry.expiresAt) {
+			live = append(live, entry)
+		}
+	}
+	if excess := len(live) - m.maxEntries; excess > 0 {
+		live = live[excess:]
+	}
+	m.entries = live
+	
+	return nil
+}
+
+// SearchVectors returns the unexpired entry most similar to the vector
+func (m *MemoryVectorIndex) SearchVectors(ctx context.Context, namespace string, vector []float32) (*VectorMatch, error) {
+	now := time.Now()
+	
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	var best *VectorMatch
+	for _, entry := range m.entries {
+		if entry.namespace != namespace || !now.Before(entry.expiresAt) {
+			continue
+		}
+		score := utils.CosineSimilarity(vector, entry.vector)
+		if best == nil || score > best.Score {
+			best = &VectorMatch{ID: entry.id, Score: score, Response: entry.response}
+		}
+	}
+	
+	return best, nil
+}
+
+// DeleteVector removes a single entry
+func (m *MemoryVectorIndex) DeleteVector(ctx context.Context, id string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	for i, entry := range m.entries {
+		if entry.id == id {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteVectors removes all entries of a namespace and its sub-namespaces, or every entry
+func (m *MemoryVectorIndex) DeleteVectors(ctx context.Context, namespace string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	kept := m.entries[:0]
+	for _, entry := range m.entries {
+		if namespace != "" && entry.namespace != namespace && !strings.HasPrefix(entry.namespace, namespace+"/") {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(m.entries) - len(kept)
+	m.entries = kept
+	
+	return removed, nil
+}
+
+// CountVectors returns the number of unexpired entries per namespace
+func (m *MemoryVectorIndex) CountVectors(ctx context.Context) (map[string]int, error) {
+	now := time.Now()
+	
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	counts := make(map[string]int)
+	for _, entry := range m.entries {
+		if now.Before(entry.expiresAt) {
+			counts[entry.namespace]++
+		}
+	}
+	return counts, nil
+}
+
+// VectorRecord stores a semantic cache entry in SQLite
+type VectorRecord struct {
+	ID        uint      `gorm:"primaryKey"`
+	EntryID   string    `gorm:"uniqueIndex;not null"`
+	Namespace string    `gorm:"index;not null"`
+	Vector    []byte    `gorm:"not null"`
+	Response  []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// SQLiteVectorIndex implements VectorIndex on the SQLite database of a
+// SQLiteStore. Vectors are compared by brute force after loading a namespace.
+type SQLiteVectorIndex struct {
+	db         *gorm.DB
+	maxEntries int
+}
+
+// VectorIndex returns a vector index stored in the same database
+func (s *SQLiteStore) VectorIndex(maxEntries int) (*SQLiteVectorIndex, error) {
+	if err := s.db.AutoMigrate(&VectorRecord{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate vector index schema: %w", err)
+	}
+	
+	return &SQLiteVectorIndex{db: s.db, maxEntries: maxEntries}, nil
+}
+
+// AddVector stores an embedding with the response it answered
+func (s *SQLiteVectorIndex) AddVector(ctx context.Context, namespace string, vector []float32, response []byte, ttl time.Duration) error {
+	now := time.Now()
+	record := VectorRecord{
+		EntryID:   newVectorID(),
+		Namespace: namespace,
+		Vector:    utils.EncodeVector(vector),
+		Response:  response,
+		ExpiresAt: now.Add(ttl),
+	}
+	
+	if err := s.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return fmt.Errorf("failed to save vector: %w", err)
+	}
+	
+	// Drop expired entries, then the oldest beyond the limit
+	err := s.db.WithContext(ctx).
+		Where("expires_at <= ?", now).
+		Delete(&VectorRecord{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to expire vectors: %w", err)
+	}
+	
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&VectorRecord{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count vectors: %w", err)
+	}
+	if excess := int(count) - s.maxEntries; excess > 0 {
+		err = s.db.WithContext(ctx).
+			Where("id IN (?)", s.db.Model(&VectorRecord{}).Select("id").Order("id").Limit(excess)).
+			Delete(&VectorRecord{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to evict vectors: %w", err)
+		}
+	}
+	
+	return nil
+}
+
+// SearchVectors returns the unexpired entry most similar to the vector
+func (s *SQLiteVectorIndex) SearchVectors(ctx context.Context, namespace string, vector []float32) (*VectorMatch, error) {
+	var records []VectorRecord
+	
+	err := s.db.WithContext(ctx).
+		Where("namespace = ? AND expires_at > ?", namespace, time.Now()).
+		Find(&records).Error
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vectors: %w", err)
+	}
+	
+	var best *VectorMatch
+	for _, record := range records {
+		score := utils.CosineSimilarity(vector, utils.DecodeVector(record.Vector))
+		if best == nil || score > best.Score {
+			best = &VectorMatch{ID: record.EntryID, Score: score, Response: record.Response}
+		}
+	}
+	
+	return best, nil
+}
+
+// DeleteVector removes a single entry
+func (s *SQLiteVectorIndex) DeleteVector(ctx context.Context, id string) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Where("entry_id = ?", id).
+		Delete(&VectorRecord{})
+	
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete vector: %w", result.Error)
+	}
+	
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteVectors removes all entries of a namespace and its sub-namespaces, or every entry
+func (s *SQLiteVectorIndex) DeleteVectors(ctx context.Context, namespace string) (int, error) {
+	query := s.db.WithContext(ctx)
+	if namespace != "" {
+		query = query.Where("namespace = ? OR substr(namespace, 1, ?) = ?", namespace, len(namespace)+1, namespace+"/")
+	} else {
+		query = query.Where("1 = 1")
+	}
+	
+	result := query.Delete(&VectorRecord{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete vectors: %w", result.Error)
+	}
+	
+	return int(result.RowsAffected), nil
+}
+
+// CountVectors returns the number of unexpired entries per namespace
+func (s *SQLiteVectorIndex) CountVectors(ctx context.Context) (map[string]int, error) {
+	var rows []struct {
+		Namespace string
+		Count     int
+	}
+	
+	err := s.db.WithContext(ctx).
+		Model(&VectorRecord{}).
+		Select("namespace, COUNT(*) AS count").
+		Where("expires_at > ?", time.Now()).
+		Group("namespace").
+		Scan(&rows).Error
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to count vectors: %w", err)
+	}
+	
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Namespace] = row.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// SSEEvent is a single server-sent event
+type SSEEvent struct {
+	Event string // "event:" field, empty for unnamed events
+	ID    string // "id:" field
+	Retry string // "retry:" field
+	Data  string // "data:" lines joined with newlines
+}
+
+// Bytes serializes the event in SSE wire format, terminated by a blank line
+func (e *SSEEvent) Bytes() []byte {
+	var buf bytes.Buffer
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry != "" {
+		buf.WriteString("retry: " + e.Retry + "\n")
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// SSEReader parses server-sent events without a line length limit
+type SSEReader struct {
+	reader *bufio.Reader
+}
+
+// NewSSEReader creates a new SSE reader
+func NewSSEReader(r io.Reader) *SSEReader {
+	return &SSEReader{
+		reader: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+// Next returns the next event. It returns io.EOF once the stream ends
+// cleanly; an event still pending at EOF is returned first.
+func (r *SSEReader) Next() (*SSEEvent, error) {
+	event := &SSEEvent{}
+	hasFields := false
+	var data []string
+
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && hasFields {
+				event.Data = strings.Join(data, "\n")
+				return event, nil
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// A blank line dispatches the event
+		if line == "" {
+			if !hasFields {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		}
+
+		// Comments (e.g. keep-alives) are skipped
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			field, value = line[:idx], strings.TrimPrefix(line[idx+1:], " ")
+		}
+
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event.Event = value
+		case "id":
+			event.ID = value
+		case "retry":
+			event.Retry = value
+		default:
+			continue // Unknown fields are ignored
+		}
+		hasFields = true
+	}
+}
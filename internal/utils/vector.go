@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// CosineSimilarity returns the cosine similarity of two vectors, or 0 if
+// their lengths differ or either is zero
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// EncodeVector serializes a vector as little-endian float32 values
+func EncodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// DecodeVector parses a vector serialized by EncodeVector
+func DecodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}